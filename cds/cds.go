@@ -44,22 +44,10 @@ func Compute(controls, experiments *mat64.Dense, r float64) *mat64.Vector {
 		maxComponentsNum = rowCount - 1
 	}
 
-	scores, loadings, explainedVar := pca.NIPALS(&combined, maxComponentsNum,
-		1e5, 1e-4)
-
-	captured_variance := 0.0
-	desiredCols := 1
-	for ; desiredCols <= explainedVar.Len(); desiredCols++ {
-		captured_variance += explainedVar.At(desiredCols-1, 0)
-		if captured_variance > 0.999 {
-			break
-		}
-	}
-
-	scoresRows, _ := scores.Dims()
-	loadingsRows, _ := loadings.Dims()
-	scores = *mat64.DenseCopyOf(scores.View(0, 0, scoresRows, desiredCols))
-	loadings = *mat64.DenseCopyOf(loadings.View(0, 0, loadingsRows, desiredCols))
+	model, scores := pca.FitScores(&combined, maxComponentsNum, 1e5, 1e-4)
+	model = model.TruncateVariance(0.999)
+	loadings := model.Loadings
+	desiredCols := model.Components()
 
 	var meanvec mat64.Vector
 	meanvec.SubVec(mat.RowMeans(experiments), mat.RowMeans(controls))
@@ -97,7 +85,7 @@ func Compute(controls, experiments *mat64.Dense, r float64) *mat64.Vector {
 	var intermediate1, intermediate2, b mat64.Dense
 	intermediate1.Mul(loadings.T(), &meanvec)
 	intermediate2.Mul(&invMat, &intermediate1)
-	b.Mul(&loadings, &intermediate2)
+	b.Mul(loadings, &intermediate2)
 	norm := mat64.Norm(&b, 2)
 	b.Apply(func(i, j int, v float64) float64 {
 		return v / norm
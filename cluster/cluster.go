@@ -0,0 +1,59 @@
+// Copyright (C) 2017 JT Olds
+// See LICENSE for copying information
+
+// Package cluster provides k-means and agglomerative hierarchical clustering
+// of the rows of mmm matrices.
+package cluster
+
+import (
+	"sort"
+
+	"github.com/jtolds/golincs/mmm"
+)
+
+func dot(a, b []float32) (rv float64) {
+	b = b[:len(a)]
+	for i, v := range a {
+		rv += float64(v) * float64(b[i])
+	}
+	return rv
+}
+
+func squaredDistance(a []float32, b []float64) (rv float64) {
+	b = b[:len(a)]
+	for i, v := range a {
+		d := float64(v) - b[i]
+		rv += d * d
+	}
+	return rv
+}
+
+// Order returns row indexes grouped by cluster assignment, with clusters in
+// increasing order. Within a cluster, rows are ordered by key, ascending.
+// This is useful for writing a row order for mmmsort.
+func Order(assignments []int, key []float64) []int {
+	order := make([]int, len(assignments))
+	for i := range order {
+		order[i] = i
+	}
+	sort.SliceStable(order, func(i, j int) bool {
+		a, b := order[i], order[j]
+		if assignments[a] != assignments[b] {
+			return assignments[a] < assignments[b]
+		}
+		if key != nil {
+			return key[a] < key[b]
+		}
+		return false
+	})
+	return order
+}
+
+// OrderIds maps row indexes to the ids of h.
+func OrderIds(h *mmm.Handle, order []int) []mmm.Ident {
+	rv := make([]mmm.Ident, len(order))
+	for i, idx := range order {
+		rv[i] = h.RowIdByIdx(idx)
+	}
+	return rv
+}
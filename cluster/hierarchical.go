@@ -0,0 +1,334 @@
+// Copyright (C) 2017 JT Olds
+// See LICENSE for copying information
+
+package cluster
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"strconv"
+
+	"github.com/jtolds/golincs/mmm"
+)
+
+// Linkage is how the distance between two clusters is computed from the
+// distances between their members.
+type Linkage int
+
+const (
+	AverageLinkage Linkage = iota
+	CompleteLinkage
+)
+
+func ParseLinkage(name string) (Linkage, error) {
+	switch name {
+	case "average":
+		return AverageLinkage, nil
+	case "complete":
+		return CompleteLinkage, nil
+	}
+	return 0, fmt.Errorf("unknown linkage %q", name)
+}
+
+// Merge is one step of agglomerative clustering. Clusters are numbered like
+// scipy's linkage matrices: 0 through n-1 are the original rows, and the
+// cluster created by merge i is n+i.
+type Merge struct {
+	A, B     int
+	Distance float64
+	Size     int
+}
+
+// Dendrogram is the result of hierarchical clustering, with merges in
+// increasing order of distance.
+type Dendrogram struct {
+	Ids    []mmm.Ident
+	Merges []Merge
+}
+
+// condensed is an upper triangular distance matrix without the diagonal.
+type condensed struct {
+	n    int
+	data []float32
+}
+
+func (c *condensed) index(i, j int) int {
+	if i > j {
+		i, j = j, i
+	}
+	return i*c.n - i*(i+1)/2 + j - i - 1
+}
+
+func (c *condensed) get(i, j int) float64    { return float64(c.data[c.index(i, j)]) }
+func (c *condensed) set(i, j int, v float64) { c.data[c.index(i, j)] = float32(v) }
+
+// cosineDistances computes 1 - cosine similarity between every pair of rows.
+func cosineDistances(h *mmm.Handle, workers int) *condensed {
+	n := h.Rows()
+	prepared := make([][]float32, n)
+	mmm.ParallelRows(n, workers, func(idx int) {
+		prepared[idx] = make([]float32, h.Cols())
+		mmm.Cosine.PrepareRow(prepared[idx], h.RowByIdx(idx))
+	})
+	c := &condensed{n: n, data: make([]float32, n*(n-1)/2)}
+	mmm.ParallelRows(n, workers, func(i int) {
+		for j := i + 1; j < n; j++ {
+			c.set(i, j, 1-dot(prepared[i], prepared[j]))
+		}
+	})
+	return c
+}
+
+// Hierarchical performs agglomerative clustering of the rows of h on cosine
+// distance, using the nearest-neighbor chain algorithm. It needs memory for
+// n*(n-1)/2 float32 distances, so it is only suitable for up to tens of
+// thousands of rows.
+func Hierarchical(h *mmm.Handle, linkage Linkage, workers int) (
+	*Dendrogram, error) {
+	n := h.Rows()
+	if n == 0 {
+		return nil, fmt.Errorf("no rows to cluster")
+	}
+	if linkage != AverageLinkage && linkage != CompleteLinkage {
+		return nil, fmt.Errorf("unknown linkage %d", linkage)
+	}
+	d := cosineDistances(h, workers)
+
+	active := make([]bool, n)
+	sizes := make([]int, n)
+	for i := range active {
+		active[i] = true
+		sizes[i] = 1
+	}
+
+	// merges are recorded by slot. a merged cluster reuses the slot of one of
+	// its children, so slots are relabeled once all merges are sorted.
+	type slotMerge struct {
+		a, b     int
+		distance float64
+	}
+	merges := make([]slotMerge, 0, n-1)
+
+	chain := make([]int, 0, n)
+	for remaining := n; remaining > 1; {
+		if len(chain) == 0 {
+			for i, a := range active {
+				if a {
+					chain = append(chain, i)
+					break
+				}
+			}
+		}
+		a := chain[len(chain)-1]
+		prev := -1
+		if len(chain) >= 2 {
+			prev = chain[len(chain)-2]
+		}
+
+		best, best_dist := -1, math.Inf(1)
+		if prev >= 0 {
+			// prefer the previous chain element on ties so the chain terminates
+			best, best_dist = prev, d.get(a, prev)
+		}
+		for k := 0; k < n; k++ {
+			if !active[k] || k == a {
+				continue
+			}
+			if dist := d.get(a, k); dist < best_dist {
+				best, best_dist = k, dist
+			}
+		}
+
+		if best != prev {
+			chain = append(chain, best)
+			continue
+		}
+
+		// a and prev are reciprocal nearest neighbors. merge them into prev's
+		// slot.
+		chain = chain[:len(chain)-2]
+		merges = append(merges, slotMerge{a: prev, b: a, distance: best_dist})
+		for k := 0; k < n; k++ {
+			if !active[k] || k == a || k == prev {
+				continue
+			}
+			da, dp := d.get(a, k), d.get(prev, k)
+			var v float64
+			switch linkage {
+			case AverageLinkage:
+				v = (float64(sizes[a])*da + float64(sizes[prev])*dp) /
+					float64(sizes[a]+sizes[prev])
+			case CompleteLinkage:
+				v = math.Max(da, dp)
+			}
+			d.set(prev, k, v)
+		}
+		sizes[prev] += sizes[a]
+		active[a] = false
+		remaining--
+	}
+
+	sort.SliceStable(merges, func(i, j int) bool {
+		return merges[i].distance < merges[j].distance
+	})
+
+	// relabel slots into cluster numbers with a union-find over rows
+	parent := make([]int, n)
+	label := make([]int, n)
+	size := make([]int, n)
+	for i := range parent {
+		parent[i] = i
+		label[i] = i
+		size[i] = 1
+	}
+	var find func(i int) int
+	find = func(i int) int {
+		for parent[i] != i {
+			parent[i] = parent[parent[i]]
+			i = parent[i]
+		}
+		return i
+	}
+
+	dg := &Dendrogram{
+		Ids:    append([]mmm.Ident(nil), h.RowIds()...),
+		Merges: make([]Merge, 0, len(merges)),
+	}
+	for i, m := range merges {
+		ra, rb := find(m.a), find(m.b)
+		la, lb := label[ra], label[rb]
+		if la > lb {
+			la, lb = lb, la
+		}
+		parent[rb] = ra
+		size[ra] += size[rb]
+		label[ra] = n + i
+		dg.Merges = append(dg.Merges, Merge{
+			A: la, B: lb, Distance: m.distance, Size: size[ra]})
+	}
+	return dg, nil
+}
+
+func (dg *Dendrogram) children(node int) (a, b int, leaf bool) {
+	n := len(dg.Ids)
+	if node < n {
+		return 0, 0, true
+	}
+	m := dg.Merges[node-n]
+	return m.A, m.B, false
+}
+
+func (dg *Dendrogram) height(node int) float64 {
+	if node < len(dg.Ids) {
+		return 0
+	}
+	return dg.Merges[node-len(dg.Ids)].Distance
+}
+
+func (dg *Dendrogram) root() int { return len(dg.Ids) + len(dg.Merges) - 1 }
+
+// LeafOrder returns row indexes in the left-to-right order of the dendrogram's
+// leaves, which places similar rows next to each other.
+func (dg *Dendrogram) LeafOrder() []int {
+	order := make([]int, 0, len(dg.Ids))
+	stack := []int{dg.root()}
+	for len(stack) > 0 {
+		node := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+		a, b, leaf := dg.children(node)
+		if leaf {
+			order = append(order, node)
+			continue
+		}
+		stack = append(stack, b, a)
+	}
+	return order
+}
+
+// Cut assigns every row to one of k clusters by undoing the last k-1 merges.
+// Clusters are numbered in leaf order.
+func (dg *Dendrogram) Cut(k int) []int {
+	n := len(dg.Ids)
+	if k < 1 {
+		k = 1
+	}
+	if k > n {
+		k = n
+	}
+	// merges from keep on are undone; the nodes they joined are the clusters
+	keep := len(dg.Merges) - (k - 1)
+	assignments := make([]int, n)
+	cluster := -1
+	type frame struct {
+		node     int
+		aboveCut bool
+	}
+	stack := []frame{{node: dg.root(), aboveCut: true}}
+	for len(stack) > 0 {
+		f := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+		if f.aboveCut && (f.node < n || f.node-n < keep) {
+			cluster++
+			f.aboveCut = false
+		}
+		a, b, leaf := dg.children(f.node)
+		if leaf {
+			assignments[f.node] = cluster
+			continue
+		}
+		stack = append(stack, frame{b, f.aboveCut}, frame{a, f.aboveCut})
+	}
+	return assignments
+}
+
+// WriteNewick writes the dendrogram in Newick format, labeling leaves with
+// row ids and using the differences in merge distance as branch lengths.
+func (dg *Dendrogram) WriteNewick(w io.Writer) error {
+	var buf []byte
+	type frame struct {
+		node  int
+		state int
+	}
+	stack := []frame{{node: dg.root()}}
+	for len(stack) > 0 {
+		f := &stack[len(stack)-1]
+		a, b, leaf := dg.children(f.node)
+		if leaf {
+			buf = strconv.AppendUint(buf, uint64(dg.Ids[f.node]), 10)
+			f.state = 3
+		}
+		switch f.state {
+		case 0:
+			buf = append(buf, '(')
+			f.state = 1
+			stack = append(stack, frame{node: a})
+			continue
+		case 1:
+			buf = append(buf, ',')
+			f.state = 2
+			stack = append(stack, frame{node: b})
+			continue
+		case 2:
+			buf = append(buf, ')')
+		}
+		node := f.node
+		stack = stack[:len(stack)-1]
+		if len(stack) > 0 {
+			parent := stack[len(stack)-1].node
+			buf = append(buf, ':')
+			buf = strconv.AppendFloat(buf,
+				dg.height(parent)-dg.height(node), 'g', 6, 64)
+		}
+		if len(buf) > 1<<16 {
+			if _, err := w.Write(buf); err != nil {
+				return err
+			}
+			buf = buf[:0]
+		}
+	}
+	buf = append(buf, ";\n"...)
+	_, err := w.Write(buf)
+	return err
+}
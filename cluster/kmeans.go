@@ -0,0 +1,181 @@
+// Copyright (C) 2017 JT Olds
+// See LICENSE for copying information
+
+package cluster
+
+import (
+	"fmt"
+	"math"
+	"math/rand"
+
+	"github.com/jtolds/golincs/mmm"
+)
+
+type KMeansOptions struct {
+	K    int
+	Seed int64
+	// MaxIterations bounds the number of Lloyd iterations. If <= 0, 100 is
+	// used.
+	MaxIterations int
+	// Tolerance stops iterating once no centroid moves more than this
+	// (Euclidean) distance.
+	Tolerance float64
+	// Workers is the number of goroutines used for assignment. If <= 0,
+	// GOMAXPROCS is used.
+	Workers int
+}
+
+type KMeansResult struct {
+	// Assignments has the cluster index of every row.
+	Assignments []int
+	// Distances has the Euclidean distance of every row to its centroid.
+	Distances  []float64
+	Centroids  [][]float64
+	Iterations int
+	Converged  bool
+	// Inertia is the sum of squared distances of rows to their centroids.
+	Inertia float64
+}
+
+// kMeansPlusPlus picks initial centroids using k-means++ seeding: the first
+// uniformly at random, and each next one with probability proportional to
+// its squared distance from the nearest centroid chosen so far.
+func kMeansPlusPlus(h *mmm.Handle, k int, r *rand.Rand) [][]float64 {
+	toCentroid := func(row []float32) []float64 {
+		c := make([]float64, len(row))
+		for i, v := range row {
+			c[i] = float64(v)
+		}
+		return c
+	}
+
+	centroids := [][]float64{toCentroid(h.RowByIdx(r.Intn(h.Rows())))}
+	nearest := make([]float64, h.Rows())
+	for i := range nearest {
+		nearest[i] = math.Inf(1)
+	}
+	for len(centroids) < k {
+		last := centroids[len(centroids)-1]
+		var total float64
+		for i := range nearest {
+			d := squaredDistance(h.RowByIdx(i), last)
+			if d < nearest[i] {
+				nearest[i] = d
+			}
+			total += nearest[i]
+		}
+		if total == 0 {
+			// fewer distinct rows than clusters; duplicate a centroid
+			centroids = append(centroids, append([]float64(nil), last...))
+			continue
+		}
+		target := r.Float64() * total
+		chosen := len(nearest) - 1
+		for i, d := range nearest {
+			target -= d
+			if target < 0 {
+				chosen = i
+				break
+			}
+		}
+		centroids = append(centroids, toCentroid(h.RowByIdx(chosen)))
+	}
+	return centroids
+}
+
+// assign sets every row's assignment to its nearest centroid, and its
+// distance to the squared distance to that centroid.
+func assign(h *mmm.Handle, centroids [][]float64, assignments []int,
+	distances []float64, workers int) {
+	mmm.ParallelRows(h.Rows(), workers, func(idx int) {
+		row := h.RowByIdx(idx)
+		best, best_dist := 0, math.Inf(1)
+		for c, centroid := range centroids {
+			if d := squaredDistance(row, centroid); d < best_dist {
+				best, best_dist = c, d
+			}
+		}
+		assignments[idx] = best
+		distances[idx] = best_dist
+	})
+}
+
+// KMeans clusters the rows of h into opts.K clusters by Euclidean distance,
+// using Lloyd's algorithm with k-means++ seeding. The result is determined by
+// opts.Seed. On unit-normalized rows, Euclidean distance orders neighbors the
+// same way cosine similarity does.
+func KMeans(h *mmm.Handle, opts KMeansOptions) (*KMeansResult, error) {
+	if opts.K <= 0 || opts.K > h.Rows() {
+		return nil, fmt.Errorf("k must be between 1 and the number of rows")
+	}
+	max_iterations := opts.MaxIterations
+	if max_iterations <= 0 {
+		max_iterations = 100
+	}
+
+	r := rand.New(rand.NewSource(opts.Seed))
+	res := &KMeansResult{
+		Assignments: make([]int, h.Rows()),
+		Distances:   make([]float64, h.Rows()),
+		Centroids:   kMeansPlusPlus(h, opts.K, r),
+	}
+
+	for res.Iterations < max_iterations {
+		res.Iterations++
+
+		assign(h, res.Centroids, res.Assignments, res.Distances, opts.Workers)
+
+		sums := make([][]float64, opts.K)
+		counts := make([]int, opts.K)
+		for c := range sums {
+			sums[c] = make([]float64, h.Cols())
+		}
+		for idx, c := range res.Assignments {
+			counts[c]++
+			for col, v := range h.RowByIdx(idx) {
+				sums[c][col] += float64(v)
+			}
+		}
+
+		var max_shift float64
+		for c := range sums {
+			if counts[c] == 0 {
+				// empty cluster: restart it at the row furthest from its centroid
+				furthest := 0
+				for idx, d := range res.Distances {
+					if d > res.Distances[furthest] {
+						furthest = idx
+					}
+				}
+				for col, v := range h.RowByIdx(furthest) {
+					sums[c][col] = float64(v)
+				}
+				counts[c] = 1
+				res.Distances[furthest] = 0
+				max_shift = math.Inf(1)
+			}
+			var shift float64
+			for col := range sums[c] {
+				v := sums[c][col] / float64(counts[c])
+				d := v - res.Centroids[c][col]
+				shift += d * d
+				res.Centroids[c][col] = v
+			}
+			max_shift = math.Max(max_shift, math.Sqrt(shift))
+		}
+
+		if max_shift <= opts.Tolerance {
+			res.Converged = true
+			break
+		}
+	}
+
+	// final assignment against the final centroids
+	assign(h, res.Centroids, res.Assignments, res.Distances, opts.Workers)
+	for idx, d := range res.Distances {
+		res.Inertia += d
+		res.Distances[idx] = math.Sqrt(d)
+	}
+
+	return res, nil
+}
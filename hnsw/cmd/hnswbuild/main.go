@@ -0,0 +1,81 @@
+// Copyright (C) 2017 JT Olds
+// See LICENSE for copying information
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"time"
+
+	"github.com/jtolds/golincs/hnsw"
+	"github.com/jtolds/golincs/mmm"
+)
+
+var (
+	inputPath  = flag.String("i", "", "input path")
+	outputPath = flag.String("o", "",
+		"output path. defaults to the input path with .hnsw appended, which "+
+			"is where the web server looks for it")
+	m = flag.Int("m", 16,
+		"links per node per layer (twice this on the bottom layer)")
+	efConstruction = flag.Int("ef_construction", 200,
+		"candidate list size while building")
+	seed    = flag.Int64("seed", 0, "random seed")
+	queries = flag.Int("recall_queries", 100,
+		"number of random rows to measure recall with. 0 skips measuring")
+	recallK  = flag.Int("recall_k", 10, "number of neighbors to measure recall at")
+	recallEf = flag.Int("recall_ef", 100,
+		"search candidate list size to measure recall at")
+	workers = flag.Int("workers", 0,
+		"number of goroutines to use. defaults to GOMAXPROCS. only 1 builds "+
+			"the same graph from the same seed every time")
+)
+
+func main() {
+	flag.Parse()
+
+	if *inputPath == "" {
+		panic("input path (-i) required")
+	}
+	if *outputPath == "" {
+		*outputPath = hnsw.Path(*inputPath)
+	}
+
+	fh, err := mmm.Open(*inputPath)
+	if err != nil {
+		panic(err)
+	}
+	defer fh.Close()
+
+	start := time.Now()
+	idx, err := hnsw.Build(fh, hnsw.Options{
+		M:              *m,
+		EfConstruction: *efConstruction,
+		Seed:           *seed,
+		Workers:        *workers,
+	})
+	if err != nil {
+		panic(err)
+	}
+	fmt.Printf("built index over %d rows in %v: m=%d ef_construction=%d "+
+		"layers=%d\n", fh.Rows(), time.Since(start), idx.M, idx.EfConstruction,
+		idx.Layers())
+
+	if *queries > 0 {
+		start = time.Now()
+		recall := idx.MeasureRecall(*queries, *recallK, *recallEf, *seed,
+			*workers)
+		fmt.Printf("recall@%d at ef=%d over %d queries: %0.4f (%v)\n",
+			*recallK, *recallEf, *queries, recall, time.Since(start))
+	}
+
+	err = idx.SaveFile(*outputPath)
+	if err != nil {
+		panic(err)
+	}
+	err = fh.Close()
+	if err != nil {
+		panic(err)
+	}
+}
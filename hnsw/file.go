@@ -0,0 +1,166 @@
+// Copyright (C) 2017 JT Olds
+// See LICENSE for copying information
+
+package hnsw
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"hash/fnv"
+	"io"
+	"os"
+
+	"github.com/jtolds/golincs/mmm"
+	"github.com/spacemonkeygo/errors"
+)
+
+const magic = "HNSW"
+
+// Path returns where the index for the mmm file at path is kept by
+// convention.
+func Path(path string) string { return path + ".hnsw" }
+
+type header struct {
+	M, EfConstruction, Rows, Entry, MaxLayer uint32
+	Recall                                   float64
+	RowIdsHash                               uint64
+}
+
+func rowIdsHash(h *mmm.Handle) uint64 {
+	hash := fnv.New64a()
+	binary.Write(hash, binary.LittleEndian, h.RowIds())
+	return hash.Sum64()
+}
+
+// Save writes the graph to w. The row data isn't included, so Load needs the
+// same mmm file the index was built from.
+func (idx *Index) Save(w io.Writer) error {
+	bw := bufio.NewWriter(w)
+	_, err := bw.WriteString(magic)
+	if err != nil {
+		return err
+	}
+	err = binary.Write(bw, binary.LittleEndian, header{
+		M:              uint32(idx.M),
+		EfConstruction: uint32(idx.EfConstruction),
+		Rows:           uint32(len(idx.links)),
+		Entry:          uint32(idx.entry),
+		MaxLayer:       uint32(idx.maxLayer),
+		Recall:         idx.Recall,
+		RowIdsHash:     rowIdsHash(idx.h),
+	})
+	if err != nil {
+		return err
+	}
+	for _, layers := range idx.links {
+		err = bw.WriteByte(byte(len(layers) - 1))
+		if err != nil {
+			return err
+		}
+		for _, links := range layers {
+			err = binary.Write(bw, binary.LittleEndian, uint32(len(links)))
+			if err != nil {
+				return err
+			}
+			err = binary.Write(bw, binary.LittleEndian, links)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return bw.Flush()
+}
+
+// Load reads a graph written by Save for the rows of h.
+func Load(r io.Reader, h *mmm.Handle) (*Index, error) {
+	br := bufio.NewReader(r)
+	m := make([]byte, len(magic))
+	_, err := io.ReadFull(br, m)
+	if err != nil {
+		return nil, err
+	}
+	if string(m) != magic {
+		return nil, fmt.Errorf("not an hnsw index")
+	}
+	var hdr header
+	err = binary.Read(br, binary.LittleEndian, &hdr)
+	if err != nil {
+		return nil, err
+	}
+	if int(hdr.Rows) != h.Rows() || hdr.RowIdsHash != rowIdsHash(h) {
+		return nil, fmt.Errorf("hnsw index was built for a different matrix")
+	}
+	if hdr.Rows == 0 || hdr.Entry >= hdr.Rows {
+		return nil, fmt.Errorf("invalid hnsw index")
+	}
+
+	idx := &Index{
+		M:              int(hdr.M),
+		EfConstruction: int(hdr.EfConstruction),
+		Recall:         hdr.Recall,
+		h:              h,
+		links:          make([][][]uint32, hdr.Rows),
+		entry:          int(hdr.Entry),
+		maxLayer:       int(hdr.MaxLayer),
+	}
+	for node := range idx.links {
+		top, err := br.ReadByte()
+		if err != nil {
+			return nil, err
+		}
+		idx.links[node] = make([][]uint32, int(top)+1)
+		for layer := range idx.links[node] {
+			var count uint32
+			err = binary.Read(br, binary.LittleEndian, &count)
+			if err != nil {
+				return nil, err
+			}
+			if count > hdr.Rows {
+				return nil, fmt.Errorf("invalid hnsw index")
+			}
+			links := make([]uint32, count)
+			err = binary.Read(br, binary.LittleEndian, links)
+			if err != nil {
+				return nil, err
+			}
+			for _, n := range links {
+				if n >= hdr.Rows {
+					return nil, fmt.Errorf("invalid hnsw index")
+				}
+			}
+			idx.links[node][layer] = links
+		}
+	}
+	if len(idx.links[idx.entry])-1 != idx.maxLayer {
+		return nil, fmt.Errorf("invalid hnsw index")
+	}
+	return idx, nil
+}
+
+// SaveFile writes the graph to a file at path.
+func (idx *Index) SaveFile(path string) (err error) {
+	fh, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	err = idx.Save(fh)
+	var errs errors.ErrorGroup
+	errs.Add(err)
+	errs.Add(fh.Close())
+	err = errs.Finalize()
+	if err != nil {
+		os.Remove(path)
+	}
+	return err
+}
+
+// LoadFile reads a graph for the rows of h from a file at path.
+func LoadFile(path string, h *mmm.Handle) (*Index, error) {
+	fh, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer fh.Close()
+	return Load(fh, h)
+}
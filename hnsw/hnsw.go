@@ -0,0 +1,464 @@
+// Copyright (C) 2017 JT Olds
+// See LICENSE for copying information
+
+// Package hnsw implements a Hierarchical Navigable Small World graph index
+// (Malkov and Yashunin, 2016) for approximate maximum inner product search
+// over the rows of an mmm file. For unit length rows, that is cosine
+// similarity search.
+package hnsw
+
+import (
+	"container/heap"
+	"fmt"
+	"math"
+	"math/rand"
+	"runtime"
+	"sort"
+	"sync"
+	"sync/atomic"
+
+	"github.com/jtolds/golincs/mmm"
+)
+
+type Options struct {
+	// M is the number of links every node keeps per layer, and twice that on
+	// the bottom layer. If <= 0, 16 is used.
+	M int
+	// EfConstruction is the size of the candidate list while inserting.
+	// Higher values build a better graph more slowly. If <= 0, 200 is used.
+	EfConstruction int
+	Seed           int64
+	// Workers is the number of goroutines to insert with. If <= 0,
+	// GOMAXPROCS is used. Concurrent inserts see each other's links in
+	// whatever order they're scheduled, so only a single worker builds the
+	// same graph from the same seed every time.
+	Workers int
+}
+
+// Index is an HNSW graph over the rows of an mmm.Handle. Nodes are row
+// indexes. An Index is safe for concurrent searches once built.
+type Index struct {
+	M              int
+	EfConstruction int
+	// Recall is the measured recall of the index, or NaN if it hasn't been
+	// measured. See MeasureRecall.
+	Recall float64
+
+	h        *mmm.Handle
+	links    [][][]uint32 // links[node][layer]
+	entry    int
+	maxLayer int
+
+	// locks guard links while building and are nil afterwards.
+	locks    []sync.Mutex
+	entryMtx sync.Mutex
+
+	visitedPool sync.Pool
+}
+
+// Result is a single search hit.
+type Result struct {
+	Idx   int
+	Score float32
+}
+
+func dot(a, b []float32) float32 {
+	var s0, s1, s2, s3 float32
+	b = b[:len(a)]
+	i := 0
+	for ; i+4 <= len(a); i += 4 {
+		s0 += a[i] * b[i]
+		s1 += a[i+1] * b[i+1]
+		s2 += a[i+2] * b[i+2]
+		s3 += a[i+3] * b[i+3]
+	}
+	for ; i < len(a); i++ {
+		s0 += a[i] * b[i]
+	}
+	return (s0 + s1) + (s2 + s3)
+}
+
+// Build constructs an index over every row of h. h must stay open for as
+// long as the index is used.
+func Build(h *mmm.Handle, opts Options) (*Index, error) {
+	if h.Rows() == 0 {
+		return nil, fmt.Errorf("no rows to index")
+	}
+	idx := &Index{
+		M:              opts.M,
+		EfConstruction: opts.EfConstruction,
+		Recall:         math.NaN(),
+		h:              h,
+		links:          make([][][]uint32, h.Rows()),
+		locks:          make([]sync.Mutex, h.Rows()),
+	}
+	if idx.M <= 0 {
+		idx.M = 16
+	}
+	if idx.EfConstruction <= 0 {
+		idx.EfConstruction = 200
+	}
+
+	// layers are assigned up front so they only depend on the seed, and so
+	// every node's link lists exist before any other node can link to it.
+	r := rand.New(rand.NewSource(opts.Seed))
+	ml := 1 / math.Log(float64(idx.M))
+	for node := range idx.links {
+		layer := int(-math.Log(1-r.Float64()) * ml)
+		idx.links[node] = make([][]uint32, layer+1)
+	}
+
+	idx.maxLayer = len(idx.links[0]) - 1
+	workers := opts.Workers
+	if workers <= 0 {
+		workers = runtime.GOMAXPROCS(0)
+	}
+	var next int64 = 1
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				node := int(atomic.AddInt64(&next, 1) - 1)
+				if node >= h.Rows() {
+					return
+				}
+				idx.insert(node)
+			}
+		}()
+	}
+	wg.Wait()
+	idx.locks = nil
+	return idx, nil
+}
+
+// Layers returns the number of layers in the graph.
+func (idx *Index) Layers() int { return idx.maxLayer + 1 }
+
+func (idx *Index) maxLinks(layer int) int {
+	if layer == 0 {
+		return 2 * idx.M
+	}
+	return idx.M
+}
+
+func (idx *Index) neighbors(node, layer int) []uint32 {
+	if idx.locks == nil {
+		return idx.links[node][layer]
+	}
+	idx.locks[node].Lock()
+	rv := append([]uint32(nil), idx.links[node][layer]...)
+	idx.locks[node].Unlock()
+	return rv
+}
+
+func (idx *Index) insert(node int) {
+	query := idx.h.RowByIdx(node)
+	layer := len(idx.links[node]) - 1
+
+	idx.entryMtx.Lock()
+	entry, max_layer := idx.entry, idx.maxLayer
+	if layer > max_layer {
+		// hold the lock until this node is linked in and can become the entry
+		// point
+		defer idx.entryMtx.Unlock()
+	} else {
+		idx.entryMtx.Unlock()
+	}
+
+	visited := idx.getVisited()
+	defer idx.visitedPool.Put(visited)
+
+	cur := Result{Idx: entry, Score: dot(query, idx.h.RowByIdx(entry))}
+	for l := max_layer; l > layer; l-- {
+		cur = idx.greedy(query, cur, l)
+	}
+	entries := []Result{cur}
+	for l := min(layer, max_layer); l >= 0; l-- {
+		found := idx.searchLayer(query, entries, idx.EfConstruction, l, visited)
+		selected := idx.selectNeighbors(found, idx.maxLinks(l))
+		links := make([]uint32, 0, len(selected))
+		for _, n := range selected {
+			links = append(links, uint32(n.Idx))
+		}
+		idx.locks[node].Lock()
+		idx.links[node][l] = links
+		idx.locks[node].Unlock()
+		for _, n := range selected {
+			idx.link(n.Idx, node, l)
+		}
+		entries = found
+	}
+
+	if layer > max_layer {
+		idx.entry, idx.maxLayer = node, layer
+	}
+}
+
+// link adds a link from node to other at layer, pruning node's links if it
+// has too many.
+func (idx *Index) link(node, other, layer int) {
+	idx.locks[node].Lock()
+	defer idx.locks[node].Unlock()
+	links := append(idx.links[node][layer], uint32(other))
+	if len(links) <= idx.maxLinks(layer) {
+		idx.links[node][layer] = links
+		return
+	}
+	row := idx.h.RowByIdx(node)
+	candidates := make([]Result, 0, len(links))
+	for _, n := range links {
+		candidates = append(candidates,
+			Result{Idx: int(n), Score: dot(row, idx.h.RowByIdx(int(n)))})
+	}
+	sortResults(candidates)
+	selected := idx.selectNeighbors(candidates, idx.maxLinks(layer))
+	links = links[:0]
+	for _, n := range selected {
+		links = append(links, uint32(n.Idx))
+	}
+	idx.links[node][layer] = links
+}
+
+// selectNeighbors picks up to m of candidates, which must be sorted best
+// first, using the heuristic from the HNSW paper: a candidate is skipped if
+// it is more similar to an already selected neighbor than to the query, which
+// keeps links pointing in diverse directions. Skipped candidates fill any
+// remaining slots.
+func (idx *Index) selectNeighbors(candidates []Result, m int) []Result {
+	if len(candidates) <= m {
+		return candidates
+	}
+	selected := make([]Result, 0, m)
+	var skipped []Result
+	for _, c := range candidates {
+		if len(selected) >= m {
+			break
+		}
+		row := idx.h.RowByIdx(c.Idx)
+		keep := true
+		for _, s := range selected {
+			if dot(row, idx.h.RowByIdx(s.Idx)) > c.Score {
+				keep = false
+				break
+			}
+		}
+		if keep {
+			selected = append(selected, c)
+		} else {
+			skipped = append(skipped, c)
+		}
+	}
+	for _, c := range skipped {
+		if len(selected) >= m {
+			break
+		}
+		selected = append(selected, c)
+	}
+	return selected
+}
+
+func (idx *Index) greedy(query []float32, cur Result, layer int) Result {
+	for changed := true; changed; {
+		changed = false
+		for _, n := range idx.neighbors(cur.Idx, layer) {
+			score := dot(query, idx.h.RowByIdx(int(n)))
+			if score > cur.Score {
+				cur = Result{Idx: int(n), Score: score}
+				changed = true
+			}
+		}
+	}
+	return cur
+}
+
+// visitedSet marks visited nodes with an epoch so it can be reused without
+// clearing.
+type visitedSet struct {
+	marks []uint32
+	epoch uint32
+}
+
+func (idx *Index) getVisited() *visitedSet {
+	if v, ok := idx.visitedPool.Get().(*visitedSet); ok {
+		return v
+	}
+	return &visitedSet{marks: make([]uint32, len(idx.links))}
+}
+
+func (v *visitedSet) reset() {
+	v.epoch++
+	if v.epoch == 0 {
+		for i := range v.marks {
+			v.marks[i] = 0
+		}
+		v.epoch = 1
+	}
+}
+
+func (v *visitedSet) visit(node int) (first bool) {
+	if v.marks[node] == v.epoch {
+		return false
+	}
+	v.marks[node] = v.epoch
+	return true
+}
+
+// resultHeap is a heap of results. If worstFirst is true, the lowest score
+// is on top, otherwise the highest.
+type resultHeap struct {
+	items      []Result
+	worstFirst bool
+}
+
+func (h *resultHeap) Len() int      { return len(h.items) }
+func (h *resultHeap) Swap(i, j int) { h.items[i], h.items[j] = h.items[j], h.items[i] }
+func (h *resultHeap) Less(i, j int) bool {
+	if h.worstFirst {
+		return h.items[i].Score < h.items[j].Score
+	}
+	return h.items[i].Score > h.items[j].Score
+}
+func (h *resultHeap) Push(x interface{}) { h.items = append(h.items, x.(Result)) }
+func (h *resultHeap) Pop() (x interface{}) {
+	x, h.items = h.items[len(h.items)-1], h.items[:len(h.items)-1]
+	return x
+}
+
+func sortResults(results []Result) {
+	sort.Slice(results, func(i, j int) bool {
+		if results[i].Score != results[j].Score {
+			return results[i].Score > results[j].Score
+		}
+		return results[i].Idx < results[j].Idx
+	})
+}
+
+// searchLayer returns up to ef of the best nodes found by a best-first
+// search of a single layer, best first.
+func (idx *Index) searchLayer(query []float32, entries []Result, ef,
+	layer int, visited *visitedSet) []Result {
+	visited.reset()
+
+	candidates := &resultHeap{}
+	results := &resultHeap{worstFirst: true}
+	for _, e := range entries {
+		visited.visit(e.Idx)
+		heap.Push(candidates, e)
+		heap.Push(results, e)
+		if results.Len() > ef {
+			heap.Pop(results)
+		}
+	}
+
+	for candidates.Len() > 0 {
+		c := heap.Pop(candidates).(Result)
+		if results.Len() >= ef && c.Score < results.items[0].Score {
+			break
+		}
+		for _, n := range idx.neighbors(c.Idx, layer) {
+			if !visited.visit(int(n)) {
+				continue
+			}
+			score := dot(query, idx.h.RowByIdx(int(n)))
+			if results.Len() < ef || score > results.items[0].Score {
+				r := Result{Idx: int(n), Score: score}
+				heap.Push(candidates, r)
+				heap.Push(results, r)
+				if results.Len() > ef {
+					heap.Pop(results)
+				}
+			}
+		}
+	}
+
+	rv := results.items
+	sortResults(rv)
+	return rv
+}
+
+// Search returns up to ef rows with the highest dot product with query,
+// best first. ef trades speed for recall and is raised to k if smaller. Only
+// the first k results are returned if k > 0.
+func (idx *Index) Search(query []float32, k, ef int) []Result {
+	if ef < k {
+		ef = k
+	}
+	if ef <= 0 {
+		ef = 1
+	}
+	visited := idx.getVisited()
+	defer idx.visitedPool.Put(visited)
+
+	cur := Result{Idx: idx.entry, Score: dot(query, idx.h.RowByIdx(idx.entry))}
+	for l := idx.maxLayer; l > 0; l-- {
+		cur = idx.greedy(query, cur, l)
+	}
+	results := idx.searchLayer(query, []Result{cur}, ef, 0, visited)
+	if k > 0 && len(results) > k {
+		results = results[:k]
+	}
+	return results
+}
+
+// Exact returns the k rows with the highest dot product with query, best
+// first, by scoring every row.
+func Exact(h *mmm.Handle, query []float32, k int) []Result {
+	results := &resultHeap{worstFirst: true}
+	for i := 0; i < h.Rows(); i++ {
+		score := dot(query, h.RowByIdx(i))
+		if results.Len() < k {
+			heap.Push(results, Result{Idx: i, Score: score})
+		} else if score > results.items[0].Score {
+			results.items[0] = Result{Idx: i, Score: score}
+			heap.Fix(results, 0)
+		}
+	}
+	sortResults(results.items)
+	return results.items
+}
+
+// MeasureRecall searches for the k nearest neighbors of queries randomly
+// chosen rows with the given ef, and returns the fraction of exact top k
+// results that the index found. The result is also stored in idx.Recall.
+func (idx *Index) MeasureRecall(queries, k, ef int, seed int64,
+	workers int) float64 {
+	r := rand.New(rand.NewSource(seed))
+	rows := make([]int, queries)
+	for i := range rows {
+		rows[i] = r.Intn(idx.h.Rows())
+	}
+	found := make([]int, queries)
+	total := make([]int, queries)
+	mmm.ParallelRows(queries, workers, func(i int) {
+		query := idx.h.RowByIdx(rows[i])
+		approx := map[int]bool{}
+		for _, res := range idx.Search(query, k, ef) {
+			approx[res.Idx] = true
+		}
+		for _, res := range Exact(idx.h, query, k) {
+			total[i]++
+			if approx[res.Idx] {
+				found[i]++
+			}
+		}
+	})
+	var found_sum, total_sum int
+	for i := range found {
+		found_sum += found[i]
+		total_sum += total[i]
+	}
+	idx.Recall = math.NaN()
+	if total_sum > 0 {
+		idx.Recall = float64(found_sum) / float64(total_sum)
+	}
+	return idx.Recall
+}
+
+func min(a, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
@@ -0,0 +1,107 @@
+// Copyright (C) 2017 JT Olds
+// See LICENSE for copying information
+
+package hnsw
+
+import (
+	"bytes"
+	"io/ioutil"
+	"math"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/jtolds/golincs/mmm"
+)
+
+// randomUnit makes an mmm file with the given number of random unit length
+// rows.
+func randomUnit(t *testing.T, dir string, rows, cols int) *mmm.Handle {
+	h, err := mmm.Create(filepath.Join(dir, "rows.mmm"), int64(rows),
+		int64(cols))
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := rand.New(rand.NewSource(1))
+	for i := range h.RowIds() {
+		h.RowIds()[i] = mmm.Ident(i + 1)
+	}
+	for i := range h.ColIds() {
+		h.ColIds()[i] = mmm.Ident(i + 1)
+	}
+	for i := 0; i < rows; i++ {
+		row := h.RowByIdx(i)
+		var norm float64
+		for j := range row {
+			row[j] = float32(r.NormFloat64())
+			norm += float64(row[j]) * float64(row[j])
+		}
+		norm = math.Sqrt(norm)
+		for j := range row {
+			row[j] = float32(float64(row[j]) / norm)
+		}
+	}
+	return h
+}
+
+func TestRecallAndSaveLoad(t *testing.T) {
+	dir, err := ioutil.TempDir("", "hnsw")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	h := randomUnit(t, dir, 2000, 16)
+	defer h.Close()
+
+	idx, err := Build(h, Options{M: 8, EfConstruction: 100, Seed: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	recall := idx.MeasureRecall(100, 10, 100, 3, 0)
+	if recall < 0.9 {
+		t.Fatalf("recall %v below 0.9", recall)
+	}
+
+	var buf bytes.Buffer
+	err = idx.Save(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	loaded, err := Load(&buf, h)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if loaded.M != idx.M || loaded.EfConstruction != idx.EfConstruction ||
+		loaded.Recall != idx.Recall || loaded.Layers() != idx.Layers() ||
+		loaded.entry != idx.entry || len(loaded.links) != len(idx.links) {
+		t.Fatalf("loaded index doesn't match the saved one")
+	}
+	for node := range idx.links {
+		if len(loaded.links[node]) != len(idx.links[node]) {
+			t.Fatalf("node %d has %d layers, saved with %d", node,
+				len(loaded.links[node]), len(idx.links[node]))
+		}
+		for layer, links := range idx.links[node] {
+			if len(links) == 0 && len(loaded.links[node][layer]) == 0 {
+				continue
+			}
+			if !reflect.DeepEqual(loaded.links[node][layer], links) {
+				t.Fatalf("node %d layer %d links differ", node, layer)
+			}
+		}
+	}
+
+	query := h.RowByIdx(7)
+	if !reflect.DeepEqual(loaded.Search(query, 10, 50),
+		idx.Search(query, 10, 50)) {
+		t.Fatalf("loaded index searches differently")
+	}
+	exact := Exact(h, query, 1)
+	if len(exact) != 1 || exact[0].Idx != 7 {
+		t.Fatalf("row 7 isn't its own nearest neighbor: %v", exact)
+	}
+}
@@ -0,0 +1,192 @@
+// Copyright (C) 2017 JT Olds
+// See LICENSE for copying information
+
+package main
+
+import (
+	"bufio"
+	"context"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"math"
+	"os"
+	"path/filepath"
+
+	"github.com/jtolds/golincs/knngraph"
+	"github.com/jtolds/golincs/mmm"
+	"github.com/jtolds/golincs/web/dbs/lincs_gse92742_v0"
+	"github.com/spacemonkeygo/errors"
+)
+
+var (
+	inputPath = flag.String("i", "",
+		"input mmm path. neighbors are computed from its rows")
+	topKPath = flag.String("topk", "",
+		"path to precomputed neighbors (from mmmcorr -k or mmmknn), used "+
+			"instead of -i")
+	outputPath = flag.String("o", "", "output path")
+	formatFlag = flag.String("format", "graphml",
+		"output format. can be 'graphml' or 'cytoscape'")
+	topK = flag.Int("k", 10,
+		"number of neighbors per row. if <= 0 with -i, every pair of rows "+
+			"scoring past -threshold is an edge")
+	threshold = flag.Float64("threshold", math.NaN(),
+		"if set, drop edges with a score below this (above, with -lowest)")
+	metricFlag = flag.String("metric", "cosine",
+		"with -i, the similarity metric. can be 'cosine', 'pearson', or "+
+			"'spearman'")
+	lowest = flag.Bool("lowest", false,
+		"connect the most opposite rows instead of the most similar")
+	directed = flag.Bool("directed", false,
+		"if true, write a directed graph with an edge per row-neighbor pair")
+	tagsFlag = flag.String("tags", "samples",
+		"where to look up node names and attributes in the dataset. can be "+
+			"'samples', 'genesigs', or 'none'")
+)
+
+func computeTopK(tmpdir string, metric mmm.Metric) string {
+	path := filepath.Join(tmpdir, "topk")
+	err := mmm.Correlate(path, *inputPath, "", mmm.CorrOptions{
+		Metric:      metric,
+		TopK:        *topK,
+		Lowest:      *lowest,
+		ExcludeSelf: true,
+	})
+	if err != nil {
+		panic(err)
+	}
+	return path
+}
+
+// thresholdGraph builds the graph of every pair of rows of the input whose
+// score passes -threshold, adding edges as the pairs are scored.
+func thresholdGraph(metric mmm.Metric) *knngraph.Graph {
+	b := knngraph.NewBuilder(*directed)
+	err := mmm.CorrelatePairs(*inputPath, "", mmm.CorrOptions{
+		Metric:      metric,
+		Lowest:      *lowest,
+		ExcludeSelf: true,
+	}, float32(*threshold), func(a_id, b_id mmm.Ident, score float32) error {
+		b.Add(a_id, b_id, float64(score))
+		return nil
+	})
+	if err != nil {
+		panic(err)
+	}
+	return b.Graph()
+}
+
+func topKGraph(path string, opts knngraph.Options) *knngraph.Graph {
+	t, err := mmm.OpenTopK(path)
+	if err != nil {
+		panic(err)
+	}
+	g := knngraph.FromTopK(t, opts)
+	err = t.Close()
+	if err != nil {
+		panic(err)
+	}
+	return g
+}
+
+func annotate(g *knngraph.Graph) {
+	if *tagsFlag == "none" {
+		return
+	}
+	if *tagsFlag != "samples" && *tagsFlag != "genesigs" {
+		panic(fmt.Sprintf("unknown tags source %q", *tagsFlag))
+	}
+	db, err := lincs_gse92742_v0.OpenDB()
+	if err != nil {
+		panic(err)
+	}
+	defer db.Close()
+
+	ids := make([]mmm.Ident, 0, len(g.Nodes))
+	for _, n := range g.Nodes {
+		ids = append(ids, n.Id)
+	}
+	metadata, err := lincs_gse92742_v0.LookupSigs(context.Background(), db,
+		ids, *tagsFlag == "samples")
+	if err != nil {
+		panic(err)
+	}
+
+	err = g.Annotate(func(id mmm.Ident) (label string, attrs map[string]string,
+		found bool, err error) {
+		m, found := metadata[id]
+		return m.Name, m.Tags, found, nil
+	})
+	if err != nil {
+		panic(err)
+	}
+}
+
+func main() {
+	flag.Parse()
+
+	if *outputPath == "" {
+		panic("output path (-o) required")
+	}
+	if (*inputPath == "") == (*topKPath == "") {
+		panic("exactly one of -i or -topk required")
+	}
+
+	opts := knngraph.Options{
+		K:            *topK,
+		UseThreshold: !math.IsNaN(*threshold),
+		Threshold:    *threshold,
+		Lowest:       *lowest,
+		Directed:     *directed,
+	}
+	var g *knngraph.Graph
+	if *inputPath != "" {
+		metric, err := mmm.ParseMetric(*metricFlag)
+		if err != nil {
+			panic(err)
+		}
+		if *topK > 0 {
+			tmpdir, err := ioutil.TempDir("", "knnexport")
+			if err != nil {
+				panic(err)
+			}
+			defer os.RemoveAll(tmpdir)
+			opts.K = 0
+			g = topKGraph(computeTopK(tmpdir, metric), opts)
+		} else {
+			if !opts.UseThreshold {
+				panic("-threshold required with -i and -k <= 0")
+			}
+			g = thresholdGraph(metric)
+		}
+	} else {
+		g = topKGraph(*topKPath, opts)
+	}
+
+	annotate(g)
+
+	fh, err := os.Create(*outputPath)
+	if err != nil {
+		panic(err)
+	}
+	defer fh.Close()
+	w := bufio.NewWriter(fh)
+
+	switch *formatFlag {
+	case "graphml":
+		err = g.WriteGraphML(w)
+	case "cytoscape":
+		err = g.WriteCytoscapeJSON(w)
+	default:
+		err = fmt.Errorf("unknown format %q", *formatFlag)
+	}
+	var errs errors.ErrorGroup
+	errs.Add(err)
+	errs.Add(w.Flush())
+	errs.Add(fh.Close())
+	err = errs.Finalize()
+	if err != nil {
+		panic(err)
+	}
+}
@@ -0,0 +1,57 @@
+// Copyright (C) 2017 JT Olds
+// See LICENSE for copying information
+
+package knngraph
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+)
+
+type cytoscapeElement struct {
+	Data map[string]interface{} `json:"data"`
+}
+
+type cytoscapeDoc struct {
+	Directed bool `json:"directed"`
+	Elements struct {
+		Nodes []cytoscapeElement `json:"nodes"`
+		Edges []cytoscapeElement `json:"edges"`
+	} `json:"elements"`
+}
+
+// WriteCytoscapeJSON writes g in the Cytoscape.js JSON format, which
+// Cytoscape desktop can also import. Node attributes are added to each
+// node's data, next to its id and name.
+func (g *Graph) WriteCytoscapeJSON(w io.Writer) error {
+	var doc cytoscapeDoc
+	doc.Directed = g.Directed
+	doc.Elements.Nodes = make([]cytoscapeElement, 0, len(g.Nodes))
+	doc.Elements.Edges = make([]cytoscapeElement, 0, len(g.Edges))
+
+	for _, n := range g.Nodes {
+		data := make(map[string]interface{}, len(n.Attrs)+2)
+		for name, val := range n.Attrs {
+			data[name] = val
+		}
+		data["id"] = fmt.Sprint(n.Id)
+		data["name"] = n.Label
+		doc.Elements.Nodes = append(doc.Elements.Nodes,
+			cytoscapeElement{Data: data})
+	}
+
+	for i, e := range g.Edges {
+		doc.Elements.Edges = append(doc.Elements.Edges, cytoscapeElement{
+			Data: map[string]interface{}{
+				"id":     fmt.Sprintf("e%d", i),
+				"source": fmt.Sprint(e.Source),
+				"target": fmt.Sprint(e.Target),
+				"weight": e.Weight,
+			}})
+	}
+
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(doc)
+}
@@ -0,0 +1,109 @@
+// Copyright (C) 2017 JT Olds
+// See LICENSE for copying information
+
+package knngraph
+
+import (
+	"encoding/xml"
+	"fmt"
+	"io"
+)
+
+type graphmlKey struct {
+	Id       string `xml:"id,attr"`
+	For      string `xml:"for,attr"`
+	AttrName string `xml:"attr.name,attr"`
+	AttrType string `xml:"attr.type,attr"`
+}
+
+type graphmlData struct {
+	Key   string `xml:"key,attr"`
+	Value string `xml:",chardata"`
+}
+
+type graphmlNode struct {
+	Id   string        `xml:"id,attr"`
+	Data []graphmlData `xml:"data"`
+}
+
+type graphmlEdge struct {
+	Id     string        `xml:"id,attr"`
+	Source string        `xml:"source,attr"`
+	Target string        `xml:"target,attr"`
+	Data   []graphmlData `xml:"data"`
+}
+
+type graphmlGraph struct {
+	Id          string        `xml:"id,attr"`
+	EdgeDefault string        `xml:"edgedefault,attr"`
+	Nodes       []graphmlNode `xml:"node"`
+	Edges       []graphmlEdge `xml:"edge"`
+}
+
+type graphmlDoc struct {
+	XMLName xml.Name     `xml:"graphml"`
+	Xmlns   string       `xml:"xmlns,attr"`
+	Keys    []graphmlKey `xml:"key"`
+	Graph   graphmlGraph `xml:"graph"`
+}
+
+// WriteGraphML writes g as GraphML. Node labels are stored in a "label"
+// attribute, node attributes as string attributes, and edge weights in a
+// "weight" attribute.
+func (g *Graph) WriteGraphML(w io.Writer) error {
+	doc := graphmlDoc{
+		Xmlns: "http://graphml.graphdrawing.org/xmlns",
+		Keys: []graphmlKey{
+			{Id: "label", For: "node", AttrName: "label", AttrType: "string"},
+			{Id: "weight", For: "edge", AttrName: "weight", AttrType: "double"},
+		},
+		Graph: graphmlGraph{Id: "G", EdgeDefault: "undirected"},
+	}
+	if g.Directed {
+		doc.Graph.EdgeDefault = "directed"
+	}
+
+	names := g.attrNames()
+	for i, name := range names {
+		doc.Keys = append(doc.Keys, graphmlKey{
+			Id: fmt.Sprintf("a%d", i), For: "node", AttrName: name,
+			AttrType: "string"})
+	}
+
+	for _, n := range g.Nodes {
+		node := graphmlNode{
+			Id:   fmt.Sprint(n.Id),
+			Data: []graphmlData{{Key: "label", Value: n.Label}},
+		}
+		for i, name := range names {
+			if val, ok := n.Attrs[name]; ok {
+				node.Data = append(node.Data, graphmlData{
+					Key: fmt.Sprintf("a%d", i), Value: val})
+			}
+		}
+		doc.Graph.Nodes = append(doc.Graph.Nodes, node)
+	}
+
+	for i, e := range g.Edges {
+		doc.Graph.Edges = append(doc.Graph.Edges, graphmlEdge{
+			Id:     fmt.Sprintf("e%d", i),
+			Source: fmt.Sprint(e.Source),
+			Target: fmt.Sprint(e.Target),
+			Data: []graphmlData{{
+				Key: "weight", Value: fmt.Sprint(e.Weight)}},
+		})
+	}
+
+	_, err := io.WriteString(w, xml.Header)
+	if err != nil {
+		return err
+	}
+	enc := xml.NewEncoder(w)
+	enc.Indent("", "  ")
+	err = enc.Encode(doc)
+	if err != nil {
+		return err
+	}
+	_, err = io.WriteString(w, "\n")
+	return err
+}
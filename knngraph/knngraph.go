@@ -0,0 +1,164 @@
+// Copyright (C) 2017 JT Olds
+// See LICENSE for copying information
+
+// Package knngraph builds similarity networks from top-k neighbor files and
+// writes them in formats network visualization tools understand.
+package knngraph
+
+import (
+	"fmt"
+	"math"
+	"sort"
+
+	"github.com/jtolds/golincs/mmm"
+)
+
+// Node is a graph vertex. Attrs are written as node attributes.
+type Node struct {
+	Id    mmm.Ident
+	Label string
+	Attrs map[string]string
+}
+
+// Edge connects two nodes by id.
+type Edge struct {
+	Source, Target mmm.Ident
+	Weight         float64
+}
+
+type Graph struct {
+	Directed bool
+	Nodes    []Node
+	Edges    []Edge
+}
+
+// Options controls which neighbors become edges.
+type Options struct {
+	// K keeps at most the first K neighbors of every row. If <= 0, all of the
+	// neighbors in the TopK file are considered.
+	K int
+	// UseThreshold enables Threshold, which drops edges with a score below it
+	// (or above it, if Lowest is set).
+	UseThreshold bool
+	Threshold    float64
+	// Lowest should be set if the TopK file holds the lowest scores, such as
+	// the output of mmmcorr -lowest.
+	Lowest bool
+	// Directed keeps one edge per row-neighbor pair. Otherwise, a pair that
+	// appears in both directions becomes a single edge with the higher
+	// magnitude weight.
+	Directed bool
+}
+
+// Builder collects edges into a graph one at a time.
+type Builder struct {
+	g     *Graph
+	edges map[pair]int
+	used  map[mmm.Ident]bool
+}
+
+type pair struct{ a, b mmm.Ident }
+
+// NewBuilder returns a Builder for a directed or undirected graph.
+func NewBuilder(directed bool) *Builder {
+	return &Builder{
+		g:     &Graph{Directed: directed},
+		edges: map[pair]int{},
+		used:  map[mmm.Ident]bool{},
+	}
+}
+
+// Add adds an edge from source to target. In an undirected graph, a pair
+// added in both directions becomes a single edge with the higher magnitude
+// weight.
+func (b *Builder) Add(source, target mmm.Ident, weight float64) {
+	key := pair{a: source, b: target}
+	if !b.g.Directed && target < source {
+		key = pair{a: target, b: source}
+	}
+	if existing, found := b.edges[key]; found {
+		if math.Abs(weight) > math.Abs(b.g.Edges[existing].Weight) {
+			b.g.Edges[existing].Weight = weight
+		}
+		return
+	}
+	b.edges[key] = len(b.g.Edges)
+	b.g.Edges = append(b.g.Edges, Edge{
+		Source: key.a, Target: key.b, Weight: weight})
+	b.used[source] = true
+	b.used[target] = true
+}
+
+// Graph returns the graph, with a node for every id that has an edge.
+func (b *Builder) Graph() *Graph {
+	b.g.Nodes = b.g.Nodes[:0]
+	for id := range b.used {
+		b.g.Nodes = append(b.g.Nodes, Node{Id: id, Label: fmt.Sprint(id)})
+	}
+	sort.Slice(b.g.Nodes, func(i, j int) bool {
+		return b.g.Nodes[i].Id < b.g.Nodes[j].Id
+	})
+	return b.g
+}
+
+// FromTopK builds a graph with a node for every row of t and an edge for
+// every neighbor that passes opts. Only nodes that have at least one edge are
+// kept.
+func FromTopK(t *mmm.TopK, opts Options) *Graph {
+	b := NewBuilder(opts.Directed)
+	for idx := 0; idx < t.Rows(); idx++ {
+		source := t.RowIdByIdx(idx)
+		ids, scores := t.NeighborsByIdx(idx)
+		for i, target := range ids {
+			if opts.K > 0 && i >= opts.K {
+				break
+			}
+			score := float64(scores[i])
+			if math.IsNaN(score) {
+				break
+			}
+			if opts.UseThreshold {
+				if opts.Lowest && score > opts.Threshold ||
+					!opts.Lowest && score < opts.Threshold {
+					break
+				}
+			}
+			b.Add(source, target, score)
+		}
+	}
+	return b.Graph()
+}
+
+// Annotate calls cb for every node, and sets the node's label and attributes
+// from its results. If cb returns found == false, the node is left alone.
+func (g *Graph) Annotate(cb func(id mmm.Ident) (label string,
+	attrs map[string]string, found bool, err error)) error {
+	for i := range g.Nodes {
+		label, attrs, found, err := cb(g.Nodes[i].Id)
+		if err != nil {
+			return err
+		}
+		if !found {
+			continue
+		}
+		g.Nodes[i].Label = label
+		g.Nodes[i].Attrs = attrs
+	}
+	return nil
+}
+
+// attrNames returns the sorted union of all node attribute names.
+func (g *Graph) attrNames() []string {
+	seen := map[string]bool{}
+	var names []string
+	for _, n := range g.Nodes {
+		for name := range n.Attrs {
+			if !seen[name] {
+				seen[name] = true
+				names = append(names, name)
+			}
+		}
+	}
+	sort.Strings(names)
+	return names
+}
@@ -0,0 +1,109 @@
+// Copyright (C) 2017 JT Olds
+// See LICENSE for copying information
+
+package mmm
+
+import (
+	"math"
+	"os"
+)
+
+// ColumnStats computes Stats for every column of h.
+func ColumnStats(h *Handle) []Stats {
+	cols := h.Cols()
+	sums := make([]float64, cols)
+	squared_sums := make([]float64, cols)
+	stats := make([]Stats, cols)
+	for i := range stats {
+		stats[i].Min, stats[i].Max = math.Inf(1), math.Inf(-1)
+	}
+	for idx := 0; idx < h.Rows(); idx++ {
+		for col, v := range h.RowByIdx(idx) {
+			f := float64(v)
+			sums[col] += f
+			squared_sums[col] += f * f
+			if f < stats[col].Min {
+				stats[col].Min = f
+			}
+			if f > stats[col].Max {
+				stats[col].Max = f
+			}
+		}
+	}
+	if h.Rows() == 0 {
+		return stats
+	}
+	n := float64(h.Rows())
+	for col := range stats {
+		s := &stats[col]
+		s.Mean = sums[col] / n
+		s.Std = math.Sqrt(math.Max(squared_sums[col]/n-s.Mean*s.Mean, 0))
+		s.Norm = math.Sqrt(squared_sums[col])
+	}
+	return stats
+}
+
+// RowStats computes Stats for a single row.
+func RowStats(row []float32) Stats {
+	return computeStats(row)
+}
+
+// Apply evaluates expr for every value in the matrix at src_path and writes
+// the result to dst_path. If dst_path is empty or the same as src_path, the
+// source file is modified in place. Row and column statistics referenced by
+// the expression are always computed from the source values before any value
+// is changed. Rows are processed in parallel by workers goroutines (or
+// GOMAXPROCS, if workers <= 0).
+func Apply(dst_path, src_path string, expr *Expr, workers int) (err error) {
+	src, err := Open(src_path)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+
+	dst := src
+	if dst_path != "" && dst_path != src_path {
+		dst, err = Create(dst_path, int64(src.Rows()), int64(src.Cols()))
+		if err != nil {
+			return err
+		}
+		defer func() {
+			dst.Close()
+			if err != nil {
+				os.Remove(dst_path)
+			}
+		}()
+		copy(dst.RowIds(), src.RowIds())
+		copy(dst.ColIds(), src.ColIds())
+	}
+
+	var col_stats []Stats
+	if expr.colVars {
+		col_stats = ColumnStats(src)
+	}
+
+	ParallelRows(src.Rows(), workers, func(idx int) {
+		src_row := src.RowByIdx(idx)
+		dst_row := dst.RowByIdx(idx)
+		env := exprEnv{}
+		if expr.rowVars {
+			row_stats := computeStats(src_row)
+			env.row = &row_stats
+		}
+		for col, val := range src_row {
+			env.x = float64(val)
+			if col_stats != nil {
+				env.col = &col_stats[col]
+			}
+			dst_row[col] = float32(expr.root(&env))
+		}
+	})
+
+	if dst != src {
+		err = dst.Close()
+		if err != nil {
+			return err
+		}
+	}
+	return src.Close()
+}
@@ -0,0 +1,42 @@
+// Copyright (C) 2017 JT Olds
+// See LICENSE for copying information
+
+package main
+
+import (
+	"flag"
+
+	"github.com/jtolds/golincs/mmm"
+)
+
+var (
+	exprFlag = flag.String("e", "", "expression to apply to every value x. "+
+		"e.g. '2*x', 'abs(x)', 'clip(x, -1, 1)', 'log2(x+1)', "+
+		"'threshold(x, 0.5)', 'sign(x)', or '(x-row_mean)/row_std'")
+	outputPath = flag.String("o", "", "output path. if empty, input files "+
+		"are modified in place")
+	workers = flag.Int("workers", 0, "number of rows to process in parallel. "+
+		"defaults to GOMAXPROCS")
+)
+
+func main() {
+	flag.Parse()
+	if *exprFlag == "" {
+		panic("expression (-e) required")
+	}
+	if *outputPath != "" && flag.NArg() != 1 {
+		panic("expecting exactly one input when an output path (-o) is given")
+	}
+
+	expr, err := mmm.ParseExpr(*exprFlag)
+	if err != nil {
+		panic(err)
+	}
+
+	for _, path := range flag.Args() {
+		err = mmm.Apply(*outputPath, path, expr, *workers)
+		if err != nil {
+			panic(err)
+		}
+	}
+}
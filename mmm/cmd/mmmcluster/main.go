@@ -0,0 +1,144 @@
+// Copyright (C) 2017 JT Olds
+// See LICENSE for copying information
+
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/jtolds/golincs/cluster"
+	"github.com/jtolds/golincs/mmm"
+	"github.com/spacemonkeygo/errors"
+)
+
+var (
+	inputPath  = flag.String("i", "", "input path")
+	outputPath = flag.String("o", "",
+		"output path for tab-separated 'row id<TAB>cluster' assignments")
+	orderPath = flag.String("order", "",
+		"if set, path to write the clustered row order to, as newline-"+
+			"separated row ids usable with mmmsort -rows order=<path>")
+	newickPath = flag.String("newick", "",
+		"if set with -method hierarchical, path to write the dendrogram to, "+
+			"in Newick format")
+	methodFlag = flag.String("method", "kmeans",
+		"clustering method. can be 'kmeans' or 'hierarchical'")
+	clusters = flag.Int("k", 10,
+		"number of clusters. for hierarchical clustering, where the "+
+			"dendrogram is cut")
+	seed          = flag.Int64("seed", 0, "random seed for k-means++ seeding")
+	maxIterations = flag.Int("max_iterations", 100,
+		"maximum number of k-means iterations")
+	tolerance = flag.Float64("tolerance", 1e-6,
+		"k-means stops once no centroid moves further than this")
+	linkageFlag = flag.String("linkage", "average",
+		"hierarchical linkage on cosine distance. can be 'average' or "+
+			"'complete'")
+	workers = flag.Int("workers", 0,
+		"number of goroutines to use. defaults to GOMAXPROCS")
+)
+
+func writeLines(path string, cb func(w io.Writer) error) {
+	fh, err := os.Create(path)
+	if err != nil {
+		panic(err)
+	}
+	defer fh.Close()
+	w := bufio.NewWriter(fh)
+	var errs errors.ErrorGroup
+	errs.Add(cb(w))
+	errs.Add(w.Flush())
+	errs.Add(fh.Close())
+	err = errs.Finalize()
+	if err != nil {
+		panic(err)
+	}
+}
+
+func main() {
+	flag.Parse()
+
+	if *inputPath == "" {
+		panic("input path (-i) required")
+	}
+	if *outputPath == "" {
+		panic("output path (-o) required")
+	}
+
+	fh, err := mmm.Open(*inputPath)
+	if err != nil {
+		panic(err)
+	}
+	defer fh.Close()
+
+	var assignments, order []int
+	var dendrogram *cluster.Dendrogram
+	switch *methodFlag {
+	case "kmeans":
+		res, err := cluster.KMeans(fh, cluster.KMeansOptions{
+			K:             *clusters,
+			Seed:          *seed,
+			MaxIterations: *maxIterations,
+			Tolerance:     *tolerance,
+			Workers:       *workers,
+		})
+		if err != nil {
+			panic(err)
+		}
+		fmt.Fprintf(os.Stderr, "iterations: %d, converged: %v, inertia: %g\n",
+			res.Iterations, res.Converged, res.Inertia)
+		assignments = res.Assignments
+		order = cluster.Order(assignments, res.Distances)
+	case "hierarchical":
+		linkage, err := cluster.ParseLinkage(*linkageFlag)
+		if err != nil {
+			panic(err)
+		}
+		dendrogram, err = cluster.Hierarchical(fh, linkage, *workers)
+		if err != nil {
+			panic(err)
+		}
+		assignments = dendrogram.Cut(*clusters)
+		order = dendrogram.LeafOrder()
+	default:
+		panic(fmt.Sprintf("unknown method %q", *methodFlag))
+	}
+
+	writeLines(*outputPath, func(w io.Writer) error {
+		for idx, c := range assignments {
+			_, err := fmt.Fprintf(w, "%d\t%d\n", fh.RowIdByIdx(idx), c)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+
+	if *orderPath != "" {
+		writeLines(*orderPath, func(w io.Writer) error {
+			for _, id := range cluster.OrderIds(fh, order) {
+				_, err := fmt.Fprintf(w, "%d\n", id)
+				if err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+	}
+
+	if *newickPath != "" {
+		if dendrogram == nil {
+			panic("-newick requires -method hierarchical")
+		}
+		writeLines(*newickPath, dendrogram.WriteNewick)
+	}
+
+	err = fh.Close()
+	if err != nil {
+		panic(err)
+	}
+}
@@ -4,29 +4,34 @@
 package main
 
 import (
+	"bufio"
 	"flag"
+	"fmt"
+	"os"
+	"strconv"
 
 	"github.com/jtolds/golincs/mmm"
+	"github.com/spacemonkeygo/errors"
 )
 
 var (
 	outputPath = flag.String("o", "", "output path")
 	byCol      = flag.Bool("col", false,
 		"if true, combine by adding columns instead of rows")
+	joinFlag = flag.String("join", "union",
+		"how to align the ids of the axis not being combined. can be 'union' "+
+			"or 'intersect'")
+	fillFlag = flag.String("fill", "nan",
+		"value for cells missing from an input when using -join union. "+
+			"e.g. 'nan' or '0'")
+	dupsFlag = flag.String("dups", "error",
+		"what to do with duplicate ids. can be 'error', 'first' (keep the "+
+			"first occurrence), or 'suffix' (give later occurrences new ids)")
+	dupMapPath = flag.String("dup_map", "",
+		"if set with -dups suffix, path to write tab-separated "+
+			"'input<TAB>old id<TAB>new id' lines for every renamed id")
 )
 
-func equal(a, b []mmm.Ident) bool {
-	if len(a) != len(b) {
-		return false
-	}
-	for i, v := range a {
-		if b[i] != v {
-			return false
-		}
-	}
-	return true
-}
-
 func main() {
 	flag.Parse()
 
@@ -34,71 +39,61 @@ func main() {
 		panic("output path (-o) required")
 	}
 
-	var handles []*mmm.Handle
-	for _, path := range flag.Args() {
-		fh, err := mmm.Open(path)
-		if err != nil {
-			panic(err)
-		}
-		defer fh.Close()
-		handles = append(handles, fh)
+	opts := mmm.CombineOptions{Axis: mmm.Rows}
+	if *byCol {
+		opts.Axis = mmm.Cols
 	}
 
-	rows, cols := 0, 0
-	if len(handles) > 0 {
-		rows, cols = handles[0].Rows(), handles[0].Cols()
-		if *byCol {
-			for _, handle := range handles[1:] {
-				if !equal(handle.RowIds(), handles[0].RowIds()) {
-					panic("row ids don't match")
-				}
-				cols += handle.Cols()
-			}
-		} else {
-			for _, handle := range handles[1:] {
-				if !equal(handle.ColIds(), handles[0].ColIds()) {
-					panic("col ids don't match")
-				}
-				rows += handle.Rows()
-			}
-		}
+	switch *joinFlag {
+	case "union":
+		opts.Join = mmm.Union
+	case "intersect":
+		opts.Join = mmm.Intersection
+	default:
+		panic(fmt.Sprintf("unknown join %q", *joinFlag))
 	}
 
-	out, err := mmm.Create(*outputPath, int64(rows), int64(cols))
+	fill, err := strconv.ParseFloat(*fillFlag, 32)
 	if err != nil {
 		panic(err)
 	}
-	defer out.Close()
+	opts.Fill = float32(fill)
 
-	if len(handles) > 0 {
-		if *byCol {
-			copy(out.RowIds(), handles[0].RowIds())
-		} else {
-			copy(out.ColIds(), handles[0].ColIds())
-		}
+	switch *dupsFlag {
+	case "error":
+		opts.Duplicates = mmm.DuplicateError
+	case "first":
+		opts.Duplicates = mmm.DuplicateKeepFirst
+	case "suffix":
+		opts.Duplicates = mmm.DuplicateSuffix
+	default:
+		panic(fmt.Sprintf("unknown duplicate handling %q", *dupsFlag))
 	}
 
-	if *byCol {
-		offset := 0
-		for _, handle := range handles {
-			for i := 0; i < out.Rows(); i++ {
-				copy(out.RowByIdx(i)[offset:offset+handle.Cols()], handle.RowByIdx(i))
-			}
-			copy(out.ColIds()[offset:offset+handle.Cols()], handle.ColIds())
-			offset += handle.Cols()
+	var dupMap *bufio.Writer
+	if *dupMapPath != "" {
+		fh, err := os.Create(*dupMapPath)
+		if err != nil {
+			panic(err)
 		}
-	} else {
-		offset := 0
-		for _, handle := range handles {
-			for i := 0; i < handle.Rows(); i++ {
-				copy(out.RowByIdx(offset+i), handle.RowByIdx(i))
-			}
-			copy(out.RowIds()[offset:offset+handle.Rows()], handle.RowIds())
-			offset += handle.Rows()
+		defer fh.Close()
+		dupMap = bufio.NewWriter(fh)
+		var errs errors.ErrorGroup
+		opts.Renamed = func(input int, old, new mmm.Ident) {
+			_, err := fmt.Fprintf(dupMap, "%s\t%d\t%d\n", flag.Arg(input), old, new)
+			errs.Add(err)
 		}
+		defer func() {
+			errs.Add(dupMap.Flush())
+			errs.Add(fh.Close())
+			err := errs.Finalize()
+			if err != nil {
+				panic(err)
+			}
+		}()
 	}
 
-	err = out.Close()
+	err = mmm.Combine(*outputPath, flag.Args(), opts)
 	if err != nil {
 		panic(err)
 	}
@@ -0,0 +1,64 @@
+// Copyright (C) 2017 JT Olds
+// See LICENSE for copying information
+
+package main
+
+import (
+	"flag"
+
+	"github.com/jtolds/golincs/mmm"
+)
+
+var (
+	outputPath = flag.String("o", "", "output path")
+	metricFlag = flag.String("metric", "cosine",
+		"similarity metric. can be 'cosine', 'pearson', or 'spearman'")
+	topK = flag.Int("k", 0,
+		"if > 0, only keep the k most similar rows of the second file for "+
+			"every row of the first, writing a sparse top-k file (the output "+
+			"path plus a '.ids' file)")
+	lowest = flag.Bool("lowest", false,
+		"with -k, keep the k least similar rows instead")
+	excludeSelf = flag.Bool("exclude_self", false,
+		"with -k, skip pairs of rows with the same id. defaults to true if "+
+			"only one file is given, and false when comparing two files")
+	blockRows = flag.Int("block_rows", 4096,
+		"rows of the first file to hold in memory at a time")
+	workers = flag.Int("workers", 0,
+		"number of goroutines to score with. defaults to GOMAXPROCS")
+)
+
+func main() {
+	flag.Parse()
+
+	if *outputPath == "" {
+		panic("output path (-o) required")
+	}
+	if flag.NArg() != 1 && flag.NArg() != 2 {
+		panic("expecting one or two input files")
+	}
+
+	exclude_self := flag.NArg() == 1
+	flag.Visit(func(f *flag.Flag) {
+		if f.Name == "exclude_self" {
+			exclude_self = *excludeSelf
+		}
+	})
+
+	metric, err := mmm.ParseMetric(*metricFlag)
+	if err != nil {
+		panic(err)
+	}
+
+	err = mmm.Correlate(*outputPath, flag.Arg(0), flag.Arg(1), mmm.CorrOptions{
+		Metric:      metric,
+		TopK:        *topK,
+		Lowest:      *lowest,
+		ExcludeSelf: exclude_self,
+		BlockRows:   *blockRows,
+		Workers:     *workers,
+	})
+	if err != nil {
+		panic(err)
+	}
+}
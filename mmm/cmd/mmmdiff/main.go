@@ -0,0 +1,96 @@
+// Copyright (C) 2017 JT Olds
+// See LICENSE for copying information
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/jtolds/golincs/mmm"
+)
+
+var (
+	absTolerance = flag.Float64("atol", 1e-6, "absolute tolerance")
+	relTolerance = flag.Float64("rtol", 1e-5,
+		"relative tolerance, scaled by the larger magnitude of the two values")
+	maxRows = flag.Int("max_rows", 20,
+		"maximum number of differing rows to list. negative means all")
+	maxIds = flag.Int("max_ids", 20,
+		"maximum number of unmatched ids to list per axis. negative means all")
+)
+
+// fail reports that the files could not be compared, and exits with status
+// 2 so scripts can tell that apart from the files differing.
+func fail(err interface{}) {
+	fmt.Fprintln(os.Stderr, "mmmdiff:", err)
+	os.Exit(2)
+}
+
+func must(n int, err error) {
+	if err != nil {
+		fail(err)
+	}
+}
+
+func printIds(label string, ids []mmm.Ident) {
+	if len(ids) == 0 {
+		return
+	}
+	must(fmt.Printf("%s: %d", label, len(ids)))
+	for i, id := range ids {
+		if *maxIds >= 0 && i >= *maxIds {
+			must(fmt.Printf(" ..."))
+			break
+		}
+		must(fmt.Printf(" %d", id))
+	}
+	must(fmt.Println())
+}
+
+// main exits with status 0 if the files match within tolerance, 1 if they
+// differ, and 2 if they could not be compared.
+func main() {
+	flag.Parse()
+	if flag.NArg() != 2 {
+		fail("expecting exactly two arguments")
+	}
+
+	report, err := mmm.Diff(flag.Arg(0), flag.Arg(1), mmm.DiffOptions{
+		AbsTolerance: *absTolerance,
+		RelTolerance: *relTolerance,
+	})
+	if err != nil {
+		fail(err)
+	}
+
+	printIds("Rows only in "+flag.Arg(0), report.RowsOnlyInA)
+	printIds("Rows only in "+flag.Arg(1), report.RowsOnlyInB)
+	printIds("Cols only in "+flag.Arg(0), report.ColsOnlyInA)
+	printIds("Cols only in "+flag.Arg(1), report.ColsOnlyInB)
+
+	must(fmt.Printf("Compared: %d\nDiffering: %d\nNaN mismatches: %d\n",
+		report.Compared, report.Differing, report.NaNMismatch))
+	must(fmt.Printf("Max absolute: %g\nMean absolute: %g\n",
+		report.MaxAbsolute, report.MeanAbsolute))
+	must(fmt.Printf("Max relative: %g\nMean relative: %g\n",
+		report.MaxRelative, report.MeanRelative))
+
+	if len(report.Rows) > 0 {
+		must(fmt.Printf("Differing rows: %d\n", len(report.Rows)))
+		must(fmt.Println("\trow\tdiffering\tmax absolute\tmax relative"))
+		for i, row := range report.Rows {
+			if *maxRows >= 0 && i >= *maxRows {
+				must(fmt.Println("\t..."))
+				break
+			}
+			must(fmt.Printf("\t%d\t%d\t%g\t%g\n", row.Id, row.Differing,
+				row.MaxAbsolute, row.MaxRelative))
+		}
+	}
+
+	if !report.Equal() {
+		os.Exit(1)
+	}
+}
@@ -6,6 +6,7 @@ package main
 import (
 	"bufio"
 	"flag"
+	"fmt"
 	"os"
 	"strconv"
 	"strings"
@@ -30,6 +31,33 @@ var (
 		"row_keep", false, "if true, keep the rows, instead of removing them")
 	colsInverted = flag.Bool(
 		"col_keep", false, "if true, keep the columns, instead of removing them")
+
+	rowIdRange = flag.String(
+		"row_id_range", "", "if set, keep only rows with ids in the inclusive "+
+			"range <first>-<last>")
+	colIdRange = flag.String(
+		"col_id_range", "", "if set, keep only columns with ids in the "+
+			"inclusive range <first>-<last>")
+	rowDropNaN = flag.Bool(
+		"row_drop_nan", false, "if true, drop rows containing any NaN")
+	colDropNaN = flag.Bool(
+		"col_drop_nan", false, "if true, drop columns containing any NaN")
+	rowMinNorm = flag.Float64(
+		"row_min_norm", 0, "if > 0, keep only rows with at least this norm")
+	colMinNorm = flag.Float64(
+		"col_min_norm", 0, "if > 0, keep only columns with at least this norm")
+	rowTopVar = flag.Int(
+		"row_top_var", 0, "if > 0, keep only this many rows with the highest "+
+			"variance")
+	colTopVar = flag.Int(
+		"col_top_var", 0, "if > 0, keep only this many columns with the "+
+			"highest variance")
+	rowSample = flag.Int(
+		"row_sample", 0, "if > 0, keep a random sample of this many rows")
+	colSample = flag.Int(
+		"col_sample", 0, "if > 0, keep a random sample of this many columns")
+	seed = flag.Int64(
+		"seed", 0, "random seed for -row_sample and -col_sample")
 )
 
 func getIds(flagval, path string) []mmm.Ident {
@@ -71,6 +99,63 @@ func getIds(flagval, path string) []mmm.Ident {
 	return ids
 }
 
+func parseIdRange(val string) (first, last mmm.Ident) {
+	parts := strings.Split(val, "-")
+	if len(parts) != 2 {
+		panic(fmt.Sprintf("invalid id range %q", val))
+	}
+	first_id, err := strconv.ParseUint(strings.TrimSpace(parts[0]), 10, 32)
+	if err != nil {
+		panic(err)
+	}
+	last_id, err := strconv.ParseUint(strings.TrimSpace(parts[1]), 10, 32)
+	if err != nil {
+		panic(err)
+	}
+	return mmm.Ident(first_id), mmm.Ident(last_id)
+}
+
+// getOptions builds the predicate filters from the command line flags. They
+// are applied in the order: id ranges, NaN removal, minimum norm, top
+// variance, and finally random sampling.
+func getOptions() (options []mmm.FilterOption) {
+	if *rowIdRange != "" {
+		first, last := parseIdRange(*rowIdRange)
+		options = append(options, mmm.KeepIdRange(mmm.Rows, first, last))
+	}
+	if *colIdRange != "" {
+		first, last := parseIdRange(*colIdRange)
+		options = append(options, mmm.KeepIdRange(mmm.Cols, first, last))
+	}
+	if *rowDropNaN {
+		options = append(options, mmm.DropNaN(mmm.Rows))
+	}
+	if *colDropNaN {
+		options = append(options, mmm.DropNaN(mmm.Cols))
+	}
+	if *rowMinNorm > 0 {
+		options = append(options, mmm.KeepNormAbove(mmm.Rows, *rowMinNorm))
+	}
+	if *colMinNorm > 0 {
+		options = append(options, mmm.KeepNormAbove(mmm.Cols, *colMinNorm))
+	}
+	if *rowTopVar > 0 {
+		options = append(options, mmm.KeepTopVariance(mmm.Rows, *rowTopVar))
+	}
+	if *colTopVar > 0 {
+		options = append(options, mmm.KeepTopVariance(mmm.Cols, *colTopVar))
+	}
+	if *rowSample > 0 {
+		options = append(options,
+			mmm.KeepRandomSample(mmm.Rows, *rowSample, *seed))
+	}
+	if *colSample > 0 {
+		options = append(options,
+			mmm.KeepRandomSample(mmm.Cols, *colSample, *seed))
+	}
+	return options
+}
+
 func main() {
 	flag.Parse()
 
@@ -83,7 +168,8 @@ func main() {
 
 	err := mmm.Filter(*outputPath, *inputPath,
 		getIds(*rowsFlag, *rowsPathFlag), *rowsInverted,
-		getIds(*colsFlag, *colsPathFlag), *colsInverted)
+		getIds(*colsFlag, *colsPathFlag), *colsInverted,
+		getOptions()...)
 	if err != nil {
 		panic(err)
 	}
@@ -11,21 +11,14 @@ import (
 
 func main() {
 	flag.Parse()
-	for _, path := range flag.Args() {
-		fh, err := mmm.Open(path)
-		if err != nil {
-			panic(err)
-		}
-		defer fh.Close()
 
-		for idx := 0; idx < fh.Rows(); idx++ {
-			row := fh.RowByIdx(idx)
-			for col := range row {
-				row[col] = -row[col]
-			}
-		}
-
-		err = fh.Close()
+	// equivalent to mmmapply -e '-x'
+	expr, err := mmm.ParseExpr("-x")
+	if err != nil {
+		panic(err)
+	}
+	for _, path := range flag.Args() {
+		err = mmm.Apply("", path, expr, 0)
 		if err != nil {
 			panic(err)
 		}
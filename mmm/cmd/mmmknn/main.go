@@ -0,0 +1,51 @@
+// Copyright (C) 2017 JT Olds
+// See LICENSE for copying information
+
+package main
+
+import (
+	"flag"
+	"fmt"
+
+	"github.com/jtolds/golincs/mmm"
+)
+
+var (
+	topK       = flag.Int("k", 50, "number of neighbors to keep per row")
+	metricFlag = flag.String("metric", "cosine",
+		"similarity metric. can be 'cosine', 'pearson', or 'spearman'")
+	blockRows = flag.Int("block_rows", 4096,
+		"rows to hold in memory at a time")
+	workers = flag.Int("workers", 0,
+		"number of goroutines to score with. defaults to GOMAXPROCS")
+)
+
+// mmmknn precomputes, for every row of every given mmm file, its k most
+// similar and k most opposite rows in the same file, from one pass over its
+// rows. They are written next to each input as sidecar TopK files (see
+// mmm.SimilarPath and mmm.OppositePath), which the web dataset picks up on
+// startup.
+func main() {
+	flag.Parse()
+
+	metric, err := mmm.ParseMetric(*metricFlag)
+	if err != nil {
+		panic(err)
+	}
+
+	for _, path := range flag.Args() {
+		fmt.Printf("%s -> %s, %s\n", path, mmm.SimilarPath(path),
+			mmm.OppositePath(path))
+		err = mmm.Correlate(mmm.SimilarPath(path), path, "", mmm.CorrOptions{
+			Metric:       metric,
+			TopK:         *topK,
+			ExcludeSelf:  true,
+			OppositePath: mmm.OppositePath(path),
+			BlockRows:    *blockRows,
+			Workers:      *workers,
+		})
+		if err != nil {
+			panic(err)
+		}
+	}
+}
@@ -0,0 +1,118 @@
+// Copyright (C) 2017 JT Olds
+// See LICENSE for copying information
+
+package main
+
+import (
+	"flag"
+	"fmt"
+
+	"github.com/jtolds/golincs/mmm"
+	"github.com/jtolds/golincs/pca"
+)
+
+var (
+	inputPath    = flag.String("i", "", "input path")
+	loadingsPath = flag.String("loadings", "",
+		"if set, path to write loadings to, with a row per component")
+	scoresPath = flag.String("scores", "",
+		"if set, path to write scores to, with a column per component")
+	meansPath = flag.String("means", "",
+		"if set, path to write the column means to, as a single row")
+	modelPath = flag.String("model", "",
+		"if set, path to save the fitted pca.Model to")
+	components = flag.Int("k", 50, "number of components")
+	oversample = flag.Int("oversample", 10,
+		"number of extra random directions to track")
+	powerIterations = flag.Int("power_iterations", 2,
+		"number of extra passes over the input to refine the components")
+	seed      = flag.Int64("seed", 0, "random seed")
+	blockRows = flag.Int("block_rows", 1024,
+		"number of rows each worker holds in memory at a time")
+	workers = flag.Int("workers", 0,
+		"number of goroutines to use. defaults to GOMAXPROCS")
+)
+
+func writeMeans(path string, h *mmm.Handle, res *pca.RandomizedResult) {
+	fh, err := mmm.Create(path, 1, int64(h.Cols()))
+	if err != nil {
+		panic(err)
+	}
+	defer fh.Close()
+	copy(fh.ColIds(), h.ColIds())
+	row := fh.RowByIdx(0)
+	for j := range row {
+		row[j] = float32(res.Means.At(j, 0))
+	}
+	err = fh.Close()
+	if err != nil {
+		panic(err)
+	}
+}
+
+func main() {
+	flag.Parse()
+
+	if *inputPath == "" {
+		panic("input path (-i) required")
+	}
+	if *loadingsPath == "" && *scoresPath == "" && *modelPath == "" {
+		panic("at least one of -loadings, -scores or -model required")
+	}
+
+	fh, err := mmm.Open(*inputPath)
+	if err != nil {
+		panic(err)
+	}
+	defer fh.Close()
+
+	res, err := pca.Randomized(fh, pca.RandomizedOptions{
+		Components:      *components,
+		Oversampling:    *oversample,
+		PowerIterations: *powerIterations,
+		Seed:            *seed,
+		BlockRows:       *blockRows,
+		Workers:         *workers,
+	})
+	if err != nil {
+		panic(err)
+	}
+
+	if *loadingsPath != "" {
+		err = res.WriteLoadings(*loadingsPath, fh)
+		if err != nil {
+			panic(err)
+		}
+	}
+	if *scoresPath != "" {
+		err = res.WriteScores(*scoresPath, fh, *workers)
+		if err != nil {
+			panic(err)
+		}
+	}
+	if *meansPath != "" {
+		writeMeans(*meansPath, fh, res)
+	}
+	if *modelPath != "" {
+		err = res.Model.SaveFile(*modelPath)
+		if err != nil {
+			panic(err)
+		}
+	}
+
+	fmt.Printf("total variance: %g (%d passes)\n", res.TotalVariance,
+		res.Passes)
+	fmt.Println("component\tvariance\texplained\tcumulative")
+	cumulative := 0.0
+	for c := 0; c < res.Variance.Len(); c++ {
+		explained := res.ExplainedVariance.At(c, 0)
+		cumulative += explained
+		fmt.Printf("%d\t%g\t%0.6f\t%0.6f\n", c, res.Variance.At(c, 0),
+			explained, cumulative)
+	}
+
+	err = fh.Close()
+	if err != nil {
+		panic(err)
+	}
+}
@@ -0,0 +1,73 @@
+// Copyright (C) 2017 JT Olds
+// See LICENSE for copying information
+
+package main
+
+import (
+	"flag"
+
+	"github.com/jtolds/golincs/mmm"
+	"github.com/jtolds/golincs/pca"
+)
+
+var (
+	inputPath    = flag.String("i", "", "input path")
+	outputPath   = flag.String("o", "", "output path")
+	loadingsPath = flag.String("loadings", "",
+		"path to a loadings matrix with a row per output dimension and "+
+			"columns matching the input's column ids, such as written by "+
+			"mmmpca -loadings")
+	meansPath = flag.String("means", "",
+		"if set, path to a single row matrix of column means to subtract "+
+			"before projecting, such as written by mmmpca -means")
+	modelPath = flag.String("model", "",
+		"path to a saved pca.Model to project with instead of -loadings. "+
+			"the model's means are always subtracted, and the input columns "+
+			"must be in the order the model was fit with")
+	workers = flag.Int("workers", 0,
+		"number of goroutines to use. defaults to GOMAXPROCS")
+)
+
+func main() {
+	flag.Parse()
+
+	if *inputPath == "" {
+		panic("input path (-i) required")
+	}
+	if *outputPath == "" {
+		panic("output path (-o) required")
+	}
+	if (*loadingsPath == "") == (*modelPath == "") {
+		panic("exactly one of -loadings or -model required")
+	}
+
+	if *loadingsPath != "" {
+		err := mmm.Project(*outputPath, *inputPath, *loadingsPath,
+			mmm.ProjectOptions{MeansPath: *meansPath, Workers: *workers})
+		if err != nil {
+			panic(err)
+		}
+		return
+	}
+
+	if *meansPath != "" {
+		panic("-means can't be used with -model")
+	}
+	model, err := pca.LoadFile(*modelPath)
+	if err != nil {
+		panic(err)
+	}
+	fh, err := mmm.Open(*inputPath)
+	if err != nil {
+		panic(err)
+	}
+	defer fh.Close()
+	err = model.WriteScores(*outputPath, fh, *workers)
+	if err != nil {
+		panic(err)
+	}
+	err = fh.Close()
+	if err != nil {
+		panic(err)
+	}
+}
@@ -0,0 +1,130 @@
+// Copyright (C) 2017 JT Olds
+// See LICENSE for copying information
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"math/rand"
+	"sort"
+
+	"github.com/jtolds/golincs/mmm"
+)
+
+var (
+	inputPath  = flag.String("i", "", "input path")
+	outputPath = flag.String("o", "",
+		"output path. defaults to the input path with .q8 appended, which "+
+			"is where the web server looks for it")
+	queries = flag.Int("recall_queries", 100,
+		"number of random rows to measure recall with. 0 skips measuring")
+	recallK          = flag.Int("recall_k", 10, "number of neighbors to measure recall at")
+	recallCandidates = flag.Int("recall_candidates", 100,
+		"number of first pass candidates re-scored exactly to measure recall at")
+	seed    = flag.Int64("seed", 0, "random seed for picking recall queries")
+	workers = flag.Int("workers", 0,
+		"number of goroutines to use. defaults to GOMAXPROCS")
+)
+
+type scored struct {
+	idx   int
+	score float32
+}
+
+// best returns the indexes of the n highest scores.
+func best(scores []float32, n int) []int {
+	all := make([]scored, 0, len(scores))
+	for idx, score := range scores {
+		all = append(all, scored{idx: idx, score: score})
+	}
+	sort.Slice(all, func(i, j int) bool { return all[i].score > all[j].score })
+	if len(all) > n {
+		all = all[:n]
+	}
+	rv := make([]int, 0, len(all))
+	for _, el := range all {
+		rv = append(rv, el.idx)
+	}
+	return rv
+}
+
+func dot(a, b []float32) (sum float32) {
+	for i := range a {
+		sum += a[i] * b[i]
+	}
+	return sum
+}
+
+// measureRecall finds the recallK best rows for random rows of src, both
+// exactly and by re-scoring the best recallCandidates rows of q, and returns
+// the fraction of the exact rows found.
+func measureRecall(src *mmm.Handle, q *mmm.Quantized) float64 {
+	r := rand.New(rand.NewSource(*seed))
+	exact_scores := make([]float32, src.Rows())
+	quantized_scores := make([]float32, src.Rows())
+	found, total := 0, 0
+	for i := 0; i < *queries; i++ {
+		query_idx := r.Intn(src.Rows())
+		query := src.RowByIdx(query_idx)
+		query_q, query_scale := q.RowByIdx(query_idx)
+		mmm.ParallelRows(src.Rows(), *workers, func(idx int) {
+			exact_scores[idx] = dot(query, src.RowByIdx(idx))
+			row, scale := q.RowByIdx(idx)
+			quantized_scores[idx] = float32(mmm.DotQuantized(query_q, row)) *
+				query_scale * scale
+		})
+
+		candidates := best(quantized_scores, *recallCandidates)
+		rescored := make([]float32, len(candidates))
+		for j, idx := range candidates {
+			rescored[j] = exact_scores[idx]
+		}
+		approx := map[int]bool{}
+		for _, j := range best(rescored, *recallK) {
+			approx[candidates[j]] = true
+		}
+		for _, idx := range best(exact_scores, *recallK) {
+			if approx[idx] {
+				found++
+			}
+			total++
+		}
+	}
+	return float64(found) / float64(total)
+}
+
+func main() {
+	flag.Parse()
+
+	if *inputPath == "" {
+		panic("input path (-i) required")
+	}
+	if *outputPath == "" {
+		*outputPath = mmm.QuantizedPath(*inputPath)
+	}
+
+	err := mmm.Quantize(*outputPath, *inputPath, *workers)
+	if err != nil {
+		panic(err)
+	}
+
+	src, err := mmm.Open(*inputPath)
+	if err != nil {
+		panic(err)
+	}
+	defer src.Close()
+	q, err := mmm.OpenQuantized(*outputPath)
+	if err != nil {
+		panic(err)
+	}
+	defer q.Close()
+
+	fmt.Printf("rows: %d\ncols: %d\n", q.Rows(), q.Cols())
+	fmt.Printf("bytes per row: %d (from %d)\n", 4*(1+(q.Cols()+3)/4),
+		4*src.Cols())
+	if *queries > 0 && src.Rows() > 0 {
+		fmt.Printf("recall@%d with %d candidates over %d queries: %0.4f\n",
+			*recallK, *recallCandidates, *queries, measureRecall(src, q))
+	}
+}
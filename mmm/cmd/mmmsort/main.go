@@ -0,0 +1,93 @@
+// Copyright (C) 2017 JT Olds
+// See LICENSE for copying information
+
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/jtolds/golincs/mmm"
+)
+
+var (
+	inputPath  = flag.String("i", "", "input path")
+	outputPath = flag.String("o", "", "output path")
+	rowsFlag   = flag.String("rows", "",
+		"how to order rows. can be 'id', 'norm', 'value=<col id>', or "+
+			"'order=<path to newline-separated list of row ids>'. if empty, row "+
+			"order is unchanged")
+	colsFlag = flag.String("cols", "",
+		"how to order columns. can be 'id', 'norm', 'value=<row id>', or "+
+			"'order=<path to newline-separated list of col ids>'. if empty, "+
+			"column order is unchanged")
+	rowsDesc = flag.Bool("row_desc", false, "if true, sort rows descending")
+	colsDesc = flag.Bool("col_desc", false, "if true, sort columns descending")
+)
+
+func parseId(val string) mmm.Ident {
+	id, err := strconv.ParseUint(strings.TrimSpace(val), 10, 32)
+	if err != nil {
+		panic(err)
+	}
+	return mmm.Ident(id)
+}
+
+func readIds(path string) (ids []mmm.Ident) {
+	fh, err := os.Open(path)
+	if err != nil {
+		panic(err)
+	}
+	defer fh.Close()
+
+	scanner := bufio.NewScanner(fh)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if len(line) == 0 {
+			continue
+		}
+		ids = append(ids, parseId(line))
+	}
+	err = scanner.Err()
+	if err != nil {
+		panic(err)
+	}
+	return ids
+}
+
+func getOrdering(val string, desc bool) mmm.Ordering {
+	switch {
+	case val == "":
+		return nil
+	case val == "id":
+		return mmm.ById(desc)
+	case val == "norm":
+		return mmm.ByNorm(desc)
+	case strings.HasPrefix(val, "value="):
+		return mmm.ByValue(parseId(strings.TrimPrefix(val, "value=")), desc)
+	case strings.HasPrefix(val, "order="):
+		return mmm.ByList(readIds(strings.TrimPrefix(val, "order=")))
+	}
+	panic(fmt.Sprintf("unknown ordering %q", val))
+}
+
+func main() {
+	flag.Parse()
+
+	if *inputPath == "" {
+		panic("input path (-i) required")
+	}
+	if *outputPath == "" {
+		panic("output path (-o) required")
+	}
+
+	err := mmm.Sort(*outputPath, *inputPath,
+		getOrdering(*rowsFlag, *rowsDesc), getOrdering(*colsFlag, *colsDesc))
+	if err != nil {
+		panic(err)
+	}
+}
@@ -0,0 +1,263 @@
+// Copyright (C) 2017 JT Olds
+// See LICENSE for copying information
+
+package mmm
+
+import (
+	"fmt"
+	"os"
+)
+
+// JoinMode determines which ids of the aligned axis end up in the output of
+// Combine.
+type JoinMode int
+
+const (
+	// Union keeps every id that appears in any input, in order of first
+	// appearance. Cells an input doesn't have are set to the fill value.
+	Union JoinMode = iota
+	// Intersection keeps only ids that appear in every input, in the order of
+	// the first input.
+	Intersection
+)
+
+// DuplicatePolicy determines what Combine does with an id that has already
+// been seen.
+type DuplicatePolicy int
+
+const (
+	// DuplicateError fails the combine.
+	DuplicateError DuplicatePolicy = iota
+	// DuplicateKeepFirst keeps the first occurrence and drops the rest.
+	DuplicateKeepFirst
+	// DuplicateSuffix keeps every occurrence of an id on the combined axis,
+	// giving later occurrences new ids above the largest id of any input.
+	// Duplicates on the aligned axis are handled like DuplicateKeepFirst.
+	DuplicateSuffix
+)
+
+type CombineOptions struct {
+	// Axis is the axis inputs are concatenated along. If Axis is Rows, the
+	// output has every input's rows, with columns aligned by id. If Axis is
+	// Cols, the output has every input's columns, with rows aligned by id.
+	Axis       Axis
+	Join       JoinMode
+	Fill       float32
+	Duplicates DuplicatePolicy
+
+	// Renamed, if not nil, is called for every id DuplicateSuffix replaces.
+	// input is the index of the input path the id came from.
+	Renamed func(input int, old, new Ident)
+}
+
+func axisIds(h *Handle, axis Axis) []Ident {
+	if axis == Rows {
+		return h.RowIds()
+	}
+	return h.ColIds()
+}
+
+type combineSource struct {
+	input, idx int
+}
+
+// alignedIds returns the ids of the aligned axis for the output, along with,
+// for every input, a mapping from output index to that input's index (or -1
+// if the input doesn't have the id).
+func alignedIds(handles []*Handle, paths []string, opts CombineOptions) (
+	ids []Ident, mappings [][]int, err error) {
+	axis := Cols
+	if opts.Axis == Cols {
+		axis = Rows
+	}
+
+	indexes := make([]map[Ident]int, len(handles))
+	for i, h := range handles {
+		hids := axisIds(h, axis)
+		indexes[i] = make(map[Ident]int, len(hids))
+		for idx, id := range hids {
+			if _, exists := indexes[i][id]; exists {
+				if opts.Duplicates == DuplicateError {
+					return nil, nil, fmt.Errorf("duplicate id %d in %q", id, paths[i])
+				}
+				continue
+			}
+			indexes[i][id] = idx
+		}
+	}
+
+	if len(handles) > 0 {
+		switch opts.Join {
+		case Union:
+			seen := map[Ident]bool{}
+			for _, h := range handles {
+				for _, id := range axisIds(h, axis) {
+					if !seen[id] {
+						seen[id] = true
+						ids = append(ids, id)
+					}
+				}
+			}
+		case Intersection:
+			seen := map[Ident]bool{}
+			for _, id := range axisIds(handles[0], axis) {
+				if seen[id] {
+					continue
+				}
+				seen[id] = true
+				in_all := true
+				for _, index := range indexes[1:] {
+					if _, found := index[id]; !found {
+						in_all = false
+						break
+					}
+				}
+				if in_all {
+					ids = append(ids, id)
+				}
+			}
+		default:
+			return nil, nil, fmt.Errorf("unknown join mode %d", opts.Join)
+		}
+	}
+
+	mappings = make([][]int, len(handles))
+	for i := range handles {
+		mappings[i] = make([]int, len(ids))
+		for j, id := range ids {
+			idx, found := indexes[i][id]
+			if !found {
+				idx = -1
+			}
+			mappings[i][j] = idx
+		}
+	}
+	return ids, mappings, nil
+}
+
+// combinedIds returns the ids of the combined axis for the output, along with
+// where each output index comes from.
+func combinedIds(handles []*Handle, paths []string, opts CombineOptions) (
+	ids []Ident, sources []combineSource, err error) {
+	next_id := uint64(0)
+	for _, h := range handles {
+		for _, id := range axisIds(h, opts.Axis) {
+			if uint64(id) >= next_id {
+				next_id = uint64(id) + 1
+			}
+		}
+	}
+
+	seen := map[Ident]bool{}
+	for i, h := range handles {
+		for idx, id := range axisIds(h, opts.Axis) {
+			if seen[id] {
+				switch opts.Duplicates {
+				case DuplicateError:
+					return nil, nil, fmt.Errorf("duplicate id %d in %q", id, paths[i])
+				case DuplicateKeepFirst:
+					continue
+				case DuplicateSuffix:
+					if next_id > uint64(maxUint32) {
+						return nil, nil, fmt.Errorf("ran out of ids renaming duplicates")
+					}
+					new_id := Ident(next_id)
+					next_id++
+					if opts.Renamed != nil {
+						opts.Renamed(i, id, new_id)
+					}
+					id = new_id
+				default:
+					return nil, nil, fmt.Errorf("unknown duplicate policy %d",
+						opts.Duplicates)
+				}
+			}
+			seen[id] = true
+			ids = append(ids, id)
+			sources = append(sources, combineSource{input: i, idx: idx})
+		}
+	}
+	return ids, sources, nil
+}
+
+// Combine concatenates the matrices at src_paths into a new matrix at
+// dst_path, aligning the other axis by id. See CombineOptions.
+func Combine(dst_path string, src_paths []string, opts CombineOptions) (
+	err error) {
+	var handles []*Handle
+	defer func() {
+		for _, h := range handles {
+			h.Close()
+		}
+	}()
+	for _, path := range src_paths {
+		h, err := Open(path)
+		if err != nil {
+			return err
+		}
+		handles = append(handles, h)
+	}
+
+	aligned, mappings, err := alignedIds(handles, src_paths, opts)
+	if err != nil {
+		return err
+	}
+	combined, sources, err := combinedIds(handles, src_paths, opts)
+	if err != nil {
+		return err
+	}
+
+	rows, cols := len(combined), len(aligned)
+	if opts.Axis == Cols {
+		rows, cols = cols, rows
+	}
+
+	dst, err := Create(dst_path, int64(rows), int64(cols))
+	if err != nil {
+		return err
+	}
+	defer func() {
+		dst.Close()
+		if err != nil {
+			os.Remove(dst_path)
+		}
+	}()
+
+	if opts.Axis == Rows {
+		copy(dst.RowIds(), combined)
+		copy(dst.ColIds(), aligned)
+		for row_idx, source := range sources {
+			src_row := handles[source.input].RowByIdx(source.idx)
+			dst_row := dst.RowByIdx(row_idx)
+			for col_idx, src_col_idx := range mappings[source.input] {
+				if src_col_idx < 0 {
+					dst_row[col_idx] = opts.Fill
+				} else {
+					dst_row[col_idx] = src_row[src_col_idx]
+				}
+			}
+		}
+	} else {
+		copy(dst.RowIds(), aligned)
+		copy(dst.ColIds(), combined)
+		src_rows := make([][]float32, len(handles))
+		for row_idx := range aligned {
+			for i, h := range handles {
+				src_rows[i] = nil
+				if src_row_idx := mappings[i][row_idx]; src_row_idx >= 0 {
+					src_rows[i] = h.RowByIdx(src_row_idx)
+				}
+			}
+			dst_row := dst.RowByIdx(row_idx)
+			for col_idx, source := range sources {
+				if src_rows[source.input] == nil {
+					dst_row[col_idx] = opts.Fill
+				} else {
+					dst_row[col_idx] = src_rows[source.input][source.idx]
+				}
+			}
+		}
+	}
+
+	return dst.Close()
+}
@@ -0,0 +1,517 @@
+// Copyright (C) 2017 JT Olds
+// See LICENSE for copying information
+
+package mmm
+
+import (
+	"container/heap"
+	"fmt"
+	"io/ioutil"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+)
+
+// Metric is a row similarity measure.
+type Metric int
+
+const (
+	Cosine Metric = iota
+	Pearson
+	Spearman
+)
+
+func ParseMetric(name string) (Metric, error) {
+	switch name {
+	case "cosine":
+		return Cosine, nil
+	case "pearson":
+		return Pearson, nil
+	case "spearman":
+		return Spearman, nil
+	}
+	return 0, fmt.Errorf("unknown metric %q", name)
+}
+
+func (m Metric) String() string {
+	switch m {
+	case Cosine:
+		return "cosine"
+	case Pearson:
+		return "pearson"
+	case Spearman:
+		return "spearman"
+	}
+	return fmt.Sprintf("Metric(%d)", int(m))
+}
+
+// PrepareRow writes a transformed copy of src to dst such that the dot
+// product of two prepared rows is their similarity under m. Cosine
+// normalizes the row to unit length, Pearson centers it first, and Spearman
+// replaces values with their ranks before centering. NaNs become zero after
+// the transformation, so they don't contribute to any score.
+func (m Metric) PrepareRow(dst, src []float32) {
+	switch m {
+	case Spearman:
+		rankRow(dst, src)
+		centerRow(dst)
+	case Pearson:
+		copy(dst, src)
+		centerRow(dst)
+	default:
+		copy(dst, src)
+	}
+	var squared_sum float64
+	for i, v := range dst {
+		if math.IsNaN(float64(v)) {
+			dst[i] = 0
+			continue
+		}
+		squared_sum += float64(v) * float64(v)
+	}
+	if squared_sum == 0 {
+		return
+	}
+	mag := math.Sqrt(squared_sum)
+	for i := range dst {
+		dst[i] = float32(float64(dst[i]) / mag)
+	}
+}
+
+func centerRow(row []float32) {
+	var sum float64
+	var count int
+	for _, v := range row {
+		if !math.IsNaN(float64(v)) {
+			sum += float64(v)
+			count++
+		}
+	}
+	if count == 0 {
+		return
+	}
+	mean := sum / float64(count)
+	for i := range row {
+		row[i] = float32(float64(row[i]) - mean)
+	}
+}
+
+// rankRow writes the rank of every value of src into dst, averaging ties.
+// NaNs keep NaN ranks.
+func rankRow(dst, src []float32) {
+	order := make([]int, 0, len(src))
+	for i, v := range src {
+		if math.IsNaN(float64(v)) {
+			dst[i] = v
+			continue
+		}
+		order = append(order, i)
+	}
+	sort.Slice(order, func(i, j int) bool { return src[order[i]] < src[order[j]] })
+	for start := 0; start < len(order); {
+		end := start + 1
+		for end < len(order) && src[order[end]] == src[order[start]] {
+			end++
+		}
+		rank := float32(start+end-1) / 2
+		for _, idx := range order[start:end] {
+			dst[idx] = rank
+		}
+		start = end
+	}
+}
+
+func dot32(a, b []float32) float32 {
+	var s0, s1, s2, s3 float32
+	b = b[:len(a)]
+	i := 0
+	for ; i+4 <= len(a); i += 4 {
+		s0 += a[i] * b[i]
+		s1 += a[i+1] * b[i+1]
+		s2 += a[i+2] * b[i+2]
+		s3 += a[i+3] * b[i+3]
+	}
+	for ; i < len(a); i++ {
+		s0 += a[i] * b[i]
+	}
+	return (s0 + s1) + (s2 + s3)
+}
+
+// Neighbor is a single scored entry of a top-k list.
+type Neighbor struct {
+	Id    Ident
+	Score float32
+}
+
+// neighborHeap keeps the k best neighbors seen so far, with the worst of
+// them on top. If lowest is true, "best" means lowest score.
+type neighborHeap struct {
+	items  []Neighbor
+	lowest bool
+}
+
+func (h *neighborHeap) Len() int      { return len(h.items) }
+func (h *neighborHeap) Swap(i, j int) { h.items[i], h.items[j] = h.items[j], h.items[i] }
+func (h *neighborHeap) Less(i, j int) bool {
+	if h.lowest {
+		return h.items[i].Score > h.items[j].Score
+	}
+	return h.items[i].Score < h.items[j].Score
+}
+func (h *neighborHeap) Push(x interface{}) { h.items = append(h.items, x.(Neighbor)) }
+func (h *neighborHeap) Pop() (x interface{}) {
+	x, h.items = h.items[len(h.items)-1], h.items[:len(h.items)-1]
+	return x
+}
+
+func (h *neighborHeap) offer(k int, n Neighbor) {
+	if len(h.items) < k {
+		heap.Push(h, n)
+		return
+	}
+	if h.lowest && n.Score >= h.items[0].Score ||
+		!h.lowest && n.Score <= h.items[0].Score {
+		return
+	}
+	h.items[0] = n
+	heap.Fix(h, 0)
+}
+
+// sorted returns the neighbors best first, emptying the heap.
+func (h *neighborHeap) sorted() []Neighbor {
+	rv := make([]Neighbor, len(h.items))
+	for i := len(rv) - 1; i >= 0; i-- {
+		rv[i] = heap.Pop(h).(Neighbor)
+	}
+	return rv
+}
+
+type CorrOptions struct {
+	Metric Metric
+
+	// TopK, if positive, writes a TopK file keeping only the k best scoring
+	// columns for every row, instead of the full similarity matrix.
+	TopK int
+	// Lowest keeps the k lowest scores instead of the highest when TopK is
+	// set, which finds the most opposite rows.
+	Lowest bool
+	// ExcludeSelf skips pairs of rows with the same id when TopK is set.
+	ExcludeSelf bool
+	// OppositePath, if set along with TopK, is where a second TopK file is
+	// written, keeping the k scores from the other end of every row's
+	// ranking, from the same pass over the rows. With Lowest unset, that's
+	// the k lowest scores.
+	OppositePath string
+
+	// BlockRows is how many rows of the first matrix are held in memory at a
+	// time. The second matrix is streamed through once per block, and scored
+	// against the whole block with a matrix multiplication per small block of
+	// its own rows. If <= 0, 4096 is used.
+	//
+	// If the first matrix takes more than one block, the second is prepared
+	// for Metric once up front, into a temporary file next to the output, so
+	// it isn't prepared again for every block.
+	BlockRows int
+	// Workers is the number of goroutines to score with. If <= 0, GOMAXPROCS
+	// is used.
+	Workers int
+}
+
+const corrInnerBlockRows = 256
+
+// openCorrInputs opens the matrices at a_path and b_path, checking that they
+// have the same columns. b is a if b_path is empty or the same as a_path.
+func openCorrInputs(a_path, b_path string) (a, b *Handle, err error) {
+	a, err = Open(a_path)
+	if err != nil {
+		return nil, nil, err
+	}
+	b = a
+	if b_path != "" && b_path != a_path {
+		b, err = Open(b_path)
+		if err != nil {
+			a.Close()
+			return nil, nil, err
+		}
+	}
+
+	if a.Cols() != b.Cols() {
+		err = fmt.Errorf("column count mismatch")
+	} else {
+		for idx, id := range a.ColIds() {
+			if b.ColIdByIdx(idx) != id {
+				err = fmt.Errorf("column ids don't match")
+				break
+			}
+		}
+	}
+	if err != nil {
+		closeCorrInputs(a, b)
+		return nil, nil, err
+	}
+	return a, b, nil
+}
+
+func closeCorrInputs(a, b *Handle) {
+	if b != a {
+		b.Close()
+	}
+	a.Close()
+}
+
+// correlateBlocks scores every row of a against every row of b, a block of
+// opts.BlockRows rows of a at a time. For every block of a, cb is called
+// with the scores of the block against each small block of b's rows, as
+// ScoreQueries does, and then done is called once the block is fully
+// scored. If a takes more than one block, b is prepared once up front into
+// a temporary file at prepared_path.
+func correlateBlocks(a, b *Handle, prepared_path string, opts CorrOptions,
+	cb func(a_start, a_end, b_start, b_end int, scores []float32) error,
+	done func(a_start, a_end int) error) (err error) {
+	block_rows := opts.BlockRows
+	if block_rows <= 0 {
+		block_rows = 4096
+	}
+	cols := a.Cols()
+
+	score_opts := ScoreOptions{
+		Metric:    opts.Metric,
+		BlockRows: corrInnerBlockRows,
+		Workers:   opts.Workers,
+	}
+	scored := b
+	if a.Rows() > block_rows {
+		scored, err = PrepareMatrix(prepared_path, b, opts.Metric, opts.Workers)
+		if err != nil {
+			return err
+		}
+		defer func() {
+			scored.Close()
+			os.Remove(prepared_path)
+		}()
+		score_opts.Prepared = true
+	}
+
+	a_buf := make([]float32, block_rows*cols)
+	for a_start := 0; a_start < a.Rows(); a_start += block_rows {
+		a_end := a_start + block_rows
+		if a_end > a.Rows() {
+			a_end = a.Rows()
+		}
+		ParallelRows(a_end-a_start, opts.Workers, func(i int) {
+			opts.Metric.PrepareRow(a_buf[i*cols:(i+1)*cols],
+				a.RowByIdx(a_start+i))
+		})
+		err = ScoreQueries(a_buf[:(a_end-a_start)*cols], scored, score_opts,
+			func(b_start, b_end int, scores []float32) error {
+				return cb(a_start, a_end, b_start, b_end, scores)
+			})
+		if err != nil {
+			return err
+		}
+		err = done(a_start, a_end)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// topKOutput is a TopK file Correlate writes, with the heaps that collect
+// the current block of rows' neighbors.
+type topKOutput struct {
+	path   string
+	topk   *TopK
+	heaps  []neighborHeap
+	lowest bool
+}
+
+func (o *topKOutput) reset(k int) {
+	for i := range o.heaps {
+		o.heaps[i] = neighborHeap{
+			items:  make([]Neighbor, 0, k),
+			lowest: o.lowest}
+	}
+}
+
+// flush writes the neighbors collected for count rows starting at start.
+func (o *topKOutput) flush(start, count int) {
+	for i := 0; i < count; i++ {
+		ids, scores := o.topk.NeighborsByIdx(start + i)
+		for j, n := range o.heaps[i].sorted() {
+			ids[j] = n.Id
+			scores[j] = n.Score
+		}
+	}
+}
+
+// Correlate computes the similarity between every row of the matrix at
+// a_path and every row of the matrix at b_path, or between every pair of rows
+// of a_path if b_path is empty. Both matrices must have the same column ids
+// in the same order. The output at dst_path has a row for every row of a_path
+// and, unless opts.TopK is set, a column for every row of b_path. Memory use
+// is bounded by opts.BlockRows prepared rows of a_path, plus a small block of
+// prepared rows of b_path per pass, plus a prepared copy of b_path on disk if
+// a_path takes more than one block.
+func Correlate(dst_path, a_path, b_path string, opts CorrOptions) (
+	err error) {
+	a, b, err := openCorrInputs(a_path, b_path)
+	if err != nil {
+		return err
+	}
+	defer closeCorrInputs(a, b)
+
+	var full *Handle
+	var outputs []*topKOutput
+	if opts.TopK > 0 {
+		outputs = append(outputs, &topKOutput{
+			path: dst_path, lowest: opts.Lowest})
+		if opts.OppositePath != "" {
+			outputs = append(outputs, &topKOutput{
+				path: opts.OppositePath, lowest: !opts.Lowest})
+		}
+		block_rows := opts.BlockRows
+		if block_rows <= 0 || block_rows > a.Rows() {
+			block_rows = a.Rows()
+		}
+		defer func() {
+			for _, o := range outputs {
+				if o.topk == nil {
+					continue
+				}
+				o.topk.Close()
+				if err != nil {
+					os.Remove(o.path)
+					os.Remove(topKIdsPath(o.path))
+				}
+			}
+		}()
+		for _, o := range outputs {
+			o.topk, err = CreateTopK(o.path, int64(a.Rows()), int64(opts.TopK))
+			if err != nil {
+				return err
+			}
+			for idx, id := range a.RowIds() {
+				o.topk.SetRowId(idx, id)
+			}
+			o.heaps = make([]neighborHeap, block_rows)
+			o.reset(opts.TopK)
+		}
+	} else {
+		full, err = Create(dst_path, int64(a.Rows()), int64(b.Rows()))
+		if err != nil {
+			return err
+		}
+		defer func() {
+			full.Close()
+			if err != nil {
+				os.Remove(dst_path)
+			}
+		}()
+		copy(full.RowIds(), a.RowIds())
+		copy(full.ColIds(), b.RowIds())
+	}
+
+	err = correlateBlocks(a, b, dst_path+".prepared", opts,
+		func(a_start, a_end, b_start, b_end int, scores []float32) error {
+			b_count := b_end - b_start
+			ParallelRows(a_end-a_start, opts.Workers, func(i int) {
+				row := scores[i*b_count : (i+1)*b_count]
+				if full != nil {
+					copy(full.RowByIdx(a_start + i)[b_start:b_end], row)
+					return
+				}
+				a_id := a.RowIdByIdx(a_start + i)
+				for j, score := range row {
+					b_id := b.RowIdByIdx(b_start + j)
+					if opts.ExcludeSelf && a_id == b_id {
+						continue
+					}
+					n := Neighbor{Id: b_id, Score: score}
+					for _, o := range outputs {
+						o.heaps[i].offer(opts.TopK, n)
+					}
+				}
+			})
+			return nil
+		},
+		func(a_start, a_end int) error {
+			for _, o := range outputs {
+				o.flush(a_start, a_end-a_start)
+				o.reset(opts.TopK)
+			}
+			return nil
+		})
+	if err != nil {
+		return err
+	}
+
+	if full != nil {
+		return full.Close()
+	}
+	for _, o := range outputs {
+		err = o.topk.Close()
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// CorrelatePairs scores pairs of rows like Correlate, but instead of writing
+// an output file, it calls cb for every pair scoring at least threshold, or
+// at most threshold if opts.Lowest is set. opts.TopK is ignored. Pairs are
+// passed to cb as they're scored, a small block at a time, so memory use
+// doesn't grow with the number of pairs. cb is never called concurrently. If
+// cb returns an error, scoring stops and the error is returned.
+func CorrelatePairs(a_path, b_path string, opts CorrOptions,
+	threshold float32, cb func(a_id, b_id Ident, score float32) error) error {
+	a, b, err := openCorrInputs(a_path, b_path)
+	if err != nil {
+		return err
+	}
+	defer closeCorrInputs(a, b)
+
+	tmpdir, err := ioutil.TempDir("", "mmmcorr")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(tmpdir)
+
+	var passing [][]Neighbor
+	return correlateBlocks(a, b, filepath.Join(tmpdir, "prepared"), opts,
+		func(a_start, a_end, b_start, b_end int, scores []float32) error {
+			a_count, b_count := a_end-a_start, b_end-b_start
+			if len(passing) < a_count {
+				passing = make([][]Neighbor, a_count)
+			}
+			ParallelRows(a_count, opts.Workers, func(i int) {
+				a_id := a.RowIdByIdx(a_start + i)
+				passing[i] = passing[i][:0]
+				for j, score := range scores[i*b_count : (i+1)*b_count] {
+					pass := score >= threshold
+					if opts.Lowest {
+						pass = score <= threshold
+					}
+					b_id := b.RowIdByIdx(b_start + j)
+					if !pass || opts.ExcludeSelf && a_id == b_id {
+						continue
+					}
+					passing[i] = append(passing[i],
+						Neighbor{Id: b_id, Score: score})
+				}
+			})
+			for i := 0; i < a_count; i++ {
+				a_id := a.RowIdByIdx(a_start + i)
+				for _, n := range passing[i] {
+					err := cb(a_id, n.Id, n.Score)
+					if err != nil {
+						return err
+					}
+				}
+			}
+			return nil
+		},
+		func(a_start, a_end int) error { return nil })
+}
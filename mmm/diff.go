@@ -0,0 +1,146 @@
+// Copyright (C) 2017 JT Olds
+// See LICENSE for copying information
+
+package mmm
+
+import (
+	"math"
+)
+
+// DiffOptions controls when two values are considered different. Values a
+// and b are equal if |a - b| <= AbsTolerance + RelTolerance * max(|a|, |b|).
+// Two NaNs are equal to each other and different from everything else.
+type DiffOptions struct {
+	AbsTolerance float64
+	RelTolerance float64
+}
+
+// RowDiff describes a row that has at least one value outside of tolerance.
+type RowDiff struct {
+	Id          Ident
+	Differing   int
+	MaxAbsolute float64
+	MaxRelative float64
+}
+
+// DiffReport summarizes the differences between two matrices. Value
+// statistics only cover cells whose row and column ids are in both matrices.
+type DiffReport struct {
+	RowsOnlyInA, RowsOnlyInB []Ident
+	ColsOnlyInA, ColsOnlyInB []Ident
+
+	Compared     int
+	Differing    int
+	NaNMismatch  int
+	MaxAbsolute  float64
+	MeanAbsolute float64
+	MaxRelative  float64
+	MeanRelative float64
+
+	// Rows lists every row with at least one differing value, in the order of
+	// the first matrix.
+	Rows []RowDiff
+}
+
+// Equal returns true if both matrices had the same ids and every compared
+// value was within tolerance.
+func (r *DiffReport) Equal() bool {
+	return len(r.RowsOnlyInA) == 0 && len(r.RowsOnlyInB) == 0 &&
+		len(r.ColsOnlyInA) == 0 && len(r.ColsOnlyInB) == 0 &&
+		r.Differing == 0
+}
+
+func onlyIn(ids []Ident, other func(Ident) (int, bool)) (rv []Ident) {
+	for _, id := range ids {
+		if _, found := other(id); !found {
+			rv = append(rv, id)
+		}
+	}
+	return rv
+}
+
+// Diff compares the matrices at a_path and b_path, aligning rows and columns
+// by id, so the files don't need to share an ordering.
+func Diff(a_path, b_path string, opts DiffOptions) (*DiffReport, error) {
+	a, err := Open(a_path)
+	if err != nil {
+		return nil, err
+	}
+	defer a.Close()
+	b, err := Open(b_path)
+	if err != nil {
+		return nil, err
+	}
+	defer b.Close()
+
+	r := &DiffReport{
+		RowsOnlyInA: onlyIn(a.RowIds(), b.RowIdxById),
+		RowsOnlyInB: onlyIn(b.RowIds(), a.RowIdxById),
+		ColsOnlyInA: onlyIn(a.ColIds(), b.ColIdxById),
+		ColsOnlyInB: onlyIn(b.ColIds(), a.ColIdxById),
+	}
+
+	// col_map[a_col_idx] is the corresponding column index in b, or -1.
+	col_map := make([]int, a.Cols())
+	for a_col_idx, id := range a.ColIds() {
+		b_col_idx, found := b.ColIdxById(id)
+		if !found {
+			b_col_idx = -1
+		}
+		col_map[a_col_idx] = b_col_idx
+	}
+
+	// numeric counts the pairs that went into abs_sum and rel_sum, which
+	// leaves out pairs with a NaN on either side.
+	var abs_sum, rel_sum float64
+	var numeric int
+	for a_row_idx, id := range a.RowIds() {
+		b_row, found := b.RowById(id)
+		if !found {
+			continue
+		}
+		a_row := a.RowByIdx(a_row_idx)
+		row := RowDiff{Id: id}
+		for a_col_idx, b_col_idx := range col_map {
+			if b_col_idx < 0 {
+				continue
+			}
+			av, bv := float64(a_row[a_col_idx]), float64(b_row[b_col_idx])
+			r.Compared++
+			if math.IsNaN(av) || math.IsNaN(bv) {
+				if math.IsNaN(av) != math.IsNaN(bv) {
+					r.NaNMismatch++
+					row.Differing++
+				}
+				continue
+			}
+			abs := math.Abs(av - bv)
+			scale := math.Max(math.Abs(av), math.Abs(bv))
+			var rel float64
+			if scale > 0 {
+				rel = abs / scale
+			}
+			abs_sum += abs
+			rel_sum += rel
+			numeric++
+			r.MaxAbsolute = math.Max(r.MaxAbsolute, abs)
+			r.MaxRelative = math.Max(r.MaxRelative, rel)
+			if abs > opts.AbsTolerance+opts.RelTolerance*scale {
+				row.Differing++
+				row.MaxAbsolute = math.Max(row.MaxAbsolute, abs)
+				row.MaxRelative = math.Max(row.MaxRelative, rel)
+			}
+		}
+		if row.Differing > 0 {
+			r.Differing += row.Differing
+			r.Rows = append(r.Rows, row)
+		}
+	}
+
+	if numeric > 0 {
+		r.MeanAbsolute = abs_sum / float64(numeric)
+		r.MeanRelative = rel_sum / float64(numeric)
+	}
+
+	return r, nil
+}
@@ -0,0 +1,374 @@
+// Copyright (C) 2017 JT Olds
+// See LICENSE for copying information
+
+package mmm
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// Stats are summary statistics over a single row or column.
+type Stats struct {
+	Mean, Std, Min, Max, Norm float64
+}
+
+func computeStats(vals []float32) (s Stats) {
+	if len(vals) == 0 {
+		return s
+	}
+	s.Min, s.Max = math.Inf(1), math.Inf(-1)
+	var sum, squared_sum float64
+	for _, v := range vals {
+		f := float64(v)
+		sum += f
+		squared_sum += f * f
+		if f < s.Min {
+			s.Min = f
+		}
+		if f > s.Max {
+			s.Max = f
+		}
+	}
+	n := float64(len(vals))
+	s.Mean = sum / n
+	s.Std = math.Sqrt(math.Max(squared_sum/n-s.Mean*s.Mean, 0))
+	s.Norm = math.Sqrt(squared_sum)
+	return s
+}
+
+type exprEnv struct {
+	x        float64
+	row, col *Stats
+}
+
+type exprNode func(env *exprEnv) float64
+
+// Expr is a compiled elementwise expression. Expressions are written over
+// the current value x, and the statistics of the value's row and column,
+// available as row_mean, row_std, row_min, row_max, row_norm, col_mean,
+// col_std, col_min, col_max and col_norm. Supported operators are +, -, *, /
+// and ^, and the supported functions are abs, sign, sqrt, exp, log, log2,
+// log10, min, max, clip(v, lo, hi) and threshold(v, t), which maps values
+// above t to 1, values below -t to -1, and everything else to 0.
+type Expr struct {
+	source  string
+	root    exprNode
+	rowVars bool
+	colVars bool
+}
+
+// ParseExpr compiles an expression such as "log2(abs(x)+1)" or
+// "clip((x-row_mean)/row_std, -3, 3)".
+func ParseExpr(source string) (*Expr, error) {
+	p := &exprParser{source: source}
+	err := p.lex()
+	if err != nil {
+		return nil, err
+	}
+	e := &Expr{source: source}
+	p.expr = e
+	root, err := p.parseSum()
+	if err != nil {
+		return nil, err
+	}
+	if p.pos < len(p.tokens) {
+		return nil, fmt.Errorf("unexpected %q in expression %q",
+			p.tokens[p.pos], source)
+	}
+	e.root = root
+	return e, nil
+}
+
+func (e *Expr) String() string { return e.source }
+
+// Eval evaluates the expression for a single value with the given row and
+// column statistics. Either statistics argument may be nil if the expression
+// doesn't need it.
+func (e *Expr) Eval(x float64, row, col *Stats) float64 {
+	return e.root(&exprEnv{x: x, row: row, col: col})
+}
+
+var exprFuncs = map[string]struct {
+	args int
+	fn   func(args []float64) float64
+}{
+	"abs":   {1, func(a []float64) float64 { return math.Abs(a[0]) }},
+	"sqrt":  {1, func(a []float64) float64 { return math.Sqrt(a[0]) }},
+	"exp":   {1, func(a []float64) float64 { return math.Exp(a[0]) }},
+	"log":   {1, func(a []float64) float64 { return math.Log(a[0]) }},
+	"log2":  {1, func(a []float64) float64 { return math.Log2(a[0]) }},
+	"log10": {1, func(a []float64) float64 { return math.Log10(a[0]) }},
+	"min":   {2, func(a []float64) float64 { return math.Min(a[0], a[1]) }},
+	"max":   {2, func(a []float64) float64 { return math.Max(a[0], a[1]) }},
+	"sign": {1, func(a []float64) float64 {
+		switch {
+		case a[0] > 0:
+			return 1
+		case a[0] < 0:
+			return -1
+		}
+		return 0
+	}},
+	"clip": {3, func(a []float64) float64 {
+		return math.Max(a[1], math.Min(a[2], a[0]))
+	}},
+	"threshold": {2, func(a []float64) float64 {
+		switch {
+		case a[0] > a[1]:
+			return 1
+		case a[0] < -a[1]:
+			return -1
+		}
+		return 0
+	}},
+}
+
+type exprParser struct {
+	source string
+	tokens []string
+	pos    int
+	expr   *Expr
+}
+
+func (p *exprParser) lex() error {
+	runes := []rune(p.source)
+	for i := 0; i < len(runes); {
+		r := runes[i]
+		switch {
+		case unicode.IsSpace(r):
+			i++
+		case strings.ContainsRune("+-*/^(),", r):
+			p.tokens = append(p.tokens, string(r))
+			i++
+		case unicode.IsDigit(r) || r == '.':
+			j := i
+			for j < len(runes) && (unicode.IsDigit(runes[j]) || runes[j] == '.' ||
+				runes[j] == 'e' || runes[j] == 'E' ||
+				((runes[j] == '-' || runes[j] == '+') &&
+					(runes[j-1] == 'e' || runes[j-1] == 'E'))) {
+				j++
+			}
+			p.tokens = append(p.tokens, string(runes[i:j]))
+			i = j
+		case unicode.IsLetter(r) || r == '_':
+			j := i
+			for j < len(runes) && (unicode.IsLetter(runes[j]) ||
+				unicode.IsDigit(runes[j]) || runes[j] == '_') {
+				j++
+			}
+			p.tokens = append(p.tokens, string(runes[i:j]))
+			i = j
+		default:
+			return fmt.Errorf("unexpected character %q in expression %q",
+				r, p.source)
+		}
+	}
+	if len(p.tokens) == 0 {
+		return fmt.Errorf("empty expression")
+	}
+	return nil
+}
+
+func (p *exprParser) peek() string {
+	if p.pos < len(p.tokens) {
+		return p.tokens[p.pos]
+	}
+	return ""
+}
+
+func (p *exprParser) next() string {
+	tok := p.peek()
+	p.pos++
+	return tok
+}
+
+func (p *exprParser) expect(tok string) error {
+	if got := p.next(); got != tok {
+		if got == "" {
+			return fmt.Errorf("expected %q at end of expression %q", tok, p.source)
+		}
+		return fmt.Errorf("expected %q, got %q in expression %q",
+			tok, got, p.source)
+	}
+	return nil
+}
+
+func (p *exprParser) parseSum() (exprNode, error) {
+	left, err := p.parseProduct()
+	if err != nil {
+		return nil, err
+	}
+	for p.peek() == "+" || p.peek() == "-" {
+		op := p.next()
+		right, err := p.parseProduct()
+		if err != nil {
+			return nil, err
+		}
+		l := left
+		if op == "+" {
+			left = func(env *exprEnv) float64 { return l(env) + right(env) }
+		} else {
+			left = func(env *exprEnv) float64 { return l(env) - right(env) }
+		}
+	}
+	return left, nil
+}
+
+func (p *exprParser) parseProduct() (exprNode, error) {
+	left, err := p.parseUnary()
+	if err != nil {
+		return nil, err
+	}
+	for p.peek() == "*" || p.peek() == "/" {
+		op := p.next()
+		right, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		l := left
+		if op == "*" {
+			left = func(env *exprEnv) float64 { return l(env) * right(env) }
+		} else {
+			left = func(env *exprEnv) float64 { return l(env) / right(env) }
+		}
+	}
+	return left, nil
+}
+
+func (p *exprParser) parseUnary() (exprNode, error) {
+	switch p.peek() {
+	case "-":
+		p.next()
+		operand, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		return func(env *exprEnv) float64 { return -operand(env) }, nil
+	case "+":
+		p.next()
+		return p.parseUnary()
+	}
+	return p.parsePower()
+}
+
+func (p *exprParser) parsePower() (exprNode, error) {
+	base, err := p.parseAtom()
+	if err != nil {
+		return nil, err
+	}
+	if p.peek() != "^" {
+		return base, nil
+	}
+	p.next()
+	// right associative, and binds tighter than unary minus on the left
+	exponent, err := p.parseUnary()
+	if err != nil {
+		return nil, err
+	}
+	return func(env *exprEnv) float64 {
+		return math.Pow(base(env), exponent(env))
+	}, nil
+}
+
+func (p *exprParser) parseAtom() (exprNode, error) {
+	tok := p.next()
+	switch {
+	case tok == "":
+		return nil, fmt.Errorf("unexpected end of expression %q", p.source)
+	case tok == "(":
+		inner, err := p.parseSum()
+		if err != nil {
+			return nil, err
+		}
+		return inner, p.expect(")")
+	case unicode.IsDigit([]rune(tok)[0]) || tok[0] == '.':
+		val, err := strconv.ParseFloat(tok, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid number %q in expression %q",
+				tok, p.source)
+		}
+		return func(env *exprEnv) float64 { return val }, nil
+	case p.peek() == "(":
+		return p.parseCall(tok)
+	}
+	return p.variable(tok)
+}
+
+func (p *exprParser) parseCall(name string) (exprNode, error) {
+	fn, found := exprFuncs[name]
+	if !found {
+		return nil, fmt.Errorf("unknown function %q in expression %q",
+			name, p.source)
+	}
+	p.next()
+	var args []exprNode
+	for p.peek() != ")" {
+		if len(args) > 0 {
+			err := p.expect(",")
+			if err != nil {
+				return nil, err
+			}
+		}
+		arg, err := p.parseSum()
+		if err != nil {
+			return nil, err
+		}
+		args = append(args, arg)
+	}
+	p.next()
+	if len(args) != fn.args {
+		return nil, fmt.Errorf("%s expects %d argument(s), got %d",
+			name, fn.args, len(args))
+	}
+	return func(env *exprEnv) float64 {
+		vals := make([]float64, len(args))
+		for i, arg := range args {
+			vals[i] = arg(env)
+		}
+		return fn.fn(vals)
+	}, nil
+}
+
+func statVariable(name string, stats func(env *exprEnv) *Stats) (
+	exprNode, bool) {
+	switch name {
+	case "mean":
+		return func(env *exprEnv) float64 { return stats(env).Mean }, true
+	case "std":
+		return func(env *exprEnv) float64 { return stats(env).Std }, true
+	case "min":
+		return func(env *exprEnv) float64 { return stats(env).Min }, true
+	case "max":
+		return func(env *exprEnv) float64 { return stats(env).Max }, true
+	case "norm":
+		return func(env *exprEnv) float64 { return stats(env).Norm }, true
+	}
+	return nil, false
+}
+
+func (p *exprParser) variable(name string) (exprNode, error) {
+	switch {
+	case name == "x":
+		return func(env *exprEnv) float64 { return env.x }, nil
+	case name == "pi":
+		return func(env *exprEnv) float64 { return math.Pi }, nil
+	case strings.HasPrefix(name, "row_"):
+		if node, ok := statVariable(strings.TrimPrefix(name, "row_"),
+			func(env *exprEnv) *Stats { return env.row }); ok {
+			p.expr.rowVars = true
+			return node, nil
+		}
+	case strings.HasPrefix(name, "col_"):
+		if node, ok := statVariable(strings.TrimPrefix(name, "col_"),
+			func(env *exprEnv) *Stats { return env.col }); ok {
+			p.expr.colVars = true
+			return node, nil
+		}
+	}
+	return nil, fmt.Errorf("unknown variable %q in expression %q",
+		name, p.source)
+}
@@ -4,9 +4,184 @@
 package mmm
 
 import (
+	"math"
+	"math/rand"
 	"os"
+	"sort"
 )
 
+// Axis selects either the rows or the columns of a matrix.
+type Axis int
+
+const (
+	Rows Axis = iota
+	Cols
+)
+
+// FilterOption is an additional predicate for Filter, computed from the data
+// itself. Options are applied in order, and each one only considers the rows
+// and columns that are still kept after the id lists and the options before
+// it.
+type FilterOption func(f *filterState)
+
+type filterState struct {
+	src  *Handle
+	keep [2][]bool
+}
+
+func (f *filterState) size(axis Axis) int {
+	if axis == Rows {
+		return f.src.Rows()
+	}
+	return f.src.Cols()
+}
+
+func (f *filterState) ids(axis Axis) []Ident {
+	if axis == Rows {
+		return f.src.RowIds()
+	}
+	return f.src.ColIds()
+}
+
+func (f *filterState) kept(axis Axis) (rv []int) {
+	for idx, keep := range f.keep[axis] {
+		if keep {
+			rv = append(rv, idx)
+		}
+	}
+	return rv
+}
+
+// stats computes Stats for every kept index along axis, using only the values
+// in kept entries of the other axis.
+func (f *filterState) stats(axis Axis) []Stats {
+	n := f.size(axis)
+	sums := make([]float64, n)
+	squared_sums := make([]float64, n)
+	counts := make([]int, n)
+	stats := make([]Stats, n)
+	for i := range stats {
+		stats[i].Min, stats[i].Max = math.Inf(1), math.Inf(-1)
+	}
+	for row_idx, row_keep := range f.keep[Rows] {
+		if !row_keep {
+			continue
+		}
+		for col_idx, val := range f.src.RowByIdx(row_idx) {
+			if !f.keep[Cols][col_idx] {
+				continue
+			}
+			idx := row_idx
+			if axis == Cols {
+				idx = col_idx
+			}
+			v := float64(val)
+			sums[idx] += v
+			squared_sums[idx] += v * v
+			counts[idx]++
+			if v < stats[idx].Min {
+				stats[idx].Min = v
+			}
+			if v > stats[idx].Max {
+				stats[idx].Max = v
+			}
+		}
+	}
+	for i := range stats {
+		if counts[i] == 0 {
+			stats[i] = Stats{}
+			continue
+		}
+		s := &stats[i]
+		s.Mean = sums[i] / float64(counts[i])
+		s.Std = math.Sqrt(math.Max(
+			squared_sums[i]/float64(counts[i])-s.Mean*s.Mean, 0))
+		s.Norm = math.Sqrt(squared_sums[i])
+	}
+	return stats
+}
+
+// KeepTopVariance keeps the n rows or columns with the highest variance.
+func KeepTopVariance(axis Axis, n int) FilterOption {
+	return func(f *filterState) {
+		stats := f.stats(axis)
+		kept := f.kept(axis)
+		if len(kept) <= n {
+			return
+		}
+		sort.SliceStable(kept, func(i, j int) bool {
+			return stats[kept[i]].Std > stats[kept[j]].Std
+		})
+		for _, idx := range kept[n:] {
+			f.keep[axis][idx] = false
+		}
+	}
+}
+
+// DropNaN removes rows or columns that contain any NaN value.
+func DropNaN(axis Axis) FilterOption {
+	return func(f *filterState) {
+		for row_idx, row_keep := range f.keep[Rows] {
+			if !row_keep {
+				continue
+			}
+			for col_idx, val := range f.src.RowByIdx(row_idx) {
+				if !f.keep[Cols][col_idx] || !math.IsNaN(float64(val)) {
+					continue
+				}
+				if axis == Rows {
+					f.keep[Rows][row_idx] = false
+					break
+				}
+				f.keep[Cols][col_idx] = false
+			}
+		}
+	}
+}
+
+// KeepNormAbove keeps rows or columns whose Euclidean norm is at least
+// threshold.
+func KeepNormAbove(axis Axis, threshold float64) FilterOption {
+	return func(f *filterState) {
+		stats := f.stats(axis)
+		for idx, keep := range f.keep[axis] {
+			if keep && stats[idx].Norm < threshold {
+				f.keep[axis][idx] = false
+			}
+		}
+	}
+}
+
+// KeepIdRange keeps rows or columns with ids in the inclusive range
+// [first, last].
+func KeepIdRange(axis Axis, first, last Ident) FilterOption {
+	return func(f *filterState) {
+		for idx, id := range f.ids(axis) {
+			if id < first || id > last {
+				f.keep[axis][idx] = false
+			}
+		}
+	}
+}
+
+// KeepRandomSample keeps a random sample of n rows or columns, chosen
+// deterministically from seed.
+func KeepRandomSample(axis Axis, n int, seed int64) FilterOption {
+	return func(f *filterState) {
+		kept := f.kept(axis)
+		if len(kept) <= n {
+			return
+		}
+		r := rand.New(rand.NewSource(seed))
+		r.Shuffle(len(kept), func(i, j int) {
+			kept[i], kept[j] = kept[j], kept[i]
+		})
+		for _, idx := range kept[n:] {
+			f.keep[axis][idx] = false
+		}
+	}
+}
+
 func shouldKeep(selected, invert bool) bool {
 	if invert {
 		return selected
@@ -14,19 +189,29 @@ func shouldKeep(selected, invert bool) bool {
 	return !selected
 }
 
-func filterIds(dst, src []Ident, selected map[int]struct{}, invert bool) {
+func filterIds(dst, src []Ident, keep []bool) {
 	dst_idx := 0
 	for src_idx, id := range src {
-		if _, s := selected[src_idx]; shouldKeep(s, invert) {
+		if keep[src_idx] {
 			dst[dst_idx] = id
 			dst_idx++
 		}
 	}
 }
 
+func countKept(keep []bool) (rv int) {
+	for _, k := range keep {
+		if k {
+			rv++
+		}
+	}
+	return rv
+}
+
 func Filter(dst_path, src_path string,
 	row_ids_selected []Ident, rows_inverted bool,
-	col_ids_selected []Ident, cols_inverted bool) error {
+	col_ids_selected []Ident, cols_inverted bool,
+	options ...FilterOption) (err error) {
 
 	src, err := Open(src_path)
 	if err != nil {
@@ -47,19 +232,24 @@ func Filter(dst_path, src_path string,
 		}
 	}
 
-	var new_rows, new_cols int
-	if rows_inverted {
-		new_rows = len(rows_selected)
-	} else {
-		new_rows = src.Rows() - len(rows_selected)
+	f := &filterState{src: src}
+	f.keep[Rows] = make([]bool, src.Rows())
+	for idx := range f.keep[Rows] {
+		_, selected := rows_selected[idx]
+		f.keep[Rows][idx] = shouldKeep(selected, rows_inverted)
+	}
+	f.keep[Cols] = make([]bool, src.Cols())
+	for idx := range f.keep[Cols] {
+		_, selected := cols_selected[idx]
+		f.keep[Cols][idx] = shouldKeep(selected, cols_inverted)
 	}
-	if cols_inverted {
-		new_cols = len(cols_selected)
-	} else {
-		new_cols = src.Cols() - len(cols_selected)
+
+	for _, option := range options {
+		option(f)
 	}
 
-	dst, err := Create(dst_path, int64(new_rows), int64(new_cols))
+	dst, err := Create(dst_path,
+		int64(countKept(f.keep[Rows])), int64(countKept(f.keep[Cols])))
 	if err != nil {
 		return err
 	}
@@ -70,21 +260,19 @@ func Filter(dst_path, src_path string,
 		}
 	}()
 
-	filterIds(dst.RowIds(), src.RowIds(), rows_selected, rows_inverted)
-	filterIds(dst.ColIds(), src.ColIds(), cols_selected, cols_inverted)
+	filterIds(dst.RowIds(), src.RowIds(), f.keep[Rows])
+	filterIds(dst.ColIds(), src.ColIds(), f.keep[Cols])
 
 	dst_row_idx := 0
 	for src_row_idx := 0; src_row_idx < src.Rows(); src_row_idx++ {
-		_, row_selected := rows_selected[src_row_idx]
-		if shouldKeep(row_selected, rows_inverted) {
+		if f.keep[Rows][src_row_idx] {
 			src_row := src.RowByIdx(src_row_idx)
 			dst_row := dst.RowByIdx(dst_row_idx)
 			dst_row_idx++
 
 			dst_col_idx := 0
 			for src_col_idx, val := range src_row {
-				_, col_selected := cols_selected[src_col_idx]
-				if shouldKeep(col_selected, cols_inverted) {
+				if f.keep[Cols][src_col_idx] {
 					dst_row[dst_col_idx] = val
 					dst_col_idx++
 				}
@@ -212,6 +212,12 @@ func (h *Handle) RowByIdx(idx int) []float32 {
 	return h.floats[h.cols*idx : h.cols*(idx+1)]
 }
 
+// RowsByIdx returns rows start through end-1 as one contiguous row-major
+// slice.
+func (h *Handle) RowsByIdx(start, end int) []float32 {
+	return h.floats[h.cols*start : h.cols*end]
+}
+
 func (h *Handle) RowById(id Ident) (row []float32, found bool) {
 	idx, found := h.RowIdxById(id)
 	if !found {
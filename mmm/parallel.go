@@ -0,0 +1,44 @@
+// Copyright (C) 2017 JT Olds
+// See LICENSE for copying information
+
+package mmm
+
+import (
+	"runtime"
+	"sync"
+)
+
+// ParallelRows calls cb once for every row index in [0, rows), spread across
+// workers goroutines. If workers <= 0, GOMAXPROCS workers are used. Each
+// worker handles a contiguous range of rows.
+func ParallelRows(rows, workers int, cb func(idx int)) {
+	if workers <= 0 {
+		workers = runtime.GOMAXPROCS(0)
+	}
+	if workers > rows {
+		workers = rows
+	}
+	if workers <= 1 {
+		for idx := 0; idx < rows; idx++ {
+			cb(idx)
+		}
+		return
+	}
+
+	var wg sync.WaitGroup
+	per_worker := (rows + workers - 1) / workers
+	for start := 0; start < rows; start += per_worker {
+		end := start + per_worker
+		if end > rows {
+			end = rows
+		}
+		wg.Add(1)
+		go func(start, end int) {
+			defer wg.Done()
+			for idx := start; idx < end; idx++ {
+				cb(idx)
+			}
+		}(start, end)
+	}
+	wg.Wait()
+}
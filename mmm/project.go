@@ -0,0 +1,109 @@
+// Copyright (C) 2017 JT Olds
+// See LICENSE for copying information
+
+package mmm
+
+import (
+	"fmt"
+	"math"
+	"os"
+)
+
+type ProjectOptions struct {
+	// MeansPath, if not empty, is a single row matrix of column means, such
+	// as the one written by mmmpca -means. The means are subtracted from
+	// every row before projecting.
+	MeansPath string
+	// Workers is the number of goroutines to use. If <= 0, GOMAXPROCS is
+	// used.
+	Workers int
+}
+
+// Project multiplies every row of the matrix at src_path by the loadings
+// matrix at loadings_path and writes the result to dst_path. The loadings
+// matrix has a row for every output dimension, such as a principal
+// component, and its columns are matched to the columns of the source matrix
+// by id, so the source may have its columns in any order. The output has the
+// row ids of the source and the row ids of the loadings matrix as column ids.
+// NaN values in the source don't contribute to any output value.
+func Project(dst_path, src_path, loadings_path string, opts ProjectOptions) (
+	err error) {
+	src, err := Open(src_path)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+	loadings, err := Open(loadings_path)
+	if err != nil {
+		return err
+	}
+	defer loadings.Close()
+
+	// src_cols maps every loadings column to its source column.
+	src_cols := make([]int, loadings.Cols())
+	for idx, id := range loadings.ColIds() {
+		src_idx, found := src.ColIdxById(id)
+		if !found {
+			return fmt.Errorf("column %d of the loadings is missing from %q",
+				id, src_path)
+		}
+		src_cols[idx] = src_idx
+	}
+
+	means := make([]float32, loadings.Cols())
+	if opts.MeansPath != "" {
+		mh, err := Open(opts.MeansPath)
+		if err != nil {
+			return err
+		}
+		defer mh.Close()
+		if mh.Rows() != 1 {
+			return fmt.Errorf("means file %q should have a single row",
+				opts.MeansPath)
+		}
+		row := mh.RowByIdx(0)
+		for idx, id := range loadings.ColIds() {
+			means_idx, found := mh.ColIdxById(id)
+			if !found {
+				return fmt.Errorf("column %d of the loadings is missing from %q",
+					id, opts.MeansPath)
+			}
+			means[idx] = row[means_idx]
+		}
+	}
+
+	dst, err := Create(dst_path, int64(src.Rows()), int64(loadings.Rows()))
+	if err != nil {
+		return err
+	}
+	defer func() {
+		dst.Close()
+		if err != nil {
+			os.Remove(dst_path)
+		}
+	}()
+	copy(dst.RowIds(), src.RowIds())
+	copy(dst.ColIds(), loadings.RowIds())
+
+	components := make([][]float32, loadings.Rows())
+	for c := range components {
+		components[c] = loadings.RowByIdx(c)
+	}
+
+	ParallelRows(src.Rows(), opts.Workers, func(idx int) {
+		src_row, dst_row := src.RowByIdx(idx), dst.RowByIdx(idx)
+		centered := make([]float32, len(src_cols))
+		for i, src_idx := range src_cols {
+			v := src_row[src_idx]
+			if math.IsNaN(float64(v)) {
+				continue
+			}
+			centered[i] = v - means[i]
+		}
+		for c, component := range components {
+			dst_row[c] = dot32(centered, component)
+		}
+	})
+
+	return dst.Close()
+}
@@ -0,0 +1,140 @@
+// Copyright (C) 2017 JT Olds
+// See LICENSE for copying information
+
+package mmm
+
+import (
+	"fmt"
+	"math"
+	"os"
+	"unsafe"
+)
+
+// Quantized is an int8 copy of a matrix, with a scale per row, for fast
+// approximate scoring. It is stored as an mmm file with the same row ids as
+// the source. Column 0 holds every row's scale and has the source's column
+// count as its id, and the remaining columns hold the row's int8 values,
+// packed four to a column, bit for bit in place of float32 values.
+type Quantized struct {
+	h    *Handle
+	cols int
+}
+
+// QuantizedPath is where the quantized copy of the mmm file at path is kept
+// by convention.
+func QuantizedPath(path string) string { return path + ".q8" }
+
+func packedCols(cols int) int { return 1 + (cols+3)/4 }
+
+func int8Slice(packed []float32, count int) []int8 {
+	if count == 0 {
+		return nil
+	}
+	return (*[1 << 30]int8)(unsafe.Pointer(&packed[0]))[:count:count]
+}
+
+// QuantizeRow writes src scaled into the int8 range to dst and returns the
+// scale, such that src[i] is approximately float32(dst[i]) * scale. NaNs
+// become zero.
+func QuantizeRow(dst []int8, src []float32) (scale float32) {
+	var max float64
+	for _, v := range src {
+		if abs := math.Abs(float64(v)); abs > max {
+			max = abs
+		}
+	}
+	if max == 0 {
+		for i := range dst {
+			dst[i] = 0
+		}
+		return 0
+	}
+	for i, v := range src {
+		if math.IsNaN(float64(v)) {
+			dst[i] = 0
+			continue
+		}
+		dst[i] = int8(math.Floor(float64(v)/max*127 + .5))
+	}
+	return float32(max / 127)
+}
+
+// DotQuantized returns the dot product of two quantized rows, which should be
+// multiplied by both of their scales to approximate the dot product of the
+// original rows.
+func DotQuantized(a, b []int8) int32 {
+	var s0, s1, s2, s3 int32
+	b = b[:len(a)]
+	i := 0
+	for ; i+4 <= len(a); i += 4 {
+		s0 += int32(a[i]) * int32(b[i])
+		s1 += int32(a[i+1]) * int32(b[i+1])
+		s2 += int32(a[i+2]) * int32(b[i+2])
+		s3 += int32(a[i+3]) * int32(b[i+3])
+	}
+	for ; i < len(a); i++ {
+		s0 += int32(a[i]) * int32(b[i])
+	}
+	return (s0 + s1) + (s2 + s3)
+}
+
+// Quantize writes a quantized copy of the matrix at src_path to dst_path.
+// Rows are scaled independently, so rows with very different magnitudes keep
+// their precision, but quantization works best on unit-normalized rows, where
+// the int8 dot product ranks rows nearly the same as the float32 one.
+func Quantize(dst_path, src_path string, workers int) (err error) {
+	src, err := Open(src_path)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+
+	dst, err := Create(dst_path, int64(src.Rows()),
+		int64(packedCols(src.Cols())))
+	if err != nil {
+		return err
+	}
+	defer func() {
+		dst.Close()
+		if err != nil {
+			os.Remove(dst_path)
+		}
+	}()
+	copy(dst.RowIds(), src.RowIds())
+	dst.ColIds()[0] = Ident(src.Cols())
+	for i := 1; i < dst.Cols(); i++ {
+		dst.ColIds()[i] = Ident(i)
+	}
+
+	ParallelRows(src.Rows(), workers, func(idx int) {
+		row := dst.RowByIdx(idx)
+		row[0] = QuantizeRow(int8Slice(row[1:], src.Cols()), src.RowByIdx(idx))
+	})
+	return dst.Close()
+}
+
+func OpenQuantized(path string) (*Quantized, error) {
+	h, err := Open(path)
+	if err != nil {
+		return nil, err
+	}
+	if h.Cols() < 1 || h.Cols() != packedCols(int(h.ColIdByIdx(0))) {
+		h.Close()
+		return nil, fmt.Errorf("%#v is not a quantized matrix", path)
+	}
+	return &Quantized{h: h, cols: int(h.ColIdByIdx(0))}, nil
+}
+
+func (q *Quantized) Close() error { return q.h.Close() }
+
+func (q *Quantized) Rows() int                { return q.h.Rows() }
+func (q *Quantized) Cols() int                { return q.cols }
+func (q *Quantized) RowIds() []Ident          { return q.h.RowIds() }
+func (q *Quantized) RowIdByIdx(idx int) Ident { return q.h.RowIdByIdx(idx) }
+
+// RowByIdx returns the quantized values and scale of row idx. The values
+// point into the mapped file and must not be modified.
+func (q *Quantized) RowByIdx(idx int) (values []int8, scale float32) {
+	row := q.h.RowByIdx(idx)
+	return int8Slice(row[1:], q.cols), row[0]
+}
@@ -0,0 +1,155 @@
+// Copyright (C) 2017 JT Olds
+// See LICENSE for copying information
+
+package mmm
+
+import (
+	"context"
+
+	"github.com/gonum/blas"
+	"github.com/gonum/blas/blas32"
+)
+
+type ScoreOptions struct {
+	Metric Metric
+
+	// TopK is how many of the best scoring rows TopQueries keeps for every
+	// query.
+	TopK int
+	// Lowest keeps the k lowest scores instead of the highest, which finds
+	// the most opposite rows.
+	Lowest bool
+
+	// Prepared means the rows of the scored matrix were already prepared for
+	// Metric, as PrepareMatrix does, so they're multiplied as they are.
+	Prepared bool
+
+	// BlockRows is how many rows of the scored matrix are prepared and
+	// multiplied against the queries at a time. If <= 0, 1024 is used.
+	BlockRows int
+	// Workers is the number of goroutines to prepare rows and keep top-k
+	// lists with. If <= 0, GOMAXPROCS is used.
+	Workers int
+}
+
+// PrepareQueries returns the queries as one row-major matrix of rows
+// prepared for opts.Metric, as expected by ScoreQueries.
+func PrepareQueries(queries [][]float32, metric Metric, workers int) []float32 {
+	if len(queries) == 0 {
+		return nil
+	}
+	cols := len(queries[0])
+	prepared := make([]float32, len(queries)*cols)
+	ParallelRows(len(queries), workers, func(q int) {
+		metric.PrepareRow(prepared[q*cols:(q+1)*cols], queries[q])
+	})
+	return prepared
+}
+
+// PrepareMatrix writes a copy of src to dst_path with every row prepared for
+// metric, for scoring src many times with ScoreOptions.Prepared.
+func PrepareMatrix(dst_path string, src *Handle, metric Metric,
+	workers int) (h *Handle, err error) {
+	h, err = Create(dst_path, int64(src.Rows()), int64(src.Cols()))
+	if err != nil {
+		return nil, err
+	}
+	copy(h.RowIds(), src.RowIds())
+	copy(h.ColIds(), src.ColIds())
+	ParallelRows(src.Rows(), workers, func(i int) {
+		metric.PrepareRow(h.RowByIdx(i), src.RowByIdx(i))
+	})
+	return h, nil
+}
+
+// ScoreQueries scores every row of prepared, a row-major matrix of queries
+// with h's columns as returned by PrepareQueries, against every row of h in
+// one pass over h. Each block of h's rows is prepared for opts.Metric and
+// scored against all of the queries with a single matrix multiplication. cb
+// is called once per block, in order, with the block's row range of h and a
+// row-major matrix of scores with a row per query and a column per row of the
+// block. scores is reused between calls. If cb returns an error, scoring
+// stops and the error is returned.
+func ScoreQueries(prepared []float32, h *Handle, opts ScoreOptions,
+	cb func(start, end int, scores []float32) error) error {
+	cols := h.Cols()
+	if cols == 0 || len(prepared) == 0 {
+		return nil
+	}
+	queries := len(prepared) / cols
+	block_rows := opts.BlockRows
+	if block_rows <= 0 {
+		block_rows = 1024
+	}
+
+	var block []float32
+	if !opts.Prepared {
+		block = make([]float32, block_rows*cols)
+	}
+	scores := make([]float32, queries*block_rows)
+	for start := 0; start < h.Rows(); start += block_rows {
+		end := start + block_rows
+		if end > h.Rows() {
+			end = h.Rows()
+		}
+		count := end - start
+		rows := h.RowsByIdx(start, end)
+		if !opts.Prepared {
+			rows = block[:count*cols]
+			ParallelRows(count, opts.Workers, func(i int) {
+				opts.Metric.PrepareRow(rows[i*cols:(i+1)*cols], h.RowByIdx(start+i))
+			})
+		}
+		blas32.Gemm(blas.NoTrans, blas.Trans, 1,
+			blas32.General{Rows: queries, Cols: cols, Stride: cols,
+				Data: prepared},
+			blas32.General{Rows: count, Cols: cols, Stride: cols, Data: rows},
+			0,
+			blas32.General{Rows: queries, Cols: count, Stride: count,
+				Data: scores[:queries*count]})
+		err := cb(start, end, scores[:queries*count])
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// TopQueries scores every query against every row of h, as ScoreQueries
+// does, and returns the opts.TopK best scoring rows for every query, best
+// first. It stops early with ctx's error if ctx is canceled.
+func TopQueries(ctx context.Context, queries [][]float32, h *Handle,
+	opts ScoreOptions) ([][]Neighbor, error) {
+	heaps := make([]neighborHeap, len(queries))
+	for q := range heaps {
+		heaps[q] = neighborHeap{
+			items:  make([]Neighbor, 0, opts.TopK),
+			lowest: opts.Lowest}
+	}
+	if opts.TopK > 0 {
+		prepared := PrepareQueries(queries, opts.Metric, opts.Workers)
+		err := ScoreQueries(prepared, h, opts, func(start, end int,
+			scores []float32) error {
+			err := ctx.Err()
+			if err != nil {
+				return err
+			}
+			count := end - start
+			ParallelRows(len(queries), opts.Workers, func(q int) {
+				for j, score := range scores[q*count : (q+1)*count] {
+					heaps[q].offer(opts.TopK, Neighbor{
+						Id: h.RowIdByIdx(start + j), Score: score})
+				}
+			})
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	rv := make([][]Neighbor, len(queries))
+	for q := range heaps {
+		rv[q] = heaps[q].sorted()
+	}
+	return rv, nil
+}
@@ -0,0 +1,179 @@
+// Copyright (C) 2017 JT Olds
+// See LICENSE for copying information
+
+package mmm
+
+import (
+	"fmt"
+	"math"
+	"os"
+	"sort"
+)
+
+// Ordering computes a new order for the rows or columns of a matrix. The
+// returned slice lists source indexes in the order they should appear.
+type Ordering func(h *Handle, axis Axis) ([]int, error)
+
+func identity(n int) []int {
+	rv := make([]int, n)
+	for i := range rv {
+		rv[i] = i
+	}
+	return rv
+}
+
+func orderByKeys(keys []float64, descending bool) []int {
+	perm := identity(len(keys))
+	sort.SliceStable(perm, func(i, j int) bool {
+		a, b := keys[perm[i]], keys[perm[j]]
+		// NaNs always sort last
+		if math.IsNaN(a) || math.IsNaN(b) {
+			return !math.IsNaN(a) && math.IsNaN(b)
+		}
+		if descending {
+			return a > b
+		}
+		return a < b
+	})
+	return perm
+}
+
+// ById orders rows or columns by their Ident.
+func ById(descending bool) Ordering {
+	return func(h *Handle, axis Axis) ([]int, error) {
+		ids := axisIds(h, axis)
+		keys := make([]float64, len(ids))
+		for i, id := range ids {
+			keys[i] = float64(id)
+		}
+		return orderByKeys(keys, descending), nil
+	}
+}
+
+// ByList orders rows or columns to match the order of ids. Rows or columns
+// not in ids are placed after, in their original order. Ids in the list
+// that the matrix doesn't have are ignored.
+func ByList(ids []Ident) Ordering {
+	return func(h *Handle, axis Axis) ([]int, error) {
+		hids := axisIds(h, axis)
+		positions := make(map[Ident]int, len(ids))
+		for pos, id := range ids {
+			if _, exists := positions[id]; !exists {
+				positions[id] = pos
+			}
+		}
+		keys := make([]float64, len(hids))
+		for i, id := range hids {
+			if pos, found := positions[id]; found {
+				keys[i] = float64(pos)
+			} else {
+				keys[i] = float64(len(ids) + i)
+			}
+		}
+		return orderByKeys(keys, false), nil
+	}
+}
+
+// ByValue orders rows by their value in the column with the given id, or
+// columns by their value in the row with the given id.
+func ByValue(id Ident, descending bool) Ordering {
+	return func(h *Handle, axis Axis) ([]int, error) {
+		var keys []float64
+		if axis == Rows {
+			col_idx, found := h.ColIdxById(id)
+			if !found {
+				return nil, fmt.Errorf("column %d not found", id)
+			}
+			keys = make([]float64, h.Rows())
+			for row_idx := range keys {
+				keys[row_idx] = float64(h.RowByIdx(row_idx)[col_idx])
+			}
+		} else {
+			row, found := h.RowById(id)
+			if !found {
+				return nil, fmt.Errorf("row %d not found", id)
+			}
+			keys = make([]float64, len(row))
+			for col_idx, val := range row {
+				keys[col_idx] = float64(val)
+			}
+		}
+		return orderByKeys(keys, descending), nil
+	}
+}
+
+// ByNorm orders rows or columns by their Euclidean norm.
+func ByNorm(descending bool) Ordering {
+	return func(h *Handle, axis Axis) ([]int, error) {
+		var keys []float64
+		if axis == Rows {
+			keys = make([]float64, h.Rows())
+			for row_idx := range keys {
+				keys[row_idx] = computeStats(h.RowByIdx(row_idx)).Norm
+			}
+		} else {
+			stats := ColumnStats(h)
+			keys = make([]float64, len(stats))
+			for col_idx, s := range stats {
+				keys[col_idx] = s.Norm
+			}
+		}
+		return orderByKeys(keys, descending), nil
+	}
+}
+
+// Sort writes a copy of the matrix at src_path to dst_path with rows ordered
+// by rows and columns ordered by cols. A nil Ordering leaves that axis in its
+// original order. Only the sort keys and permutations are held in memory;
+// row data is read from and written to the memory mapped files directly, so
+// Sort works on files larger than RAM.
+func Sort(dst_path, src_path string, rows, cols Ordering) (err error) {
+	src, err := Open(src_path)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+
+	row_perm := identity(src.Rows())
+	if rows != nil {
+		row_perm, err = rows(src, Rows)
+		if err != nil {
+			return err
+		}
+	}
+	col_perm := identity(src.Cols())
+	if cols != nil {
+		col_perm, err = cols(src, Cols)
+		if err != nil {
+			return err
+		}
+	}
+
+	dst, err := Create(dst_path, int64(src.Rows()), int64(src.Cols()))
+	if err != nil {
+		return err
+	}
+	defer func() {
+		dst.Close()
+		if err != nil {
+			os.Remove(dst_path)
+		}
+	}()
+
+	for dst_idx, src_idx := range row_perm {
+		dst.RowIds()[dst_idx] = src.RowIdByIdx(src_idx)
+	}
+	for dst_idx, src_idx := range col_perm {
+		dst.ColIds()[dst_idx] = src.ColIdByIdx(src_idx)
+	}
+
+	for dst_row_idx, src_row_idx := range row_perm {
+		src_row := src.RowByIdx(src_row_idx)
+		dst_row := dst.RowByIdx(dst_row_idx)
+		for dst_col_idx, src_col_idx := range col_perm {
+			dst_row[dst_col_idx] = src_row[src_col_idx]
+		}
+	}
+
+	return dst.Close()
+}
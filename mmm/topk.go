@@ -0,0 +1,124 @@
+// Copyright (C) 2017 JT Olds
+// See LICENSE for copying information
+
+package mmm
+
+import (
+	"fmt"
+	"math"
+	"os"
+	"unsafe"
+
+	"github.com/spacemonkeygo/errors"
+)
+
+// TopK is a sparse similarity matrix that keeps, for every row, only the k
+// best scoring columns. It is stored as a pair of mmm files that share row
+// ids: path holds the scores, and path+".ids" holds the matching neighbor
+// ids, stored bit for bit in place of the float32 values. Both files have
+// column ids 0 through k-1, ordered best first. Rows with fewer than k
+// neighbors have NaN scores in their unused slots.
+type TopK struct {
+	scores, ids *Handle
+}
+
+func topKIdsPath(path string) string { return path + ".ids" }
+
+// SimilarPath is where the precomputed most similar rows of the mmm file at
+// path are stored, as a TopK file.
+func SimilarPath(path string) string { return path + ".similar" }
+
+// OppositePath is where the precomputed most opposite rows of the mmm file at
+// path are stored, as a TopK file.
+func OppositePath(path string) string { return path + ".opposite" }
+
+func CreateTopK(path string, rows, k int64) (rv *TopK, err error) {
+	scores, err := Create(path, rows, k)
+	if err != nil {
+		return nil, err
+	}
+	ids, err := Create(topKIdsPath(path), rows, k)
+	if err != nil {
+		scores.Close()
+		os.Remove(path)
+		return nil, err
+	}
+	for i := range scores.ColIds() {
+		scores.ColIds()[i] = Ident(i)
+		ids.ColIds()[i] = Ident(i)
+	}
+	nan := float32(math.NaN())
+	for i := range scores.floats {
+		scores.floats[i] = nan
+	}
+	return &TopK{scores: scores, ids: ids}, nil
+}
+
+func OpenTopK(path string) (rv *TopK, err error) {
+	scores, err := Open(path)
+	if err != nil {
+		return nil, err
+	}
+	ids, err := Open(topKIdsPath(path))
+	if err != nil {
+		scores.Close()
+		return nil, err
+	}
+	if scores.Rows() != ids.Rows() || scores.Cols() != ids.Cols() {
+		scores.Close()
+		ids.Close()
+		return nil, fmt.Errorf("%#v and its ids file have different dimensions",
+			path)
+	}
+	return &TopK{scores: scores, ids: ids}, nil
+}
+
+func (t *TopK) Close() error {
+	var errs errors.ErrorGroup
+	errs.Add(t.scores.Close())
+	errs.Add(t.ids.Close())
+	return errs.Finalize()
+}
+
+func (t *TopK) K() int                   { return t.scores.Cols() }
+func (t *TopK) Rows() int                { return t.scores.Rows() }
+func (t *TopK) RowIds() []Ident          { return t.scores.RowIds() }
+func (t *TopK) RowIdByIdx(idx int) Ident { return t.scores.RowIdByIdx(idx) }
+
+func (t *TopK) RowIdxById(id Ident) (idx int, found bool) {
+	return t.scores.RowIdxById(id)
+}
+
+// SetRowId sets the id of a row in both files.
+func (t *TopK) SetRowId(idx int, id Ident) {
+	t.scores.RowIds()[idx] = id
+	t.ids.RowIds()[idx] = id
+}
+
+// NeighborsByIdx returns the neighbor ids and scores of a row. The slices
+// point directly into the files, so writes to them are persisted. Only
+// entries with non-NaN scores are valid.
+func (t *TopK) NeighborsByIdx(idx int) (ids []Ident, scores []float32) {
+	scores = t.scores.RowByIdx(idx)
+	raw := t.ids.RowByIdx(idx)
+	if len(raw) == 0 {
+		return nil, scores
+	}
+	ids = (*[1 << 30]Ident)(unsafe.Pointer(&raw[0]))[:len(raw):len(raw)]
+	return ids, scores
+}
+
+// Neighbors returns the valid neighbors of the row with the given id.
+func (t *TopK) Neighbors(id Ident) (ids []Ident, scores []float32,
+	found bool) {
+	idx, found := t.RowIdxById(id)
+	if !found {
+		return nil, nil, false
+	}
+	ids, scores = t.NeighborsByIdx(idx)
+	n := 0
+	for n < len(scores) && !math.IsNaN(float64(scores[n])) {
+		n++
+	}
+	return ids[:n], scores[:n], true
+}
@@ -0,0 +1,203 @@
+// Copyright (C) 2017 JT Olds
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pca
+
+import (
+	"context"
+	"fmt"
+	"math"
+
+	"github.com/gonum/matrix/mat64"
+)
+
+// ComponentFit describes how the iteration for a single NIPALS component
+// went.
+type ComponentFit struct {
+	// Iterations is how many score/loading updates were performed.
+	Iterations int64
+	// Converged is true if the iteration stopped because the change in the
+	// score vector dropped below the tolerance, rather than because it ran
+	// out of iterations.
+	Converged bool
+	// Error is the Euclidean length of the change in the score vector during
+	// the last iteration.
+	Error float64
+}
+
+// NIPALSResult is a principal component analysis computed by
+// NIPALSMissing.
+type NIPALSResult struct {
+	Model
+	// Scores has a row for every observation and a column for every
+	// component.
+	Scores *mat64.Dense
+	// Fits has an entry for every component.
+	Fits []ComponentFit
+}
+
+// NIPALSMissing is like NIPALS, but treats NaN values in data as missing.
+// Missing entries are skipped in every score and loading update, which is
+// the classic way NIPALS handles incomplete data, so the resulting scores are
+// estimated from the observed values only. The column means are likewise
+// computed from the observed values.
+//
+// Each component starts from the column of the residual matrix with the most
+// remaining variance. Instead of printing when a component fails to converge,
+// NIPALSMissing reports the iteration count and final error of every
+// component in the result. If ctx is canceled, NIPALSMissing stops and
+// returns ctx's error.
+func NIPALSMissing(ctx context.Context, data *mat64.Dense, components int,
+	iterations int64, tolerance float64) (*NIPALSResult, error) {
+	obsCount, varCount := data.Dims()
+	if components <= 0 || components > varCount {
+		return nil, fmt.Errorf("invalid number of components %d", components)
+	}
+
+	varMeans := make([]float64, varCount)
+	for j := range varMeans {
+		count := 0
+		for i := 0; i < obsCount; i++ {
+			if v := data.At(i, j); !math.IsNaN(v) {
+				varMeans[j] += v
+				count++
+			}
+		}
+		if count > 0 {
+			varMeans[j] /= float64(count)
+		}
+	}
+
+	// Xh is the centered residual matrix, stored row-major with NaNs kept in
+	// place to mark missing entries.
+	Xh := make([]float64, obsCount*varCount)
+	for i := 0; i < obsCount; i++ {
+		for j := 0; j < varCount; j++ {
+			Xh[i*varCount+j] = data.At(i, j) - varMeans[j]
+		}
+	}
+	residualVar := func() (total float64, perVar []float64) {
+		perVar = make([]float64, varCount)
+		for idx, v := range Xh {
+			if !math.IsNaN(v) {
+				perVar[idx%varCount] += v * v
+				total += v * v
+			}
+		}
+		return total, perVar
+	}
+
+	res := &NIPALSResult{
+		Model: Model{
+			Means:             mat64.NewVector(varCount, varMeans),
+			Loadings:          mat64.NewDense(varCount, components, nil),
+			ExplainedVariance: mat64.NewVector(components, nil),
+		},
+		Scores: mat64.NewDense(obsCount, components, nil),
+		Fits:   make([]ComponentFit, components),
+	}
+
+	varTotal, perVar := residualVar()
+	currVar := varTotal
+	th := make([]float64, obsCount)
+	thnew := make([]float64, obsCount)
+	ph := make([]float64, varCount)
+
+	for h := 0; h < components; h++ {
+		start := 0
+		for j := range perVar {
+			if perVar[j] > perVar[start] {
+				start = j
+			}
+		}
+		for i := range th {
+			th[i] = Xh[i*varCount+start]
+			if math.IsNaN(th[i]) {
+				th[i] = 0
+			}
+		}
+
+		fit := &res.Fits[h]
+		for {
+			if err := ctx.Err(); err != nil {
+				return nil, err
+			}
+			fit.Iterations++
+
+			// ph = Xh' th / th'th, over observed entries only
+			for j := range ph {
+				var num, denom float64
+				for i, t := range th {
+					if v := Xh[i*varCount+j]; !math.IsNaN(v) {
+						num += v * t
+						denom += t * t
+					}
+				}
+				ph[j] = 0
+				if denom > 0 {
+					ph[j] = num / denom
+				}
+			}
+			norm := math.Sqrt(dot(ph, ph))
+			if norm > 0 {
+				for j := range ph {
+					ph[j] /= norm
+				}
+			}
+
+			// thnew = Xh ph / ph'ph, over observed entries only
+			var prec float64
+			for i := range thnew {
+				var num, denom float64
+				for j, p := range ph {
+					if v := Xh[i*varCount+j]; !math.IsNaN(v) {
+						num += v * p
+						denom += p * p
+					}
+				}
+				thnew[i] = 0
+				if denom > 0 {
+					thnew[i] = num / denom
+				}
+				diff := thnew[i] - th[i]
+				prec += diff * diff
+			}
+			th, thnew = thnew, th
+			fit.Error = math.Sqrt(prec)
+
+			if prec <= tolerance*tolerance {
+				fit.Converged = true
+				break
+			}
+			if iterations <= fit.Iterations {
+				break
+			}
+		}
+
+		for i, t := range th {
+			for j, p := range ph {
+				Xh[i*varCount+j] -= t * p
+			}
+		}
+		res.Scores.SetCol(h, th)
+		res.Loadings.SetCol(h, ph)
+		oldVar := currVar
+		currVar, perVar = residualVar()
+		if varTotal > 0 {
+			res.ExplainedVariance.SetVec(h, (oldVar-currVar)/varTotal)
+		}
+	}
+
+	return res, nil
+}
@@ -0,0 +1,315 @@
+// Copyright (C) 2017 JT Olds
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pca
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+	"os"
+
+	"github.com/gonum/matrix/mat64"
+	"github.com/jtolds/golincs/mat"
+	"github.com/jtolds/golincs/mmm"
+	"github.com/spacemonkeygo/errors"
+)
+
+const modelMagic = "PCAM"
+
+// Model is a fitted principal component analysis that can project new data
+// into and out of the component space.
+type Model struct {
+	// Means are the column means subtracted from every row before
+	// projecting.
+	Means *mat64.Vector
+	// Loadings has a row for every variable and a column for every component.
+	Loadings *mat64.Dense
+	// ExplainedVariance is the fraction of the total variance each component
+	// represents.
+	ExplainedVariance *mat64.Vector
+}
+
+// Fit computes a Model of the rows of data with NIPALS. See NIPALS for the
+// meaning of the arguments.
+func Fit(data *mat64.Dense, components int, iterations int64,
+	tolerance float64) *Model {
+	model, _ := FitScores(data, components, iterations, tolerance)
+	return model
+}
+
+// FitScores is like Fit, but also returns the scores NIPALS computed for
+// data, with a row for every row of data and a column for every component,
+// so they don't need to be recomputed with Transform.
+func FitScores(data *mat64.Dense, components int, iterations int64,
+	tolerance float64) (*Model, *mat64.Dense) {
+	scores, loadings, explainedVariance := NIPALS(data, components,
+		iterations, tolerance)
+	variance := make([]float64, components)
+	for i := range variance {
+		variance[i] = explainedVariance.At(i, 0)
+	}
+	return &Model{
+		Means:             mat.ColumnMeans(data),
+		Loadings:          &loadings,
+		ExplainedVariance: mat64.NewVector(components, variance),
+	}, &scores
+}
+
+// Variables returns how many variables (input columns) the model expects.
+func (m *Model) Variables() int {
+	rows, _ := m.Loadings.Dims()
+	return rows
+}
+
+// Components returns how many components the model has.
+func (m *Model) Components() int {
+	_, cols := m.Loadings.Dims()
+	return cols
+}
+
+// Truncate returns a model with only the first components components, but at
+// least one.
+func (m *Model) Truncate(components int) *Model {
+	if components > m.Components() {
+		components = m.Components()
+	}
+	if components < 1 {
+		components = 1
+	}
+	variance := make([]float64, components)
+	for i := range variance {
+		variance[i] = m.ExplainedVariance.At(i, 0)
+	}
+	return &Model{
+		Means: m.Means,
+		Loadings: mat64.DenseCopyOf(
+			m.Loadings.View(0, 0, m.Variables(), components)),
+		ExplainedVariance: mat64.NewVector(components, variance),
+	}
+}
+
+// TruncateVariance returns a model with the fewest leading components whose
+// explained variance adds up to more than threshold, or every component if
+// they never do.
+func (m *Model) TruncateVariance(threshold float64) *Model {
+	captured := 0.0
+	for i := 0; i < m.Components(); i++ {
+		captured += m.ExplainedVariance.At(i, 0)
+		if captured > threshold {
+			return m.Truncate(i + 1)
+		}
+	}
+	return m
+}
+
+// Transform centers every row of data with the model's means and returns its
+// scores, with a row for every row of data and a column for every component.
+func (m *Model) Transform(data *mat64.Dense) *mat64.Dense {
+	var centered, scores mat64.Dense
+	centered.Apply(func(i, j int, v float64) float64 {
+		return v - m.Means.At(j, 0)
+	}, data)
+	scores.Mul(&centered, m.Loadings)
+	return &scores
+}
+
+// InverseTransform maps scores back into the original variable space,
+// approximately reconstructing the rows they were computed from.
+func (m *Model) InverseTransform(scores *mat64.Dense) *mat64.Dense {
+	var data mat64.Dense
+	data.Mul(scores, m.Loadings.T())
+	data.Apply(func(i, j int, v float64) float64 {
+		return v + m.Means.At(j, 0)
+	}, &data)
+	return &data
+}
+
+// Save writes the model to w in a compact binary format readable by Load.
+func (m *Model) Save(w io.Writer) error {
+	variables, components := m.Variables(), m.Components()
+	vals := make([]float64, 0,
+		variables+variables*components+components)
+	for i := 0; i < variables; i++ {
+		vals = append(vals, m.Means.At(i, 0))
+	}
+	for i := 0; i < variables; i++ {
+		for j := 0; j < components; j++ {
+			vals = append(vals, m.Loadings.At(i, j))
+		}
+	}
+	for j := 0; j < components; j++ {
+		vals = append(vals, m.ExplainedVariance.At(j, 0))
+	}
+
+	bw := bufio.NewWriter(w)
+	_, err := bw.WriteString(modelMagic)
+	if err != nil {
+		return err
+	}
+	err = binary.Write(bw, binary.LittleEndian,
+		[2]uint32{uint32(variables), uint32(components)})
+	if err != nil {
+		return err
+	}
+	err = binary.Write(bw, binary.LittleEndian, vals)
+	if err != nil {
+		return err
+	}
+	return bw.Flush()
+}
+
+// Load reads a model written by Save.
+func Load(r io.Reader) (*Model, error) {
+	br := bufio.NewReader(r)
+	magic := make([]byte, len(modelMagic))
+	_, err := io.ReadFull(br, magic)
+	if err != nil {
+		return nil, err
+	}
+	if string(magic) != modelMagic {
+		return nil, fmt.Errorf("not a pca model")
+	}
+	var sizes [2]uint32
+	err = binary.Read(br, binary.LittleEndian, &sizes)
+	if err != nil {
+		return nil, err
+	}
+	variables, components := int(sizes[0]), int(sizes[1])
+	if variables == 0 || components == 0 {
+		return nil, fmt.Errorf("invalid pca model")
+	}
+
+	means := make([]float64, variables)
+	loadings := make([]float64, variables*components)
+	variance := make([]float64, components)
+	for _, vals := range [][]float64{means, loadings, variance} {
+		err = binary.Read(br, binary.LittleEndian, vals)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return &Model{
+		Means:             mat64.NewVector(variables, means),
+		Loadings:          mat64.NewDense(variables, components, loadings),
+		ExplainedVariance: mat64.NewVector(components, variance),
+	}, nil
+}
+
+// SaveFile writes the model to a file at path.
+func (m *Model) SaveFile(path string) (err error) {
+	fh, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	err = m.Save(fh)
+	var errs errors.ErrorGroup
+	errs.Add(err)
+	errs.Add(fh.Close())
+	err = errs.Finalize()
+	if err != nil {
+		os.Remove(path)
+	}
+	return err
+}
+
+// LoadFile reads a model from a file at path.
+func LoadFile(path string) (*Model, error) {
+	fh, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer fh.Close()
+	return Load(fh)
+}
+
+// WriteLoadings writes the loadings of the model as an mmm file with a row for
+// every component, numbered from zero, and the column ids of h, which must
+// have a column for every variable of the model.
+func (m *Model) WriteLoadings(dst_path string, h *mmm.Handle) (err error) {
+	cols, components := m.Variables(), m.Components()
+	if cols != h.Cols() {
+		return fmt.Errorf("loadings don't match input columns")
+	}
+	dst, err := mmm.Create(dst_path, int64(components), int64(cols))
+	if err != nil {
+		return err
+	}
+	defer func() {
+		dst.Close()
+		if err != nil {
+			os.Remove(dst_path)
+		}
+	}()
+	copy(dst.ColIds(), h.ColIds())
+	for c := 0; c < components; c++ {
+		dst.RowIds()[c] = mmm.Ident(c)
+		row := dst.RowByIdx(c)
+		for j := range row {
+			row[j] = float32(m.Loadings.At(j, c))
+		}
+	}
+	return dst.Close()
+}
+
+// WriteScores projects every row of h onto the components of the model and
+// writes the scores as an mmm file with the row ids of h and a column for
+// every component, numbered from zero. NaNs in h are treated as the column
+// mean.
+func (m *Model) WriteScores(dst_path string, h *mmm.Handle, workers int) (
+	err error) {
+	cols, components := m.Variables(), m.Components()
+	if cols != h.Cols() {
+		return fmt.Errorf("loadings don't match input columns")
+	}
+	dst, err := mmm.Create(dst_path, int64(h.Rows()), int64(components))
+	if err != nil {
+		return err
+	}
+	defer func() {
+		dst.Close()
+		if err != nil {
+			os.Remove(dst_path)
+		}
+	}()
+	copy(dst.RowIds(), h.RowIds())
+	for c := 0; c < components; c++ {
+		dst.ColIds()[c] = mmm.Ident(c)
+	}
+
+	loadings := make([][]float64, components)
+	for c := range loadings {
+		loadings[c] = make([]float64, cols)
+		mat64.Col(loadings[c], c, m.Loadings)
+	}
+	means := make([]float64, cols)
+	for j := range means {
+		means[j] = m.Means.At(j, 0)
+	}
+	mmm.ParallelRows(h.Rows(), workers, func(idx int) {
+		src, out := h.RowByIdx(idx), dst.RowByIdx(idx)
+		for c, loading := range loadings {
+			var sum float64
+			for j, val := range src {
+				if !math.IsNaN(float64(val)) {
+					sum += (float64(val) - means[j]) * loading[j]
+				}
+			}
+			out[c] = float32(sum)
+		}
+	})
+	return dst.Close()
+}
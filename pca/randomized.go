@@ -0,0 +1,325 @@
+// Copyright (C) 2017 JT Olds
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pca
+
+import (
+	"fmt"
+	"math"
+	"math/rand"
+	"runtime"
+	"sort"
+	"sync"
+	"sync/atomic"
+
+	"github.com/gonum/matrix/mat64"
+	"github.com/jtolds/golincs/mmm"
+)
+
+type RandomizedOptions struct {
+	// Components is the number of principal components to compute.
+	Components int
+	// Oversampling is how many extra random directions are tracked beyond
+	// Components, which improves accuracy. If <= 0, 10 is used.
+	Oversampling int
+	// PowerIterations is how many extra passes are spent refining the random
+	// subspace. Every pass reads the whole matrix. If < 0, 2 is used.
+	PowerIterations int
+	Seed            int64
+
+	// BlockRows is how many rows are decoded into memory at a time by each
+	// worker. If <= 0, 1024 is used.
+	BlockRows int
+	// Workers is the number of goroutines to use. If <= 0, GOMAXPROCS is
+	// used.
+	Workers int
+}
+
+// RandomizedResult is a principal component analysis computed by Randomized.
+type RandomizedResult struct {
+	Model
+	// Variance is the variance of the scores along each component.
+	Variance *mat64.Vector
+	// TotalVariance is the sum of the variances of all input columns.
+	TotalVariance float64
+	// Passes is how many times the input was read.
+	Passes int
+}
+
+// Randomized calculates a limited number of principal components of the rows
+// of h using the randomized subspace iteration of Halko, Martinsson and Tropp
+// (2011). Unlike NIPALS, the matrix is never held in memory: rows are
+// streamed from h in blocks, and besides the blocks only a few matrices of
+// size columns by (components + oversampling) are kept. The input is read
+// PowerIterations + 3 times.
+//
+// NaN values are treated as missing and replaced with their column's mean.
+func Randomized(h *mmm.Handle, opts RandomizedOptions) (
+	*RandomizedResult, error) {
+	rows, cols := h.Rows(), h.Cols()
+	if rows < 2 || cols == 0 {
+		return nil, fmt.Errorf("need at least two rows and one column")
+	}
+	if opts.Components <= 0 {
+		return nil, fmt.Errorf("invalid number of components %d", opts.Components)
+	}
+	components := opts.Components
+	if components > cols {
+		components = cols
+	}
+	if components > rows {
+		components = rows
+	}
+	oversampling := opts.Oversampling
+	if oversampling <= 0 {
+		oversampling = 10
+	}
+	power_iterations := opts.PowerIterations
+	if power_iterations < 0 {
+		power_iterations = 2
+	}
+	subspace := components + oversampling
+	if subspace > cols {
+		subspace = cols
+	}
+
+	s := &streamer{h: h, blockRows: opts.BlockRows, workers: opts.Workers}
+	if s.blockRows <= 0 {
+		s.blockRows = 1024
+	}
+	means, total := columnMeans(h)
+	s.means = means
+	res := &RandomizedResult{
+		Model:         Model{Means: mat64.NewVector(cols, means)},
+		TotalVariance: total / float64(rows-1),
+		Passes:        1,
+	}
+
+	r := rand.New(rand.NewSource(opts.Seed))
+	q := mat64.NewDense(cols, subspace, nil)
+	for i := 0; i < cols; i++ {
+		for j := 0; j < subspace; j++ {
+			q.Set(i, j, r.NormFloat64())
+		}
+	}
+
+	// subspace iteration on the covariance matrix X'X, where X is the centered
+	// input. every pass computes X'(XQ) block by block.
+	var y *mat64.Dense
+	for i := 0; i <= power_iterations+1; i++ {
+		if i > 0 {
+			q = y
+			orthonormalize(q)
+		}
+		y = s.pass(cols, subspace, func(block, acc *mat64.Dense) {
+			var xq, xtxq mat64.Dense
+			xq.Mul(block, q)
+			xtxq.Mul(block.T(), &xq)
+			acc.Add(acc, &xtxq)
+		})
+		res.Passes++
+	}
+
+	// y is now X'XQ for an orthonormal q, so Q'X'XQ is the covariance matrix
+	// restricted to the subspace. its eigenvectors rotate q onto the principal
+	// components.
+	var b mat64.Dense
+	b.Mul(q.T(), y)
+	sym := mat64.NewSymDense(subspace, nil)
+	for i := 0; i < subspace; i++ {
+		for j := i; j < subspace; j++ {
+			sym.SetSym(i, j, (b.At(i, j)+b.At(j, i))/2)
+		}
+	}
+	var eigen mat64.EigenSym
+	if !eigen.Factorize(sym, true) {
+		return nil, fmt.Errorf("eigendecomposition failed")
+	}
+	values := eigen.Values(nil)
+	var vectors mat64.Dense
+	vectors.EigenvectorsSym(&eigen)
+
+	order := make([]int, subspace)
+	for i := range order {
+		order[i] = i
+	}
+	sort.SliceStable(order, func(i, j int) bool {
+		return values[order[i]] > values[order[j]]
+	})
+
+	var rotated mat64.Dense
+	rotated.Mul(q, &vectors)
+	res.Loadings = mat64.NewDense(cols, components, nil)
+	res.Variance = mat64.NewVector(components, nil)
+	res.ExplainedVariance = mat64.NewVector(components, nil)
+	for c, idx := range order[:components] {
+		loading := make([]float64, cols)
+		mat64.Col(loading, idx, &rotated)
+		canonicalSign(loading)
+		res.Loadings.SetCol(c, loading)
+		value := math.Max(values[idx], 0)
+		res.Variance.SetVec(c, value/float64(rows-1))
+		if total > 0 {
+			res.ExplainedVariance.SetVec(c, value/total)
+		}
+	}
+	return res, nil
+}
+
+// columnMeans returns the mean of every column of h, ignoring NaNs, along with
+// the total sum of squared deviations from those means.
+func columnMeans(h *mmm.Handle) (means []float64, total float64) {
+	cols := h.Cols()
+	sums := make([]float64, cols)
+	squared_sums := make([]float64, cols)
+	counts := make([]int, cols)
+	for idx := 0; idx < h.Rows(); idx++ {
+		for j, val := range h.RowByIdx(idx) {
+			if math.IsNaN(float64(val)) {
+				continue
+			}
+			v := float64(val)
+			sums[j] += v
+			squared_sums[j] += v * v
+			counts[j]++
+		}
+	}
+	means = make([]float64, cols)
+	for j := range means {
+		if counts[j] == 0 {
+			continue
+		}
+		means[j] = sums[j] / float64(counts[j])
+		total += math.Max(squared_sums[j]-means[j]*sums[j], 0)
+	}
+	return means, total
+}
+
+// orthonormalize replaces the columns of m with an orthonormal basis of their
+// span using modified Gram-Schmidt, run twice for numerical stability.
+// Columns that are linearly dependent on earlier ones become zero.
+func orthonormalize(m *mat64.Dense) {
+	rows, cols := m.Dims()
+	col := make([]float64, rows)
+	basis := make([][]float64, cols)
+	for j := 0; j < cols; j++ {
+		mat64.Col(col, j, m)
+		original := math.Sqrt(dot(col, col))
+		for pass := 0; pass < 2; pass++ {
+			for _, prev := range basis[:j] {
+				d := dot(col, prev)
+				for i := range col {
+					col[i] -= d * prev[i]
+				}
+			}
+		}
+		norm := math.Sqrt(dot(col, col))
+		if norm <= 1e-10*original || norm == 0 {
+			norm = math.Inf(1)
+		}
+		basis[j] = make([]float64, rows)
+		for i := range col {
+			basis[j][i] = col[i] / norm
+		}
+		m.SetCol(j, basis[j])
+	}
+}
+
+func dot(a, b []float64) (sum float64) {
+	for i, v := range a {
+		sum += v * b[i]
+	}
+	return sum
+}
+
+// canonicalSign flips v if needed so its largest magnitude entry is positive,
+// which makes components comparable between runs.
+func canonicalSign(v []float64) {
+	largest := 0
+	for i := range v {
+		if math.Abs(v[i]) > math.Abs(v[largest]) {
+			largest = i
+		}
+	}
+	if len(v) > 0 && v[largest] < 0 {
+		for i := range v {
+			v[i] = -v[i]
+		}
+	}
+}
+
+// streamer reads centered blocks of rows from an mmm.Handle.
+type streamer struct {
+	h                  *mmm.Handle
+	means              []float64
+	blockRows, workers int
+}
+
+// pass calls cb with every block of centered rows, with NaNs replaced by
+// zero, along with an accumulator matrix of size acc_rows by acc_cols owned by
+// the worker processing the block. The accumulators of all workers are summed
+// and returned.
+func (s *streamer) pass(acc_rows, acc_cols int,
+	cb func(block, acc *mat64.Dense)) *mat64.Dense {
+	rows, cols := s.h.Rows(), s.h.Cols()
+	blocks := (rows + s.blockRows - 1) / s.blockRows
+	workers := s.workers
+	if workers <= 0 {
+		workers = runtime.GOMAXPROCS(0)
+	}
+	if workers > blocks {
+		workers = blocks
+	}
+
+	accs := make([]*mat64.Dense, workers)
+	var next int64
+	var wg sync.WaitGroup
+	for w := range accs {
+		accs[w] = mat64.NewDense(acc_rows, acc_cols, nil)
+		wg.Add(1)
+		go func(acc *mat64.Dense) {
+			defer wg.Done()
+			buf := make([]float64, s.blockRows*cols)
+			for {
+				b := int(atomic.AddInt64(&next, 1) - 1)
+				if b >= blocks {
+					return
+				}
+				start := b * s.blockRows
+				end := start + s.blockRows
+				if end > rows {
+					end = rows
+				}
+				data := buf[:(end-start)*cols]
+				for i := start; i < end; i++ {
+					out := data[(i-start)*cols : (i-start+1)*cols]
+					for j, val := range s.h.RowByIdx(i) {
+						if math.IsNaN(float64(val)) {
+							out[j] = 0
+						} else {
+							out[j] = float64(val) - s.means[j]
+						}
+					}
+				}
+				cb(mat64.NewDense(end-start, cols, data), acc)
+			}
+		}(accs[w])
+	}
+	wg.Wait()
+
+	for _, acc := range accs[1:] {
+		accs[0].Add(accs[0], acc)
+	}
+	return accs[0]
+}
@@ -0,0 +1,446 @@
+// Copyright (C) 2017 JT Olds
+// See LICENSE for copying information
+
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+
+	"github.com/jtolds/golincs/web/dbs"
+	"github.com/jtolds/golincs/web/wire"
+	"gopkg.in/webhelp.v1/whcompat"
+	"gopkg.in/webhelp.v1/wherr"
+	"gopkg.in/webhelp.v1/whfatal"
+	"gopkg.in/webhelp.v1/whmux"
+	"gopkg.in/webhelp.v1/whparse"
+)
+
+// The json api mirrors the html endpoints under /api/v1/, taking the same
+// form values, and adds the endpoints the client package needs to implement
+// dbs.Dataset remotely. Errors are returned as a wire.ErrorBody with the
+// error's HTTP status.
+
+// maxAPIBody bounds the size of json request bodies.
+const maxAPIBody = 64 << 20
+
+func handleAPIError(w http.ResponseWriter, r *http.Request, err error) {
+	code, message, ok := errorMessage(r, err)
+	if !ok {
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(wire.ErrorBody{Error: wire.Error{
+		Code:    code,
+		Status:  http.StatusText(code),
+		Message: message,
+	}})
+}
+
+// apiHandler serves h with json errors.
+func apiHandler(h http.Handler) http.Handler {
+	return wherr.HandleWith(wherr.HandlerFunc(handleAPIError),
+		whfatal.Catch(h))
+}
+
+func writeJSON(w http.ResponseWriter, val interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	err := json.NewEncoder(w).Encode(val)
+	if err != nil {
+		whfatal.Error(err)
+	}
+}
+
+// readJSON decodes a json request body into val.
+func readJSON(w http.ResponseWriter, r *http.Request, val interface{}) {
+	err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxAPIBody)).
+		Decode(val)
+	if err != nil {
+		whfatal.Error(wherr.BadRequest.New("invalid request body: %v", err))
+	}
+}
+
+func newAPIDataset(id int, data dbs.Dataset) wire.Dataset {
+	return wire.Dataset{
+		Id:             id,
+		Name:           data.Name(),
+		Dimensions:     data.Dimensions(),
+		DimMax:         data.DimMax(),
+		SampleTagNames: data.SampleTagNames(),
+		Samples:        data.Samples(),
+		GeneSigs:       data.GeneSigs(),
+		Genesets:       data.Genesets(),
+	}
+}
+
+// apiScore returns the score of a search result, or nil for a plain entry.
+func apiScore(val interface{}) *float64 {
+	if scored, ok := val.(interface {
+		Score() float64
+	}); ok {
+		score := scored.Score()
+		return &score
+	}
+	return nil
+}
+
+func newAPISample(s dbs.Sample) wire.Sample {
+	return wire.Sample{Id: s.Id(), Name: s.Name(), Tags: s.Tags(),
+		Score: apiScore(s)}
+}
+
+func newAPIGeneSig(s dbs.GeneSig) wire.GeneSig {
+	return wire.GeneSig{Id: s.Id(), Name: s.Name(), Score: apiScore(s)}
+}
+
+func newAPIGeneset(s dbs.Geneset) wire.Geneset {
+	return wire.Geneset{Id: s.Id(), Name: s.Name(),
+		Description: s.Description(), Genes: s.Genes(), Score: apiScore(s)}
+}
+
+// apiResults converts a list of dataset entries or search results to their
+// json forms.
+func apiResults(results interface{}) interface{} {
+	switch results := results.(type) {
+	case []dbs.Sample:
+		rv := make([]wire.Sample, 0, len(results))
+		for _, s := range results {
+			rv = append(rv, newAPISample(s))
+		}
+		return rv
+	case []dbs.ScoredSample:
+		rv := make([]wire.Sample, 0, len(results))
+		for _, s := range results {
+			rv = append(rv, newAPISample(s))
+		}
+		return rv
+	case []dbs.GeneSig:
+		rv := make([]wire.GeneSig, 0, len(results))
+		for _, s := range results {
+			rv = append(rv, newAPIGeneSig(s))
+		}
+		return rv
+	case []dbs.ScoredGeneSig:
+		rv := make([]wire.GeneSig, 0, len(results))
+		for _, s := range results {
+			rv = append(rv, newAPIGeneSig(s))
+		}
+		return rv
+	case []dbs.Geneset:
+		rv := make([]wire.Geneset, 0, len(results))
+		for _, s := range results {
+			rv = append(rv, newAPIGeneset(s))
+		}
+		return rv
+	case []dbs.ScoredGeneset:
+		rv := make([]wire.Geneset, 0, len(results))
+		for _, s := range results {
+			rv = append(rv, newAPIGeneset(s))
+		}
+		return rv
+	}
+	panic("unknown result type")
+}
+
+func apiDimensions(dims []dbs.Dimension, err error) []wire.Dimension {
+	if err != nil {
+		whfatal.Error(err)
+	}
+	return wire.FromDimensions(dims)
+}
+
+func apiDatasets(datasets []dbs.Dataset) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		rv := make([]wire.Dataset, 0, len(datasets))
+		for id, data := range datasets {
+			rv = append(rv, newAPIDataset(id, data))
+		}
+		writeJSON(w, rv)
+	})
+}
+
+// APIRoutes returns the json api for the dataset, which has id id.
+func (a *Endpoints) APIRoutes(id int) http.Handler {
+	return whmux.Dir{
+		"": whmux.Exact(http.HandlerFunc(
+			func(w http.ResponseWriter, r *http.Request) {
+				writeJSON(w, newAPIDataset(id, a.data))
+			})),
+
+		"samples":  whmux.Exact(http.HandlerFunc(a.APISamples)),
+		"genesigs": whmux.Exact(http.HandlerFunc(a.APIGeneSigs)),
+		"genesets": whmux.Exact(http.HandlerFunc(a.APIGenesets)),
+
+		"sample": sampleId.Shift(whmux.Dir{
+			"":     whmux.Exact(http.HandlerFunc(a.APISample)),
+			"data": whmux.Exact(http.HandlerFunc(a.APISampleData)),
+			"neighbors": whmux.Exact(
+				http.HandlerFunc(a.APISampleNeighbors)),
+		}),
+		"genesig": geneSigId.Shift(whmux.Dir{
+			"":     whmux.Exact(http.HandlerFunc(a.APIGeneSig)),
+			"data": whmux.Exact(http.HandlerFunc(a.APIGeneSigData)),
+			"neighbors": whmux.Exact(
+				http.HandlerFunc(a.APIGeneSigNeighbors)),
+		}),
+		"geneset": genesetId.Shift(whmux.Dir{
+			"":      whmux.Exact(http.HandlerFunc(a.APIGeneset)),
+			"query": whmux.Exact(http.HandlerFunc(a.APIGenesetQuery)),
+		}),
+
+		"search": whmux.Dir{
+			"keyword":   whmux.Exact(http.HandlerFunc(a.APIKeyword)),
+			"signature": whmux.Exact(http.HandlerFunc(a.APISignature)),
+		},
+
+		"nearest": whmux.Exact(whmux.RequireMethod("POST",
+			http.HandlerFunc(a.APINearest))),
+		"nearest_batch": whmux.Exact(whmux.RequireMethod("POST",
+			http.HandlerFunc(a.APINearestBatch))),
+		"combine": whmux.Exact(whmux.RequireMethod("POST",
+			http.HandlerFunc(a.APICombine))),
+	}
+}
+
+func (a *Endpoints) apiList(w http.ResponseWriter, r *http.Request,
+	total int, list func(offset, limit int) (interface{}, error)) {
+	offset := whparse.OptInt(r.FormValue("offset"), 0)
+	limit := whparse.OptInt(r.FormValue("limit"), defaultLimit)
+	results, err := list(offset, limit)
+	if err != nil {
+		whfatal.Error(err)
+	}
+	writeJSON(w, wire.List{
+		Offset:  offset,
+		Limit:   limit,
+		Total:   total,
+		Results: apiResults(results),
+	})
+}
+
+func (a *Endpoints) APISamples(w http.ResponseWriter, r *http.Request) {
+	a.apiList(w, r, a.data.Samples(),
+		func(offset, limit int) (interface{}, error) {
+			return a.data.ListSamplesContext(whcompat.Context(r), offset, limit)
+		})
+}
+
+func (a *Endpoints) APIGeneSigs(w http.ResponseWriter, r *http.Request) {
+	a.apiList(w, r, a.data.GeneSigs(),
+		func(offset, limit int) (interface{}, error) {
+			return a.data.ListGeneSigsContext(whcompat.Context(r), offset, limit)
+		})
+}
+
+func (a *Endpoints) APIGenesets(w http.ResponseWriter, r *http.Request) {
+	a.apiList(w, r, a.data.Genesets(),
+		func(offset, limit int) (interface{}, error) {
+			return a.data.ListGenesetsContext(whcompat.Context(r), offset, limit)
+		})
+}
+
+func (a *Endpoints) apiSample(r *http.Request) dbs.Sample {
+	ctx := whcompat.Context(r)
+	sample, err := a.data.GetSampleContext(ctx, sampleId.Get(ctx))
+	if err != nil {
+		whfatal.Error(err)
+	}
+	return sample
+}
+
+func (a *Endpoints) APISample(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, newAPISample(a.apiSample(r)))
+}
+
+func (a *Endpoints) APISampleData(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, apiDimensions(a.apiSample(r).Data()))
+}
+
+func (a *Endpoints) APISampleNeighbors(w http.ResponseWriter,
+	r *http.Request) {
+	similar, opposite, err := a.data.SampleNeighborsContext(
+		whcompat.Context(r), a.apiSample(r).Id(),
+		whparse.OptInt(r.FormValue("limit"), neighborLimit))
+	if err != nil {
+		whfatal.Error(err)
+	}
+	writeJSON(w, wire.Neighbors{
+		Similar:  apiResults(similar),
+		Opposite: apiResults(opposite),
+	})
+}
+
+func (a *Endpoints) apiGeneSig(r *http.Request) dbs.GeneSig {
+	ctx := whcompat.Context(r)
+	genesig, err := a.data.GetGeneSigContext(ctx, geneSigId.Get(ctx))
+	if err != nil {
+		whfatal.Error(err)
+	}
+	return genesig
+}
+
+func (a *Endpoints) APIGeneSig(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, newAPIGeneSig(a.apiGeneSig(r)))
+}
+
+func (a *Endpoints) APIGeneSigData(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, apiDimensions(a.apiGeneSig(r).Data()))
+}
+
+func (a *Endpoints) APIGeneSigNeighbors(w http.ResponseWriter,
+	r *http.Request) {
+	similar, opposite, err := a.data.GeneSigNeighborsContext(
+		whcompat.Context(r), a.apiGeneSig(r).Id(),
+		whparse.OptInt(r.FormValue("limit"), neighborLimit))
+	if err != nil {
+		whfatal.Error(err)
+	}
+	writeJSON(w, wire.Neighbors{
+		Similar:  apiResults(similar),
+		Opposite: apiResults(opposite),
+	})
+}
+
+func (a *Endpoints) apiGeneset(r *http.Request) dbs.Geneset {
+	ctx := whcompat.Context(r)
+	geneset, err := a.data.GetGenesetContext(ctx, genesetId.Get(ctx))
+	if err != nil {
+		whfatal.Error(err)
+	}
+	return geneset
+}
+
+func (a *Endpoints) APIGeneset(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, newAPIGeneset(a.apiGeneset(r)))
+}
+
+func (a *Endpoints) APIGenesetQuery(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, apiDimensions(a.apiGeneset(r).Query()))
+}
+
+func (a *Endpoints) APIKeyword(w http.ResponseWriter, r *http.Request) {
+	rtype, offset, limit, results := a.keywordSearch(r)
+	writeJSON(w, wire.List{
+		Rtype:   rtype,
+		Offset:  offset,
+		Limit:   limit,
+		Total:   -1,
+		Results: apiResults(results),
+	})
+}
+
+func (s *signatureResults) apiList() wire.List {
+	rv := wire.List{
+		QueryId: s.spec.Id(),
+		Query:   s.spec.Encode(),
+		Rtype:   s.spec.Rtype,
+		Offset:  s.offset,
+		Limit:   s.limit,
+		Total:   s.total,
+	}
+	switch s.spec.Rtype {
+	case "samples":
+		rv.Results = apiResults(s.samples)
+	case "genesigs":
+		rv.Results = apiResults(s.genesigs)
+	case "genesets":
+		rv.Results = apiResults(s.genesets)
+	}
+	return rv
+}
+
+func (a *Endpoints) APISignature(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, a.signatureSearch(w, r).apiList())
+}
+
+// APINearest ranks entries by similarity to arbitrary dimension values,
+// which signature searches can't express. Tag filters work like a signature
+// search's, but other dbs.SampleFilter and dbs.ScoreFilter functions are
+// applied by the client as it pages through results.
+func (a *Endpoints) APINearest(w http.ResponseWriter, r *http.Request) {
+	var req wire.NearestRequest
+	readJSON(w, r, &req)
+	if req.Limit <= 0 {
+		req.Limit = defaultLimit
+	}
+	filters, err := dbs.ParseTagFilters(strings.Join(req.Filters, " "))
+	if err != nil {
+		whfatal.Error(err)
+	}
+	spec := newQuerySpec(wire.ToDimensions(req.Dims), filters,
+		searchRtype(req.Rtype), dbs.SearchOptions{
+			Exact:      req.Exact,
+			Candidates: req.Candidates,
+		})
+	writeJSON(w, a.searchPage(w, r, spec, req.Offset, req.Limit).apiList())
+}
+
+func (a *Endpoints) APINearestBatch(w http.ResponseWriter, r *http.Request) {
+	var req wire.BatchRequest
+	readJSON(w, r, &req)
+	switch req.Rtype {
+	default:
+		whfatal.Error(wherr.BadRequest.New("invalid rtype %q", req.Rtype))
+	case "samples", "genesigs", "":
+	}
+	if req.Limit <= 0 {
+		req.Limit = defaultLimit
+	}
+	if len(req.Queries) > wire.MaxBatchQueries {
+		whfatal.Error(wherr.BadRequest.New("%d queries is more than the "+
+			"limit of %d. split them up or submit a batch job",
+			len(req.Queries), wire.MaxBatchQueries))
+	}
+	if len(req.Queries)*req.Limit > wire.MaxBatchResults {
+		whfatal.Error(wherr.BadRequest.New("%d queries with a limit of %d is "+
+			"more than %d results. split them up or submit a batch job",
+			len(req.Queries), req.Limit, wire.MaxBatchResults))
+	}
+	queries := make([][]dbs.Dimension, 0, len(req.Queries))
+	for _, query := range req.Queries {
+		queries = append(queries, wire.ToDimensions(query))
+	}
+
+	ctx := whcompat.Context(r)
+	release, err := a.scans.Acquire(ctx, clientKey(r))
+	if err != nil {
+		a.failScan(w, err)
+	}
+	defer release()
+
+	var rv wire.BatchResults
+	switch req.Rtype {
+	case "samples", "":
+		results, err := a.data.NearestSamplesBatchContext(ctx, queries,
+			req.Limit)
+		if err != nil {
+			whfatal.Error(err)
+		}
+		lists := make([]interface{}, 0, len(results))
+		for _, result := range results {
+			lists = append(lists, apiResults(result))
+		}
+		rv.Results = lists
+	case "genesigs":
+		results, err := a.data.NearestGeneSigsBatchContext(ctx, queries,
+			req.Limit)
+		if err != nil {
+			whfatal.Error(err)
+		}
+		lists := make([]interface{}, 0, len(results))
+		for _, result := range results {
+			lists = append(lists, apiResults(result))
+		}
+		rv.Results = lists
+	}
+	writeJSON(w, rv)
+}
+
+func (a *Endpoints) APICombine(w http.ResponseWriter, r *http.Request) {
+	var req wire.CombineRequest
+	readJSON(w, r, &req)
+	writeJSON(w, apiDimensions(a.data.CombineGenesContext(whcompat.Context(r),
+		wire.ToGenes(req.Genes))))
+}
@@ -0,0 +1,309 @@
+// Copyright (C) 2017 JT Olds
+// See LICENSE for copying information
+
+package main
+
+import (
+	"bytes"
+	"compress/flate"
+	"container/list"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/gob"
+	"encoding/hex"
+	"flag"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/jtolds/golincs/web/dbs"
+)
+
+var (
+	cacheDepth = flag.Int("cache_depth", 1000,
+		"number of ranked results to cache per signature search. pages past "+
+			"this depth are computed directly")
+	cacheFill = flag.Int("cache_fill", 100,
+		"number of ranked results first cached for a signature search that "+
+			"isn't exact, so approximate indexes can answer it. the cached "+
+			"ranking is deepened, up to -cache_depth, as later pages need it")
+	cacheEntries = flag.Int("cache_entries", 1000,
+		"maximum number of signature searches to cache per dataset")
+	cacheBytes = flag.Int64("cache_bytes", 64<<20,
+		"approximate maximum memory used by cached signature searches per "+
+			"dataset")
+)
+
+// querySpec is a signature search in canonical form, so that equivalent
+// searches have the same id.
+type querySpec struct {
+	Dims    []dbs.Dimension
+	Filters []string
+	Rtype   string
+	Opts    dbs.SearchOptions
+}
+
+func newQuerySpec(dims []dbs.Dimension, filters []string, rtype string,
+	opts dbs.SearchOptions) querySpec {
+	spec := querySpec{
+		Dims:    append([]dbs.Dimension(nil), dims...),
+		Filters: append([]string(nil), filters...),
+		Rtype:   rtype,
+		Opts:    opts,
+	}
+	sort.Slice(spec.Dims, func(i, j int) bool {
+		return spec.Dims[i].Name < spec.Dims[j].Name
+	})
+	sort.Strings(spec.Filters)
+	if spec.Opts.Exact {
+		spec.Opts.Candidates = 0
+	}
+	if spec.Opts.Candidates < 0 {
+		spec.Opts.Candidates = 0
+	}
+	return spec
+}
+
+// Id returns a hash of the search that can be shared in URLs.
+func (s querySpec) Id() string {
+	h := sha256.New()
+	fmt.Fprintf(h, "rtype:%q\n", s.Rtype)
+	fmt.Fprintf(h, "exact:%v\ncandidates:%d\n", s.Opts.Exact,
+		s.Opts.Candidates)
+	for _, filter := range s.Filters {
+		fmt.Fprintf(h, "filter:%q\n", filter)
+	}
+	for _, dim := range s.Dims {
+		fmt.Fprintf(h, "dim:%q=%s\n", dim.Name,
+			strconv.FormatFloat(dim.Value, 'g', -1, 64))
+	}
+	return hex.EncodeToString(h.Sum(nil)[:12])
+}
+
+// Encode returns the whole search as a URL-safe string, so links to the
+// search keep working after it leaves the cache or the server restarts.
+func (s querySpec) Encode() string {
+	var buf bytes.Buffer
+	w, err := flate.NewWriter(&buf, flate.BestCompression)
+	if err != nil {
+		panic(err)
+	}
+	err = gob.NewEncoder(w).Encode(s)
+	if err == nil {
+		err = w.Close()
+	}
+	if err != nil {
+		panic(err)
+	}
+	return base64.RawURLEncoding.EncodeToString(buf.Bytes())
+}
+
+// maxEncodedSpec limits how much data an encoded search may expand to.
+const maxEncodedSpec = 4 << 20
+
+// decodeQuerySpec reads a search made by querySpec.Encode, and puts it back
+// in canonical form.
+func decodeQuerySpec(val string) (spec querySpec, err error) {
+	data, err := base64.RawURLEncoding.DecodeString(val)
+	if err != nil {
+		return querySpec{}, err
+	}
+	err = gob.NewDecoder(io.LimitReader(flate.NewReader(bytes.NewReader(data)),
+		maxEncodedSpec)).Decode(&spec)
+	if err != nil {
+		return querySpec{}, err
+	}
+	filters, err := dbs.ParseTagFilters(strings.Join(spec.Filters, " "))
+	if err != nil {
+		return querySpec{}, err
+	}
+	return newQuerySpec(spec.Dims, filters, spec.Rtype, spec.Opts), nil
+}
+
+// WithRtype returns the same search for a different result type.
+func (s querySpec) WithRtype(rtype string) querySpec {
+	s.Rtype = rtype
+	return s
+}
+
+// ranking is the best results of a search, best first. Only the list for
+// the search's rtype is set. The entries are kept as the search loaded them,
+// so pages of results don't need to be looked up again.
+type ranking struct {
+	Samples  []dbs.ScoredSample
+	GeneSigs []dbs.ScoredGeneSig
+	Genesets []dbs.ScoredGeneset
+	// Complete is true if the ranking has every result, rather than stopping
+	// at the cache depth.
+	Complete bool
+}
+
+// Len returns how many results the ranking has.
+func (r *ranking) Len() int {
+	return len(r.Samples) + len(r.GeneSigs) + len(r.Genesets)
+}
+
+// slice returns the results from start up to end, or to the end of the
+// ranking if it's shorter.
+func (r *ranking) slice(start, end int) *ranking {
+	if end > r.Len() {
+		end = r.Len()
+	}
+	if start > end {
+		start = end
+	}
+	rv := &ranking{}
+	switch {
+	case len(r.Samples) > 0:
+		rv.Samples = r.Samples[start:end]
+	case len(r.GeneSigs) > 0:
+		rv.GeneSigs = r.GeneSigs[start:end]
+	case len(r.Genesets) > 0:
+		rv.Genesets = r.Genesets[start:end]
+	}
+	return rv
+}
+
+// extend returns r followed by the results of deeper that r doesn't have
+// yet. A deeper ranking can come from a different index than r did and
+// order r's results differently, so keeping r as its prefix keeps the pages
+// already served from changing.
+func (r *ranking) extend(deeper *ranking) *ranking {
+	seen := make(map[string]bool, r.Len())
+	rv := &ranking{
+		Samples:  append([]dbs.ScoredSample(nil), r.Samples...),
+		GeneSigs: append([]dbs.ScoredGeneSig(nil), r.GeneSigs...),
+		Genesets: append([]dbs.ScoredGeneset(nil), r.Genesets...),
+		Complete: deeper.Complete,
+	}
+	for _, s := range r.Samples {
+		seen[s.Id()] = true
+	}
+	for _, s := range r.GeneSigs {
+		seen[s.Id()] = true
+	}
+	for _, s := range r.Genesets {
+		seen[s.Id()] = true
+	}
+	for _, s := range deeper.Samples {
+		if !seen[s.Id()] {
+			rv.Samples = append(rv.Samples, s)
+		}
+	}
+	for _, s := range deeper.GeneSigs {
+		if !seen[s.Id()] {
+			rv.GeneSigs = append(rv.GeneSigs, s)
+		}
+	}
+	for _, s := range deeper.Genesets {
+		if !seen[s.Id()] {
+			rv.Genesets = append(rv.Genesets, s)
+		}
+	}
+	return rv
+}
+
+type cacheEntry struct {
+	id      string
+	spec    querySpec
+	ranking *ranking
+	size    int64
+}
+
+func (e *cacheEntry) computeSize() {
+	e.size = 256
+	for _, dim := range e.spec.Dims {
+		e.size += int64(len(dim.Name)) + 32
+	}
+	for _, filter := range e.spec.Filters {
+		e.size += int64(len(filter)) + 16
+	}
+	entry := func(id, name string) int64 {
+		return int64(len(id)+len(name)) + 64
+	}
+	for _, s := range e.ranking.Samples {
+		e.size += entry(s.Id(), s.Name())
+		for key, val := range s.Tags() {
+			e.size += int64(len(key)+len(val)) + 32
+		}
+	}
+	for _, s := range e.ranking.GeneSigs {
+		e.size += entry(s.Id(), s.Name())
+	}
+	for _, s := range e.ranking.Genesets {
+		e.size += entry(s.Id(), s.Name())
+	}
+}
+
+// resultCache is an LRU cache of search rankings, limited both by entry
+// count and by approximate memory use.
+type resultCache struct {
+	depth      int
+	maxEntries int
+	maxBytes   int64
+
+	mtx     sync.Mutex
+	bytes   int64
+	lru     *list.List
+	entries map[string]*list.Element
+}
+
+func newResultCache(depth, max_entries int, max_bytes int64) *resultCache {
+	return &resultCache{
+		depth:      depth,
+		maxEntries: max_entries,
+		maxBytes:   max_bytes,
+		lru:        list.New(),
+		entries:    map[string]*list.Element{},
+	}
+}
+
+// Depth is how many results are kept per search.
+func (c *resultCache) Depth() int { return c.depth }
+
+// Get returns the search and ranking for a query id, if it's cached.
+func (c *resultCache) Get(id string) (spec querySpec, r *ranking, ok bool) {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	elem, ok := c.entries[id]
+	if !ok {
+		return querySpec{}, nil, false
+	}
+	c.lru.MoveToFront(elem)
+	entry := elem.Value.(*cacheEntry)
+	return entry.spec, entry.ranking, true
+}
+
+// Put caches the ranking for a search, evicting the least recently used
+// searches as needed to stay within the limits.
+func (c *resultCache) Put(spec querySpec, r *ranking) {
+	if c.depth <= 0 || c.maxEntries <= 0 {
+		return
+	}
+	entry := &cacheEntry{id: spec.Id(), spec: spec, ranking: r}
+	entry.computeSize()
+	if entry.size > c.maxBytes {
+		return
+	}
+
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	if elem, exists := c.entries[entry.id]; exists {
+		c.remove(elem)
+	}
+	c.entries[entry.id] = c.lru.PushFront(entry)
+	c.bytes += entry.size
+	for c.lru.Len() > c.maxEntries || c.bytes > c.maxBytes {
+		c.remove(c.lru.Back())
+	}
+}
+
+func (c *resultCache) remove(elem *list.Element) {
+	entry := elem.Value.(*cacheEntry)
+	c.lru.Remove(elem)
+	delete(c.entries, entry.id)
+	c.bytes -= entry.size
+}
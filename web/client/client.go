@@ -0,0 +1,683 @@
+// Copyright (C) 2017 JT Olds
+// See LICENSE for copying information
+
+// Package client implements dbs.Dataset on top of a golincs web server's
+// json api, so code written against a local dataset can run against a
+// remote one instead.
+package client
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jtolds/golincs/web/dbs"
+	"github.com/jtolds/golincs/web/wire"
+	"github.com/spacemonkeygo/errors/errhttp"
+)
+
+var (
+	Err            = dbs.Err.NewClass("remote")
+	ErrBadRequest  = Err.NewClass("bad request", errhttp.SetStatusCode(400))
+	ErrUnavailable = Err.NewClass("unavailable", errhttp.SetStatusCode(503))
+	ErrTimeout     = Err.NewClass("timeout", errhttp.SetStatusCode(504))
+)
+
+const (
+	// maxRetries is how many times a request turned away by the server's
+	// search queue is retried before giving up.
+	maxRetries = 5
+	// pageSize is how many results are first fetched at a time when results
+	// have to be filtered locally. Pages double in size from there, since
+	// pages past the server's cache depth each cost a full search.
+	pageSize = 500
+)
+
+// Dataset is a dataset served by a golincs web server.
+type Dataset struct {
+	hc   *http.Client
+	base string
+	info wire.Dataset
+}
+
+var _ dbs.Dataset = (*Dataset)(nil)
+
+// Open connects to dataset id on the golincs web server at server, such as
+// http://localhost:8080.
+func Open(server string, id int) (*Dataset, error) {
+	return OpenWith(http.DefaultClient, server, id)
+}
+
+// OpenWith is like Open, but makes requests with hc.
+func OpenWith(hc *http.Client, server string, id int) (*Dataset, error) {
+	base := fmt.Sprintf("%s/api/v1/datasets/%d/",
+		strings.TrimRight(server, "/"), id)
+	ds := &Dataset{hc: hc, base: base}
+	err := ds.get(context.Background(), "", nil, &ds.info)
+	if err != nil {
+		return nil, err
+	}
+	return ds, nil
+}
+
+// do makes an api request, sending body as json if it isn't nil, and
+// decodes the json response into out. Requests turned away because the
+// server is busy are retried after the delay the server asks for.
+func (ds *Dataset) do(ctx context.Context, method, path string,
+	query url.Values, body, out interface{}) error {
+	u := ds.base + path
+	if len(query) > 0 {
+		u += "?" + query.Encode()
+	}
+	var data []byte
+	if body != nil {
+		var err error
+		data, err = json.Marshal(body)
+		if err != nil {
+			return Err.Wrap(err)
+		}
+	}
+
+	for attempt := 0; ; attempt++ {
+		var body_reader io.Reader
+		if body != nil {
+			body_reader = bytes.NewReader(data)
+		}
+		req, err := http.NewRequest(method, u, body_reader)
+		if err != nil {
+			return Err.Wrap(err)
+		}
+		req = req.WithContext(ctx)
+		if body != nil {
+			req.Header.Set("Content-Type", "application/json")
+		}
+		req.Header.Set("Accept", "application/json")
+
+		resp, err := ds.hc.Do(req)
+		if err != nil {
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			return Err.Wrap(err)
+		}
+		if resp.StatusCode == http.StatusOK {
+			err = json.NewDecoder(resp.Body).Decode(out)
+			resp.Body.Close()
+			if err != nil {
+				return Err.New("%s %s: bad response: %v", method, path, err)
+			}
+			return nil
+		}
+
+		err = responseError(resp)
+		retry := retryAfter(resp)
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusServiceUnavailable ||
+			attempt >= maxRetries {
+			return err
+		}
+		timer := time.NewTimer(retry)
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			timer.Stop()
+			return ctx.Err()
+		}
+	}
+}
+
+// responseError turns an error response into an error of the matching
+// class.
+func responseError(resp *http.Response) error {
+	var body wire.ErrorBody
+	data, err := ioutil.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil || json.Unmarshal(data, &body) != nil ||
+		body.Error.Message == "" {
+		body.Error.Message = strings.TrimSpace(string(data))
+		if body.Error.Message == "" {
+			body.Error.Message = resp.Status
+		}
+	}
+	message := body.Error.Message
+	switch resp.StatusCode {
+	case http.StatusNotFound:
+		return dbs.ErrNotFound.New("%s", message)
+	case http.StatusBadRequest:
+		return ErrBadRequest.New("%s", message)
+	case http.StatusServiceUnavailable:
+		return ErrUnavailable.New("%s", message)
+	case http.StatusGatewayTimeout:
+		return ErrTimeout.New("%s", message)
+	}
+	return Err.New("%s: %s", resp.Status, message)
+}
+
+// retryAfter is how long the server asked us to wait before retrying.
+func retryAfter(resp *http.Response) time.Duration {
+	seconds, err := strconv.Atoi(resp.Header.Get("Retry-After"))
+	if err != nil || seconds < 1 {
+		return time.Second
+	}
+	return time.Duration(seconds) * time.Second
+}
+
+func (ds *Dataset) get(ctx context.Context, path string, query url.Values,
+	out interface{}) error {
+	return ds.do(ctx, "GET", path, query, nil, out)
+}
+
+func (ds *Dataset) post(ctx context.Context, path string, body,
+	out interface{}) error {
+	return ds.do(ctx, "POST", path, nil, body, out)
+}
+
+func pageQuery(offset, limit int) url.Values {
+	return url.Values{
+		"offset": {fmt.Sprint(offset)},
+		"limit":  {fmt.Sprint(limit)},
+	}
+}
+
+// filtered collects the results from offset to offset+limit of a search
+// that has to be filtered locally. fetch gets a page of unfiltered results
+// and returns how many it got, keep says whether the ith result of the last
+// page passes the filters, and add collects it.
+func filtered(offset, limit int, fetch func(offset, limit int) (int, error),
+	keep func(i int) bool, add func(i int)) error {
+	if limit <= 0 {
+		return nil
+	}
+	skipped, added, page_offset := 0, 0, 0
+	for size := pageSize; ; size *= 2 {
+		n, err := fetch(page_offset, size)
+		if err != nil {
+			return err
+		}
+		for i := 0; i < n; i++ {
+			if !keep(i) {
+				continue
+			}
+			if skipped < offset {
+				skipped++
+				continue
+			}
+			add(i)
+			added++
+			if added >= limit {
+				return nil
+			}
+		}
+		if n < size {
+			return nil
+		}
+		page_offset += size
+	}
+}
+
+func (ds *Dataset) Name() string             { return ds.info.Name }
+func (ds *Dataset) Dimensions() int          { return ds.info.Dimensions }
+func (ds *Dataset) DimMax() float64          { return ds.info.DimMax }
+func (ds *Dataset) SampleTagNames() []string { return ds.info.SampleTagNames }
+func (ds *Dataset) Samples() int             { return ds.info.Samples }
+func (ds *Dataset) GeneSigs() int            { return ds.info.GeneSigs }
+func (ds *Dataset) Genesets() int            { return ds.info.Genesets }
+
+func (ds *Dataset) ListSamples(offset, limit int) ([]dbs.Sample, error) {
+	return ds.ListSamplesContext(context.Background(), offset, limit)
+}
+
+func (ds *Dataset) ListSamplesContext(ctx context.Context, offset,
+	limit int) ([]dbs.Sample, error) {
+	var results []wire.Sample
+	err := ds.get(ctx, "samples", pageQuery(offset, limit),
+		&wire.List{Results: &results})
+	if err != nil {
+		return nil, err
+	}
+	rv := make([]dbs.Sample, 0, len(results))
+	for _, s := range results {
+		rv = append(rv, ds.newSample(s))
+	}
+	return rv, nil
+}
+
+func (ds *Dataset) ListGeneSigs(offset, limit int) ([]dbs.GeneSig, error) {
+	return ds.ListGeneSigsContext(context.Background(), offset, limit)
+}
+
+func (ds *Dataset) ListGeneSigsContext(ctx context.Context, offset,
+	limit int) ([]dbs.GeneSig, error) {
+	var results []wire.GeneSig
+	err := ds.get(ctx, "genesigs", pageQuery(offset, limit),
+		&wire.List{Results: &results})
+	if err != nil {
+		return nil, err
+	}
+	rv := make([]dbs.GeneSig, 0, len(results))
+	for _, s := range results {
+		rv = append(rv, ds.newGeneSig(s))
+	}
+	return rv, nil
+}
+
+func (ds *Dataset) ListGenesets(offset, limit int) ([]dbs.Geneset, error) {
+	return ds.ListGenesetsContext(context.Background(), offset, limit)
+}
+
+func (ds *Dataset) ListGenesetsContext(ctx context.Context, offset,
+	limit int) ([]dbs.Geneset, error) {
+	var results []wire.Geneset
+	err := ds.get(ctx, "genesets", pageQuery(offset, limit),
+		&wire.List{Results: &results})
+	if err != nil {
+		return nil, err
+	}
+	rv := make([]dbs.Geneset, 0, len(results))
+	for _, s := range results {
+		rv = append(rv, ds.newGeneset(s))
+	}
+	return rv, nil
+}
+
+func (ds *Dataset) GetSample(sampleId string) (dbs.Sample, error) {
+	return ds.GetSampleContext(context.Background(), sampleId)
+}
+
+func (ds *Dataset) GetSampleContext(ctx context.Context, sampleId string) (
+	dbs.Sample, error) {
+	var s wire.Sample
+	err := ds.get(ctx, "sample/"+url.PathEscape(sampleId), nil, &s)
+	if err != nil {
+		return nil, err
+	}
+	return ds.newSample(s), nil
+}
+
+func (ds *Dataset) GetGeneSig(geneSigId string) (dbs.GeneSig, error) {
+	return ds.GetGeneSigContext(context.Background(), geneSigId)
+}
+
+func (ds *Dataset) GetGeneSigContext(ctx context.Context, geneSigId string) (
+	dbs.GeneSig, error) {
+	var s wire.GeneSig
+	err := ds.get(ctx, "genesig/"+url.PathEscape(geneSigId), nil, &s)
+	if err != nil {
+		return nil, err
+	}
+	return ds.newGeneSig(s), nil
+}
+
+func (ds *Dataset) GetGeneset(genesetId string) (dbs.Geneset, error) {
+	return ds.GetGenesetContext(context.Background(), genesetId)
+}
+
+func (ds *Dataset) GetGenesetContext(ctx context.Context, genesetId string) (
+	dbs.Geneset, error) {
+	var s wire.Geneset
+	err := ds.get(ctx, "geneset/"+url.PathEscape(genesetId), nil, &s)
+	if err != nil {
+		return nil, err
+	}
+	return ds.newGeneset(s), nil
+}
+
+// nearest fetches a page of nearest search results into results. filters
+// are tag filters for the server to apply.
+func (ds *Dataset) nearest(ctx context.Context, rtype string,
+	opts dbs.SearchOptions, dims []dbs.Dimension, filters []string, offset,
+	limit int, results interface{}) error {
+	return ds.post(ctx, "nearest", wire.NearestRequest{
+		Rtype:      rtype,
+		Dims:       wire.FromDimensions(dims),
+		Filters:    filters,
+		Exact:      opts.Exact,
+		Candidates: opts.Candidates,
+		Offset:     offset,
+		Limit:      limit,
+	}, &wire.List{Results: results})
+}
+
+func (ds *Dataset) NearestSamples(dims []dbs.Dimension, f1 dbs.SampleFilter,
+	f2 dbs.ScoreFilter, offset, limit int) ([]dbs.ScoredSample, error) {
+	return ds.NearestSamplesWithContext(context.Background(),
+		dbs.SearchOptions{}, dims, f1, f2, offset, limit)
+}
+
+func (ds *Dataset) NearestSamplesContext(ctx context.Context,
+	dims []dbs.Dimension, f1 dbs.SampleFilter, f2 dbs.ScoreFilter, offset,
+	limit int) ([]dbs.ScoredSample, error) {
+	return ds.NearestSamplesWithContext(ctx, dbs.SearchOptions{}, dims, f1, f2,
+		offset, limit)
+}
+
+func (ds *Dataset) NearestSamplesWith(opts dbs.SearchOptions,
+	dims []dbs.Dimension, f1 dbs.SampleFilter, f2 dbs.ScoreFilter, offset,
+	limit int) ([]dbs.ScoredSample, error) {
+	return ds.NearestSamplesWithContext(context.Background(), opts, dims, f1,
+		f2, offset, limit)
+}
+
+// NearestSamplesWithContext ranks samples on the server. Filter functions
+// can't be sent to the server, so filtered searches page through an exact
+// ranking and filter it here. NearestSamplesFilteredContext sends tag
+// filters to the server instead.
+func (ds *Dataset) NearestSamplesWithContext(ctx context.Context,
+	opts dbs.SearchOptions, dims []dbs.Dimension, f1 dbs.SampleFilter,
+	f2 dbs.ScoreFilter, offset, limit int) ([]dbs.ScoredSample, error) {
+	return ds.nearestSamples(ctx, opts, dims, nil, f1, f2, offset, limit)
+}
+
+// NearestSamplesFilteredContext is like NearestSamplesWithContext, but
+// filters samples by tag on the server, with filters in the key=value form
+// of a signature search's filters.
+func (ds *Dataset) NearestSamplesFilteredContext(ctx context.Context,
+	opts dbs.SearchOptions, dims []dbs.Dimension, filters []string,
+	f2 dbs.ScoreFilter, offset, limit int) ([]dbs.ScoredSample, error) {
+	return ds.nearestSamples(ctx, opts, dims, filters, nil, f2, offset, limit)
+}
+
+func (ds *Dataset) nearestSamples(ctx context.Context,
+	opts dbs.SearchOptions, dims []dbs.Dimension, filters []string,
+	f1 dbs.SampleFilter, f2 dbs.ScoreFilter, offset, limit int) (
+	[]dbs.ScoredSample, error) {
+	if limit <= 0 {
+		return nil, nil
+	}
+	var page []wire.Sample
+	if f1 == nil && f2 == nil {
+		err := ds.nearest(ctx, "samples", opts, dims, filters, offset, limit,
+			&page)
+		if err != nil {
+			return nil, err
+		}
+		return ds.scoredSamples(page), nil
+	}
+	// approximate searches can answer pages differently, so the pages of a
+	// locally filtered ranking have to be exact.
+	opts.Exact = true
+	var rv []dbs.ScoredSample
+	return rv, filtered(offset, limit,
+		func(offset, limit int) (int, error) {
+			page = nil
+			err := ds.nearest(ctx, "samples", opts, dims, filters, offset, limit,
+				&page)
+			return len(page), err
+		},
+		func(i int) bool {
+			s := ds.newScoredSample(page[i])
+			return (f1 == nil || f1(s)) && (f2 == nil || f2(s.Score()))
+		},
+		func(i int) { rv = append(rv, ds.newScoredSample(page[i])) })
+}
+
+func (ds *Dataset) NearestGeneSigs(dims []dbs.Dimension, f2 dbs.ScoreFilter,
+	offset, limit int) ([]dbs.ScoredGeneSig, error) {
+	return ds.NearestGeneSigsWithContext(context.Background(),
+		dbs.SearchOptions{}, dims, f2, offset, limit)
+}
+
+func (ds *Dataset) NearestGeneSigsContext(ctx context.Context,
+	dims []dbs.Dimension, f2 dbs.ScoreFilter, offset, limit int) (
+	[]dbs.ScoredGeneSig, error) {
+	return ds.NearestGeneSigsWithContext(ctx, dbs.SearchOptions{}, dims, f2,
+		offset, limit)
+}
+
+func (ds *Dataset) NearestGeneSigsWith(opts dbs.SearchOptions,
+	dims []dbs.Dimension, f2 dbs.ScoreFilter, offset, limit int) (
+	[]dbs.ScoredGeneSig, error) {
+	return ds.NearestGeneSigsWithContext(context.Background(), opts, dims, f2,
+		offset, limit)
+}
+
+func (ds *Dataset) NearestGeneSigsWithContext(ctx context.Context,
+	opts dbs.SearchOptions, dims []dbs.Dimension, f2 dbs.ScoreFilter, offset,
+	limit int) ([]dbs.ScoredGeneSig, error) {
+	if limit <= 0 {
+		return nil, nil
+	}
+	var page []wire.GeneSig
+	if f2 == nil {
+		err := ds.nearest(ctx, "genesigs", opts, dims, nil, offset, limit,
+			&page)
+		if err != nil {
+			return nil, err
+		}
+		return ds.scoredGeneSigs(page), nil
+	}
+	opts.Exact = true
+	var rv []dbs.ScoredGeneSig
+	return rv, filtered(offset, limit,
+		func(offset, limit int) (int, error) {
+			page = nil
+			err := ds.nearest(ctx, "genesigs", opts, dims, nil, offset, limit,
+				&page)
+			return len(page), err
+		},
+		func(i int) bool { return f2(ds.newScoredGeneSig(page[i]).Score()) },
+		func(i int) { rv = append(rv, ds.newScoredGeneSig(page[i])) })
+}
+
+func (ds *Dataset) NearestGenesets(dims []dbs.Dimension, f dbs.ScoreFilter,
+	offset, limit int) ([]dbs.ScoredGeneset, error) {
+	return ds.NearestGenesetsContext(context.Background(), dims, f, offset,
+		limit)
+}
+
+func (ds *Dataset) NearestGenesetsContext(ctx context.Context,
+	dims []dbs.Dimension, f dbs.ScoreFilter, offset, limit int) (
+	[]dbs.ScoredGeneset, error) {
+	if limit <= 0 {
+		return nil, nil
+	}
+	var page []wire.Geneset
+	if f == nil {
+		err := ds.nearest(ctx, "genesets", dbs.SearchOptions{}, dims, nil,
+			offset, limit, &page)
+		if err != nil {
+			return nil, err
+		}
+		return ds.scoredGenesets(page), nil
+	}
+	var rv []dbs.ScoredGeneset
+	return rv, filtered(offset, limit,
+		func(offset, limit int) (int, error) {
+			page = nil
+			err := ds.nearest(ctx, "genesets", dbs.SearchOptions{Exact: true},
+				dims, nil, offset, limit, &page)
+			return len(page), err
+		},
+		func(i int) bool { return f(ds.newScoredGeneset(page[i]).Score()) },
+		func(i int) { rv = append(rv, ds.newScoredGeneset(page[i])) })
+}
+
+func batchRequest(rtype string, queries [][]dbs.Dimension,
+	limit int) wire.BatchRequest {
+	req := wire.BatchRequest{
+		Rtype:   rtype,
+		Queries: make([][]wire.Dimension, 0, len(queries)),
+		Limit:   limit,
+	}
+	for _, query := range queries {
+		req.Queries = append(req.Queries, wire.FromDimensions(query))
+	}
+	return req
+}
+
+func (ds *Dataset) NearestSamplesBatch(queries [][]dbs.Dimension, limit int) (
+	[][]dbs.ScoredSample, error) {
+	return ds.NearestSamplesBatchContext(context.Background(), queries, limit)
+}
+
+func (ds *Dataset) NearestSamplesBatchContext(ctx context.Context,
+	queries [][]dbs.Dimension, limit int) ([][]dbs.ScoredSample, error) {
+	var results [][]wire.Sample
+	err := ds.post(ctx, "nearest_batch",
+		batchRequest("samples", queries, limit),
+		&wire.BatchResults{Results: &results})
+	if err != nil {
+		return nil, err
+	}
+	rv := make([][]dbs.ScoredSample, 0, len(results))
+	for _, result := range results {
+		rv = append(rv, ds.scoredSamples(result))
+	}
+	return rv, nil
+}
+
+func (ds *Dataset) NearestGeneSigsBatch(queries [][]dbs.Dimension,
+	limit int) ([][]dbs.ScoredGeneSig, error) {
+	return ds.NearestGeneSigsBatchContext(context.Background(), queries, limit)
+}
+
+func (ds *Dataset) NearestGeneSigsBatchContext(ctx context.Context,
+	queries [][]dbs.Dimension, limit int) ([][]dbs.ScoredGeneSig, error) {
+	var results [][]wire.GeneSig
+	err := ds.post(ctx, "nearest_batch",
+		batchRequest("genesigs", queries, limit),
+		&wire.BatchResults{Results: &results})
+	if err != nil {
+		return nil, err
+	}
+	rv := make([][]dbs.ScoredGeneSig, 0, len(results))
+	for _, result := range results {
+		rv = append(rv, ds.scoredGeneSigs(result))
+	}
+	return rv, nil
+}
+
+func (ds *Dataset) SampleNeighbors(sampleId string, limit int) (
+	similar, opposite []dbs.ScoredSample, err error) {
+	return ds.SampleNeighborsContext(context.Background(), sampleId, limit)
+}
+
+func (ds *Dataset) SampleNeighborsContext(ctx context.Context,
+	sampleId string, limit int) (similar, opposite []dbs.ScoredSample,
+	err error) {
+	var s, o []wire.Sample
+	err = ds.get(ctx, "sample/"+url.PathEscape(sampleId)+"/neighbors",
+		url.Values{"limit": {fmt.Sprint(limit)}},
+		&wire.Neighbors{Similar: &s, Opposite: &o})
+	if err != nil {
+		return nil, nil, err
+	}
+	if len(s) == 0 && len(o) == 0 {
+		return nil, nil, nil
+	}
+	return ds.scoredSamples(s), ds.scoredSamples(o), nil
+}
+
+func (ds *Dataset) GeneSigNeighbors(geneSigId string, limit int) (
+	similar, opposite []dbs.ScoredGeneSig, err error) {
+	return ds.GeneSigNeighborsContext(context.Background(), geneSigId, limit)
+}
+
+func (ds *Dataset) GeneSigNeighborsContext(ctx context.Context,
+	geneSigId string, limit int) (similar, opposite []dbs.ScoredGeneSig,
+	err error) {
+	var s, o []wire.GeneSig
+	err = ds.get(ctx, "genesig/"+url.PathEscape(geneSigId)+"/neighbors",
+		url.Values{"limit": {fmt.Sprint(limit)}},
+		&wire.Neighbors{Similar: &s, Opposite: &o})
+	if err != nil {
+		return nil, nil, err
+	}
+	if len(s) == 0 && len(o) == 0 {
+		return nil, nil, nil
+	}
+	return ds.scoredGeneSigs(s), ds.scoredGeneSigs(o), nil
+}
+
+func (ds *Dataset) CombineGenes(genes []dbs.Gene) ([]dbs.Dimension, error) {
+	return ds.CombineGenesContext(context.Background(), genes)
+}
+
+func (ds *Dataset) CombineGenesContext(ctx context.Context,
+	genes []dbs.Gene) ([]dbs.Dimension, error) {
+	var dims []wire.Dimension
+	err := ds.post(ctx, "combine",
+		wire.CombineRequest{Genes: wire.FromGenes(genes)}, &dims)
+	if err != nil {
+		return nil, err
+	}
+	return wire.ToDimensions(dims), nil
+}
+
+// keyword fetches a page of unfiltered keyword search results into results.
+func (ds *Dataset) keyword(ctx context.Context, rtype, keyword string,
+	offset, limit int, results interface{}) error {
+	query := pageQuery(offset, limit)
+	query.Set("rtype", rtype)
+	query.Set("keyword", keyword)
+	return ds.get(ctx, "search/keyword", query, &wire.List{Results: results})
+}
+
+func (ds *Dataset) SearchSamples(keyword string, filter dbs.SampleFilter,
+	offset, limit int) ([]dbs.ScoredSample, error) {
+	return ds.SearchSamplesContext(context.Background(), keyword, filter,
+		offset, limit)
+}
+
+func (ds *Dataset) SearchSamplesContext(ctx context.Context, keyword string,
+	filter dbs.SampleFilter, offset, limit int) ([]dbs.ScoredSample, error) {
+	if limit <= 0 {
+		return nil, nil
+	}
+	var page []wire.Sample
+	if filter == nil {
+		err := ds.keyword(ctx, "samples", keyword, offset, limit, &page)
+		if err != nil {
+			return nil, err
+		}
+		return ds.scoredSamples(page), nil
+	}
+	var rv []dbs.ScoredSample
+	return rv, filtered(offset, limit,
+		func(offset, limit int) (int, error) {
+			page = nil
+			err := ds.keyword(ctx, "samples", keyword, offset, limit, &page)
+			return len(page), err
+		},
+		func(i int) bool { return filter(ds.newScoredSample(page[i])) },
+		func(i int) { rv = append(rv, ds.newScoredSample(page[i])) })
+}
+
+func (ds *Dataset) SearchGeneSigs(keyword string, offset, limit int) (
+	[]dbs.ScoredGeneSig, error) {
+	return ds.SearchGeneSigsContext(context.Background(), keyword, offset,
+		limit)
+}
+
+func (ds *Dataset) SearchGeneSigsContext(ctx context.Context, keyword string,
+	offset, limit int) ([]dbs.ScoredGeneSig, error) {
+	var page []wire.GeneSig
+	err := ds.keyword(ctx, "genesigs", keyword, offset, limit, &page)
+	if err != nil {
+		return nil, err
+	}
+	return ds.scoredGeneSigs(page), nil
+}
+
+func (ds *Dataset) SearchGenesets(keyword string, offset, limit int) (
+	[]dbs.ScoredGeneset, error) {
+	return ds.SearchGenesetsContext(context.Background(), keyword, offset,
+		limit)
+}
+
+func (ds *Dataset) SearchGenesetsContext(ctx context.Context, keyword string,
+	offset, limit int) ([]dbs.ScoredGeneset, error) {
+	var page []wire.Geneset
+	err := ds.keyword(ctx, "genesets", keyword, offset, limit, &page)
+	if err != nil {
+		return nil, err
+	}
+	return ds.scoredGenesets(page), nil
+}
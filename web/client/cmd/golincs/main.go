@@ -0,0 +1,323 @@
+// Copyright (C) 2017 JT Olds
+// See LICENSE for copying information
+
+package main
+
+import (
+	"bufio"
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/jtolds/golincs/web/client"
+	"github.com/jtolds/golincs/web/dbs"
+	"github.com/jtolds/golincs/web/wire"
+)
+
+var (
+	server = flag.String("server", "http://localhost:8080",
+		"golincs web server to query")
+	datasetId = flag.Int("dataset", 0, "dataset id on the server")
+	up        = flag.String("up", "",
+		"up-regulated genes to search for, separated by spaces or commas")
+	down = flag.String("down", "",
+		"down-regulated genes to search for, separated by spaces or commas")
+	queriesPath = flag.String("queries", "",
+		"a tab-separated file of queries, one per line, with a query name, "+
+			"space-separated up-regulated genes, and space-separated "+
+			"down-regulated genes. - reads from stdin")
+	qtype = flag.String("qtype", "",
+		"if set, search with the signature of an existing entry instead. can "+
+			"be 'sample', 'genesig', or 'geneset'. requires -id")
+	entryId = flag.String("id", "", "entry id for -qtype")
+	rtype   = flag.String("rtype", "samples",
+		"what to search. can be 'samples', 'genesigs', or 'genesets'")
+	k      = flag.Int("k", 10, "how many results to write per query")
+	offset = flag.Int("offset", 0, "how many of the best results to skip")
+	exact  = flag.Bool("exact", false,
+		"score every entry instead of using approximate indexes")
+	candidates = flag.Int("candidates", 0,
+		"candidates approximate indexes consider. 0 uses the server's "+
+			"default. if set, queries aren't batched")
+	batchSize = flag.Int("batch", 64,
+		"how many queries to send the server at once, when there are many "+
+			"without -filters or -offset. batched queries are always scored "+
+			"exactly")
+	filters = flag.String("filters", "",
+		"space-separated key=value sample tag filters. values match "+
+			"case-insensitively and samples without the tag pass")
+	timeout = flag.Duration("timeout", 0,
+		"give up after this long. 0 waits forever")
+)
+
+// golincs runs signature searches against a golincs web server and writes
+// the results as tsv, with a line per result.
+func main() {
+	flag.Parse()
+
+	ds, err := client.Open(*server, *datasetId)
+	if err != nil {
+		panic(err)
+	}
+
+	ctx := context.Background()
+	if *timeout > 0 {
+		var cancel func()
+		ctx, cancel = context.WithTimeout(ctx, *timeout)
+		defer cancel()
+	}
+
+	names, queries, err := readQueries(ctx, ds)
+	if err != nil {
+		panic(err)
+	}
+	tag_filters, err := dbs.ParseTagFilters(*filters)
+	if err != nil {
+		panic(err)
+	}
+	if len(tag_filters) > 0 && *rtype != "samples" {
+		panic("-filters only apply to samples")
+	}
+
+	w := bufio.NewWriter(os.Stdout)
+	header := []string{"query", "rank", "id", "name", "score"}
+	if *rtype == "samples" {
+		header = append(header, ds.SampleTagNames()...)
+	}
+	_, err = fmt.Fprintln(w, strings.Join(header, "\t"))
+	if err != nil {
+		panic(err)
+	}
+
+	err = search(ctx, ds, names, queries, tag_filters, w)
+	if err != nil {
+		panic(err)
+	}
+	err = w.Flush()
+	if err != nil {
+		panic(err)
+	}
+}
+
+func splitGenes(genes string) []string {
+	return strings.FieldsFunc(genes, func(r rune) bool {
+		return r == ',' || r == ' ' || r == '\t'
+	})
+}
+
+// readQueries reads the queries given by -up and -down, -queries, or -qtype.
+func readQueries(ctx context.Context, ds dbs.Dataset) (names []string,
+	queries [][]dbs.Dimension, err error) {
+	sources := 0
+	for _, given := range []bool{*up != "" || *down != "", *queriesPath != "",
+		*qtype != ""} {
+		if given {
+			sources++
+		}
+	}
+	if sources != 1 {
+		return nil, nil, fmt.Errorf(
+			"exactly one of -up/-down, -queries, or -qtype is required")
+	}
+
+	switch {
+	case *queriesPath != "":
+		return readQueryLines(ds, *queriesPath)
+	case *qtype != "":
+		if *entryId == "" {
+			return nil, nil, fmt.Errorf("-id is required with -qtype")
+		}
+		var dims []dbs.Dimension
+		switch *qtype {
+		default:
+			return nil, nil, fmt.Errorf("invalid qtype %q", *qtype)
+		case "sample":
+			sample, err := ds.GetSampleContext(ctx, *entryId)
+			if err != nil {
+				return nil, nil, err
+			}
+			dims, err = sample.Data()
+			if err != nil {
+				return nil, nil, err
+			}
+		case "genesig":
+			genesig, err := ds.GetGeneSigContext(ctx, *entryId)
+			if err != nil {
+				return nil, nil, err
+			}
+			dims, err = genesig.Data()
+			if err != nil {
+				return nil, nil, err
+			}
+		case "geneset":
+			geneset, err := ds.GetGenesetContext(ctx, *entryId)
+			if err != nil {
+				return nil, nil, err
+			}
+			dims, err = geneset.Query()
+			if err != nil {
+				return nil, nil, err
+			}
+		}
+		return []string{*entryId}, [][]dbs.Dimension{dims}, nil
+	default:
+		dims, err := dbs.UpDownQuery(ds, splitGenes(*up), splitGenes(*down))
+		if err != nil {
+			return nil, nil, err
+		}
+		return []string{"query"}, [][]dbs.Dimension{dims}, nil
+	}
+}
+
+// readQueryLines reads queries in the format described by -queries.
+func readQueryLines(ds dbs.Dataset, path string) (names []string,
+	queries [][]dbs.Dimension, err error) {
+	if path == "-" {
+		return dbs.ParseQueryLines(ds, os.Stdin, "stdin")
+	}
+	fh, err := os.Open(path)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer fh.Close()
+	return dbs.ParseQueryLines(ds, fh, path)
+}
+
+// batchChunk returns how many queries to send the server per batch, within
+// what it accepts.
+func batchChunk() int {
+	chunk := *batchSize
+	if chunk > wire.MaxBatchQueries {
+		chunk = wire.MaxBatchQueries
+	}
+	if *k > 0 && wire.MaxBatchResults / *k < chunk {
+		chunk = wire.MaxBatchResults / *k
+	}
+	if chunk < 1 {
+		chunk = 1
+	}
+	return chunk
+}
+
+// search runs every query and writes the results to w. Many unfiltered
+// queries from the start of the ranking are sent to the server in batches,
+// which are scored exactly, unless -candidates asks for approximate
+// indexes.
+func search(ctx context.Context, ds *client.Dataset, names []string,
+	queries [][]dbs.Dimension, filters []string, w io.Writer) error {
+	opts := dbs.SearchOptions{Exact: *exact, Candidates: *candidates}
+	batch := len(queries) > 1 && len(filters) == 0 && *offset == 0 &&
+		*candidates == 0
+	chunk := 1
+	if batch {
+		chunk = batchChunk()
+	}
+
+	tags := func(s dbs.Sample) []string {
+		rv := make([]string, 0, len(ds.SampleTagNames()))
+		for _, name := range ds.SampleTagNames() {
+			rv = append(rv, s.Tags()[name])
+		}
+		return rv
+	}
+	write := func(q, rank int, id, name string, score float64,
+		extra []string) error {
+		fields := append([]string{names[q], fmt.Sprint(*offset + rank + 1), id,
+			name, fmt.Sprintf("%0.6f", score)}, extra...)
+		_, err := fmt.Fprintln(w, strings.Join(fields, "\t"))
+		return err
+	}
+
+	switch *rtype {
+	default:
+		return fmt.Errorf("invalid rtype %q", *rtype)
+
+	case "samples":
+		for start := 0; start < len(queries); start += chunk {
+			end := start + chunk
+			if end > len(queries) {
+				end = len(queries)
+			}
+			var results [][]dbs.ScoredSample
+			if batch {
+				var err error
+				results, err = ds.NearestSamplesBatchContext(ctx,
+					queries[start:end], *k)
+				if err != nil {
+					return err
+				}
+			} else {
+				for _, query := range queries[start:end] {
+					hits, err := ds.NearestSamplesFilteredContext(ctx, opts, query,
+						filters, nil, *offset, *k)
+					if err != nil {
+						return err
+					}
+					results = append(results, hits)
+				}
+			}
+			for i, hits := range results {
+				for rank, hit := range hits {
+					err := write(start+i, rank, hit.Id(), hit.Name(), hit.Score(),
+						tags(hit))
+					if err != nil {
+						return err
+					}
+				}
+			}
+		}
+
+	case "genesigs":
+		for start := 0; start < len(queries); start += chunk {
+			end := start + chunk
+			if end > len(queries) {
+				end = len(queries)
+			}
+			var results [][]dbs.ScoredGeneSig
+			if batch {
+				var err error
+				results, err = ds.NearestGeneSigsBatchContext(ctx,
+					queries[start:end], *k)
+				if err != nil {
+					return err
+				}
+			} else {
+				for _, query := range queries[start:end] {
+					hits, err := ds.NearestGeneSigsWithContext(ctx, opts, query,
+						nil, *offset, *k)
+					if err != nil {
+						return err
+					}
+					results = append(results, hits)
+				}
+			}
+			for i, hits := range results {
+				for rank, hit := range hits {
+					err := write(start+i, rank, hit.Id(), hit.Name(), hit.Score(),
+						nil)
+					if err != nil {
+						return err
+					}
+				}
+			}
+		}
+
+	case "genesets":
+		for q, query := range queries {
+			hits, err := ds.NearestGenesetsContext(ctx, query, nil, *offset, *k)
+			if err != nil {
+				return err
+			}
+			for rank, hit := range hits {
+				err = write(q, rank, hit.Id(), hit.Name(), hit.Score(), nil)
+				if err != nil {
+					return err
+				}
+			}
+		}
+	}
+	return nil
+}
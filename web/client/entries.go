@@ -0,0 +1,137 @@
+// Copyright (C) 2017 JT Olds
+// See LICENSE for copying information
+
+package client
+
+import (
+	"context"
+	"net/url"
+
+	"github.com/jtolds/golincs/web/dbs"
+	"github.com/jtolds/golincs/web/wire"
+)
+
+// Entries only hold what list and search results include. Signature data
+// is fetched from the server when it's asked for.
+
+type sample struct {
+	ds   *Dataset
+	info wire.Sample
+}
+
+func (s *sample) Id() string              { return s.info.Id }
+func (s *sample) Name() string            { return s.info.Name }
+func (s *sample) Tags() map[string]string { return s.info.Tags }
+func (s *sample) Data() ([]dbs.Dimension, error) {
+	return s.ds.dims("sample/" + url.PathEscape(s.info.Id) + "/data")
+}
+
+type geneSig struct {
+	ds   *Dataset
+	info wire.GeneSig
+}
+
+func (s *geneSig) Id() string   { return s.info.Id }
+func (s *geneSig) Name() string { return s.info.Name }
+func (s *geneSig) Data() ([]dbs.Dimension, error) {
+	return s.ds.dims("genesig/" + url.PathEscape(s.info.Id) + "/data")
+}
+
+type geneset struct {
+	ds   *Dataset
+	info wire.Geneset
+}
+
+func (s *geneset) Id() string          { return s.info.Id }
+func (s *geneset) Name() string        { return s.info.Name }
+func (s *geneset) Description() string { return s.info.Description }
+func (s *geneset) Genes() []string     { return s.info.Genes }
+func (s *geneset) Query() ([]dbs.Dimension, error) {
+	return s.ds.dims("geneset/" + url.PathEscape(s.info.Id) + "/query")
+}
+
+func (ds *Dataset) dims(path string) ([]dbs.Dimension, error) {
+	var dims []wire.Dimension
+	err := ds.get(context.Background(), path, nil, &dims)
+	if err != nil {
+		return nil, err
+	}
+	return wire.ToDimensions(dims), nil
+}
+
+func (ds *Dataset) newSample(s wire.Sample) *sample {
+	return &sample{ds: ds, info: s}
+}
+
+func (ds *Dataset) newGeneSig(s wire.GeneSig) *geneSig {
+	return &geneSig{ds: ds, info: s}
+}
+
+func (ds *Dataset) newGeneset(s wire.Geneset) *geneset {
+	return &geneset{ds: ds, info: s}
+}
+
+// score returns a search result's score, which the server always sends.
+func score(val *float64) float64 {
+	if val == nil {
+		return 0
+	}
+	return *val
+}
+
+type scoredSample struct {
+	*sample
+	score float64
+}
+
+func (s scoredSample) Score() float64 { return s.score }
+
+type scoredGeneSig struct {
+	*geneSig
+	score float64
+}
+
+func (s scoredGeneSig) Score() float64 { return s.score }
+
+type scoredGeneset struct {
+	*geneset
+	score float64
+}
+
+func (s scoredGeneset) Score() float64 { return s.score }
+
+func (ds *Dataset) newScoredSample(s wire.Sample) scoredSample {
+	return scoredSample{sample: ds.newSample(s), score: score(s.Score)}
+}
+
+func (ds *Dataset) newScoredGeneSig(s wire.GeneSig) scoredGeneSig {
+	return scoredGeneSig{geneSig: ds.newGeneSig(s), score: score(s.Score)}
+}
+
+func (ds *Dataset) newScoredGeneset(s wire.Geneset) scoredGeneset {
+	return scoredGeneset{geneset: ds.newGeneset(s), score: score(s.Score)}
+}
+
+func (ds *Dataset) scoredSamples(l []wire.Sample) []dbs.ScoredSample {
+	rv := make([]dbs.ScoredSample, 0, len(l))
+	for _, s := range l {
+		rv = append(rv, ds.newScoredSample(s))
+	}
+	return rv
+}
+
+func (ds *Dataset) scoredGeneSigs(l []wire.GeneSig) []dbs.ScoredGeneSig {
+	rv := make([]dbs.ScoredGeneSig, 0, len(l))
+	for _, s := range l {
+		rv = append(rv, ds.newScoredGeneSig(s))
+	}
+	return rv
+}
+
+func (ds *Dataset) scoredGenesets(l []wire.Geneset) []dbs.ScoredGeneset {
+	rv := make([]dbs.ScoredGeneset, 0, len(l))
+	for _, s := range l {
+		rv = append(rv, ds.newScoredGeneset(s))
+	}
+	return rv
+}
@@ -4,6 +4,8 @@
 package dbs
 
 import (
+	"context"
+
 	"github.com/spacemonkeygo/errors"
 	"github.com/spacemonkeygo/errors/errhttp"
 )
@@ -92,6 +94,18 @@ func CombineScoreFilters(filters ...ScoreFilter) ScoreFilter {
 	}
 }
 
+// SearchOptions tune nearest neighbor searches for datasets with approximate
+// search indexes. The zero value uses the dataset's defaults.
+type SearchOptions struct {
+	// Exact disables approximate indexes and scores every entry.
+	Exact bool
+	// Candidates is how many candidates an approximate index considers, such
+	// as the number of entries re-ranked with exact scores or the size of a
+	// graph search's candidate list. Higher values improve recall at the cost
+	// of speed. If <= 0, the dataset's default is used.
+	Candidates int
+}
+
 type Dataset interface {
 	Name() string
 	Dimensions() int
@@ -117,10 +131,80 @@ type Dataset interface {
 	NearestGenesets(dims []Dimension, f ScoreFilter, offset, limit int) (
 		[]ScoredGeneset, error)
 
+	// NearestSamplesWith and NearestGeneSigsWith are like NearestSamples and
+	// NearestGeneSigs, but with control over approximate indexes.
+	NearestSamplesWith(opts SearchOptions, dims []Dimension, f1 SampleFilter,
+		f2 ScoreFilter, offset, limit int) ([]ScoredSample, error)
+	NearestGeneSigsWith(opts SearchOptions, dims []Dimension, f2 ScoreFilter,
+		offset, limit int) ([]ScoredGeneSig, error)
+
+	// NearestSamplesBatch and NearestGeneSigsBatch score many queries at
+	// once, returning the exact top limit results for every query, in the
+	// same order as queries.
+	NearestSamplesBatch(queries [][]Dimension, limit int) (
+		[][]ScoredSample, error)
+	NearestGeneSigsBatch(queries [][]Dimension, limit int) (
+		[][]ScoredGeneSig, error)
+
+	// SampleNeighbors and GeneSigNeighbors return up to limit precomputed
+	// most similar and most opposite entries for the given id. If neighbors
+	// haven't been precomputed, they return nil lists and no error.
+	SampleNeighbors(sampleId string, limit int) (
+		similar, opposite []ScoredSample, err error)
+	GeneSigNeighbors(geneSigId string, limit int) (
+		similar, opposite []ScoredGeneSig, err error)
+
 	CombineGenes(genes []Gene) ([]Dimension, error)
 
 	SearchSamples(keyword string, filter SampleFilter, offset, limit int) (
 		[]ScoredSample, error)
 	SearchGeneSigs(keyword string, offset, limit int) ([]ScoredGeneSig, error)
 	SearchGenesets(keyword string, offset, limit int) ([]ScoredGeneset, error)
+
+	// The Context variants of the query methods above stop early and return
+	// an error if ctx is canceled or its deadline passes. The variants without
+	// a context behave like these with context.Background().
+	ListSamplesContext(ctx context.Context, offset, limit int) ([]Sample,
+		error)
+	ListGeneSigsContext(ctx context.Context, offset, limit int) ([]GeneSig,
+		error)
+	ListGenesetsContext(ctx context.Context, offset, limit int) ([]Geneset,
+		error)
+
+	GetSampleContext(ctx context.Context, sampleId string) (Sample, error)
+	GetGeneSigContext(ctx context.Context, geneSigId string) (GeneSig, error)
+	GetGenesetContext(ctx context.Context, genesetId string) (Geneset, error)
+
+	NearestSamplesContext(ctx context.Context, dims []Dimension,
+		f1 SampleFilter, f2 ScoreFilter, offset, limit int) ([]ScoredSample,
+		error)
+	NearestGeneSigsContext(ctx context.Context, dims []Dimension,
+		f2 ScoreFilter, offset, limit int) ([]ScoredGeneSig, error)
+	NearestGenesetsContext(ctx context.Context, dims []Dimension,
+		f ScoreFilter, offset, limit int) ([]ScoredGeneset, error)
+	NearestSamplesWithContext(ctx context.Context, opts SearchOptions,
+		dims []Dimension, f1 SampleFilter, f2 ScoreFilter, offset, limit int) (
+		[]ScoredSample, error)
+	NearestGeneSigsWithContext(ctx context.Context, opts SearchOptions,
+		dims []Dimension, f2 ScoreFilter, offset, limit int) (
+		[]ScoredGeneSig, error)
+	NearestSamplesBatchContext(ctx context.Context, queries [][]Dimension,
+		limit int) ([][]ScoredSample, error)
+	NearestGeneSigsBatchContext(ctx context.Context, queries [][]Dimension,
+		limit int) ([][]ScoredGeneSig, error)
+
+	SampleNeighborsContext(ctx context.Context, sampleId string, limit int) (
+		similar, opposite []ScoredSample, err error)
+	GeneSigNeighborsContext(ctx context.Context, geneSigId string,
+		limit int) (similar, opposite []ScoredGeneSig, err error)
+
+	CombineGenesContext(ctx context.Context, genes []Gene) ([]Dimension,
+		error)
+
+	SearchSamplesContext(ctx context.Context, keyword string,
+		filter SampleFilter, offset, limit int) ([]ScoredSample, error)
+	SearchGeneSigsContext(ctx context.Context, keyword string,
+		offset, limit int) ([]ScoredGeneSig, error)
+	SearchGenesetsContext(ctx context.Context, keyword string,
+		offset, limit int) ([]ScoredGeneset, error)
 }
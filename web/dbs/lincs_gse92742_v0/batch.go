@@ -0,0 +1,209 @@
+// Copyright (C) 2017 JT Olds
+// See LICENSE for copying information
+
+package lincs_gse92742_v0
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"os"
+
+	"github.com/jtolds/golincs/mmm"
+	"github.com/jtolds/golincs/web/dbs"
+)
+
+func (ds *Dataset) queryVectors(queries [][]dbs.Dimension) [][]float32 {
+	rv := make([][]float32, 0, len(queries))
+	for _, dims := range queries {
+		rv = append(rv, ds.queryVector(dims))
+	}
+	return rv
+}
+
+func (ds *Dataset) nearestBatch(ctx context.Context, mh *mmm.Handle,
+	queries [][]dbs.Dimension, limit int, tags bool) (
+	[][]scoredSample, error) {
+	if limit <= 0 || len(queries) == 0 {
+		return make([][]scoredSample, len(queries)), nil
+	}
+	hits, err := mmm.TopQueries(ctx, ds.queryVectors(queries), mh,
+		mmm.ScoreOptions{
+			Metric:  mmm.Cosine,
+			TopK:    limit,
+			Workers: *scanWorkers,
+		})
+	if err != nil {
+		return nil, err
+	}
+
+	// load every hit's metadata at once, since queries tend to share hits.
+	var idxs []int
+	loaded := map[int]*sample{}
+	for _, neighbors := range hits {
+		for _, n := range neighbors {
+			idx, _ := mh.RowIdxById(n.Id)
+			if _, exists := loaded[idx]; !exists {
+				loaded[idx] = nil
+				idxs = append(idxs, idx)
+			}
+		}
+	}
+	samples, err := ds.byIdxs(ctx, mh, idxs, tags)
+	if err != nil {
+		return nil, err
+	}
+	for i, idx := range idxs {
+		loaded[idx] = samples[i]
+	}
+
+	rv := make([][]scoredSample, 0, len(hits))
+	for _, neighbors := range hits {
+		results := make([]scoredSample, 0, len(neighbors))
+		for _, n := range neighbors {
+			idx, _ := mh.RowIdxById(n.Id)
+			results = append(results, scoredSample{
+				idx:    idx,
+				score:  float64(n.Score),
+				Sample: loaded[idx]})
+		}
+		rv = append(rv, results)
+	}
+	return rv, nil
+}
+
+func (ds *Dataset) NearestSamplesBatch(queries [][]dbs.Dimension,
+	limit int) ([][]dbs.ScoredSample, error) {
+	return ds.NearestSamplesBatchContext(context.Background(), queries, limit)
+}
+
+func (ds *Dataset) NearestSamplesBatchContext(ctx context.Context,
+	queries [][]dbs.Dimension, limit int) ([][]dbs.ScoredSample, error) {
+	results, err := ds.nearestBatch(ctx, ds.samples, queries, limit, true)
+	if err != nil {
+		return nil, err
+	}
+	rv := make([][]dbs.ScoredSample, 0, len(results))
+	for _, r := range results {
+		rv = append(rv, scoredSamplesToScoredSamples(r))
+	}
+	return rv, nil
+}
+
+func (ds *Dataset) NearestGeneSigsBatch(queries [][]dbs.Dimension,
+	limit int) ([][]dbs.ScoredGeneSig, error) {
+	return ds.NearestGeneSigsBatchContext(context.Background(), queries, limit)
+}
+
+func (ds *Dataset) NearestGeneSigsBatchContext(ctx context.Context,
+	queries [][]dbs.Dimension, limit int) ([][]dbs.ScoredGeneSig, error) {
+	results, err := ds.nearestBatch(ctx, ds.genesigs, queries, limit, false)
+	if err != nil {
+		return nil, err
+	}
+	rv := make([][]dbs.ScoredGeneSig, 0, len(results))
+	for _, r := range results {
+		rv = append(rv, scoredSamplesToScoredGeneSigs(r))
+	}
+	return rv, nil
+}
+
+// WriteQueryScores scores every query against every sample, or every gene
+// signature if genesigs is true, and writes the full score matrix to an mmm
+// file at dst_path. The output has a row per query, with ids from ids, and a
+// column per sample or gene signature, with their ids.
+func (ds *Dataset) WriteQueryScores(dst_path string, ids []mmm.Ident,
+	queries [][]dbs.Dimension, genesigs bool) error {
+	return ds.WriteQueryScoresContext(context.Background(), dst_path, ids,
+		queries, genesigs)
+}
+
+// WriteQueryScoresContext is like WriteQueryScores, but stops between blocks
+// of rows, removing the partial output, if ctx is canceled.
+func (ds *Dataset) WriteQueryScoresContext(ctx context.Context,
+	dst_path string, ids []mmm.Ident, queries [][]dbs.Dimension,
+	genesigs bool) (err error) {
+	if len(ids) != len(queries) {
+		return fmt.Errorf("expected an id for every query")
+	}
+	mh := ds.samples
+	if genesigs {
+		mh = ds.genesigs
+	}
+
+	dst, err := mmm.Create(dst_path, int64(len(queries)), int64(mh.Rows()))
+	if err != nil {
+		return err
+	}
+	defer func() {
+		dst.Close()
+		if err != nil {
+			os.Remove(dst_path)
+		}
+	}()
+	copy(dst.RowIds(), ids)
+	copy(dst.ColIds(), mh.RowIds())
+
+	opts := mmm.ScoreOptions{Metric: mmm.Cosine, Workers: *scanWorkers}
+	err = mmm.ScoreQueries(
+		mmm.PrepareQueries(ds.queryVectors(queries), opts.Metric, opts.Workers),
+		mh, opts, func(start, end int, scores []float32) error {
+			err := ctx.Err()
+			if err != nil {
+				return err
+			}
+			count := end - start
+			for q := range queries {
+				copy(dst.RowByIdx(q)[start:end], scores[q*count:(q+1)*count])
+			}
+			return nil
+		})
+	if err != nil {
+		return err
+	}
+	return dst.Close()
+}
+
+// ReadQueries reads queries from the rows of an mmm file at path. Columns are
+// matched to the dataset's dimensions by id, so they may be in any order, and
+// columns the dataset doesn't have are ignored, as are NaN values.
+func (ds *Dataset) ReadQueries(path string) (ids []mmm.Ident,
+	queries [][]dbs.Dimension, err error) {
+	h, err := mmm.Open(path)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer h.Close()
+
+	var cols []int
+	var names []string
+	for idx, id := range h.ColIds() {
+		dim_idx, found := ds.samples.ColIdxById(id)
+		if !found || ds.dimensionMap[dim_idx] == "" {
+			continue
+		}
+		cols = append(cols, idx)
+		names = append(names, ds.dimensionMap[dim_idx])
+	}
+	if len(cols) == 0 {
+		return nil, nil, fmt.Errorf("%q has no columns in common with the "+
+			"dataset", path)
+	}
+
+	ids = make([]mmm.Ident, 0, h.Rows())
+	queries = make([][]dbs.Dimension, 0, h.Rows())
+	for i := 0; i < h.Rows(); i++ {
+		row := h.RowByIdx(i)
+		dims := make([]dbs.Dimension, 0, len(cols))
+		for j, col := range cols {
+			if math.IsNaN(float64(row[col])) {
+				continue
+			}
+			dims = append(dims, dbs.Dimension{
+				Name: names[j], Value: float64(row[col])})
+		}
+		ids = append(ids, h.RowIdByIdx(i))
+		queries = append(queries, dims)
+	}
+	return ids, queries, nil
+}
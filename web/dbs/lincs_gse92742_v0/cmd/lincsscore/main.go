@@ -0,0 +1,164 @@
+// Copyright (C) 2017 JT Olds
+// See LICENSE for copying information
+
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/jtolds/golincs/mmm"
+	"github.com/jtolds/golincs/web/dbs"
+	"github.com/jtolds/golincs/web/dbs/lincs_gse92742_v0"
+)
+
+var (
+	queriesPath = flag.String("queries", "",
+		"a tab-separated file of queries, one per line, with a query name, "+
+			"space-separated up-regulated genes, and space-separated "+
+			"down-regulated genes")
+	queriesMMMPath = flag.String("queries_mmm", "",
+		"an mmm file of queries, one per row, with dimension ids as column ids")
+	rtype = flag.String("rtype", "samples",
+		"what to score queries against. can be 'samples' or 'genesigs'")
+	topK = flag.Int("k", 10,
+		"how many of the best results to write per query as tsv. if 0, the "+
+			"full query by signature score matrix is written to -o as an mmm "+
+			"file instead, with a row per query. rows of -queries are "+
+			"numbered from 0")
+	outputPath = flag.String("o", "",
+		"output path. tsv output defaults to stdout")
+)
+
+// readQueries reads the queries given by -queries or -queries_mmm, and
+// returns a name and an id for each.
+func readQueries(ds *lincs_gse92742_v0.Dataset) (names []string,
+	ids []mmm.Ident, queries [][]dbs.Dimension, err error) {
+	switch {
+	case *queriesPath != "" && *queriesMMMPath != "":
+		return nil, nil, nil, fmt.Errorf(
+			"only one of -queries and -queries_mmm can be given")
+	case *queriesPath != "":
+		fh, err := os.Open(*queriesPath)
+		if err != nil {
+			return nil, nil, nil, err
+		}
+		defer fh.Close()
+		names, queries, err = dbs.ParseQueryLines(ds, fh, *queriesPath)
+		if err != nil {
+			return nil, nil, nil, err
+		}
+		for i := range queries {
+			ids = append(ids, mmm.Ident(i))
+		}
+	case *queriesMMMPath != "":
+		ids, queries, err = ds.ReadQueries(*queriesMMMPath)
+		if err != nil {
+			return nil, nil, nil, err
+		}
+		for _, id := range ids {
+			names = append(names, fmt.Sprint(id))
+		}
+	default:
+		return nil, nil, nil, fmt.Errorf("-queries or -queries_mmm required")
+	}
+	return names, ids, queries, nil
+}
+
+// writeTopK writes the -k best results for every query as tsv to w.
+func writeTopK(ds *lincs_gse92742_v0.Dataset, w io.Writer, names []string,
+	queries [][]dbs.Dimension) error {
+	_, err := io.WriteString(w, dbs.ScoreHeader)
+	if err != nil {
+		return err
+	}
+	if *rtype == "genesigs" {
+		results, err := ds.NearestGeneSigsBatch(queries, *topK)
+		if err != nil {
+			return err
+		}
+		for q, hits := range results {
+			for rank, hit := range hits {
+				err = dbs.WriteScore(w, names[q], rank, hit.Id(), hit.Name(),
+					hit.Score())
+				if err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	}
+	results, err := ds.NearestSamplesBatch(queries, *topK)
+	if err != nil {
+		return err
+	}
+	for q, hits := range results {
+		for rank, hit := range hits {
+			err = dbs.WriteScore(w, names[q], rank, hit.Id(), hit.Name(),
+				hit.Score())
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// lincsscore scores many queries against the samples or gene signatures of
+// the dataset at once, writing either each query's best results or the full
+// score matrix.
+func main() {
+	flag.Parse()
+
+	if *rtype != "samples" && *rtype != "genesigs" {
+		panic(fmt.Sprintf("invalid rtype %q", *rtype))
+	}
+	if *topK <= 0 && *outputPath == "" {
+		panic("output path (-o) required")
+	}
+
+	ds, err := lincs_gse92742_v0.New()
+	if err != nil {
+		panic(err)
+	}
+	defer ds.Close()
+
+	names, ids, queries, err := readQueries(ds)
+	if err != nil {
+		panic(err)
+	}
+
+	if *topK <= 0 {
+		err = ds.WriteQueryScores(*outputPath, ids, queries,
+			*rtype == "genesigs")
+		if err != nil {
+			panic(err)
+		}
+		return
+	}
+
+	out := os.Stdout
+	if *outputPath != "" {
+		out, err = os.Create(*outputPath)
+		if err != nil {
+			panic(err)
+		}
+		defer out.Close()
+	}
+	w := bufio.NewWriter(out)
+	err = writeTopK(ds, w, names, queries)
+	if err != nil {
+		panic(err)
+	}
+	err = w.Flush()
+	if err != nil {
+		panic(err)
+	}
+	err = out.Close()
+	if err != nil {
+		panic(err)
+	}
+}
@@ -0,0 +1,52 @@
+// Copyright (C) 2017 JT Olds
+// See LICENSE for copying information
+
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/jtolds/golincs/web/dbs/lincs_gse92742_v0"
+)
+
+var (
+	query = flag.String("query", "",
+		"signature metadata query, e.g. "+
+			"'cell_id = MCF7 AND pert_type = trt_cp'")
+)
+
+// sigids resolves a signature metadata query against the database given by
+// -gse92742.db_path, and writes the matching mmm row ids one per line, ready
+// for mmmfilter's -rows_path with -row_keep.
+func main() {
+	flag.Parse()
+
+	if *query == "" {
+		panic("query (-query) required")
+	}
+
+	db, err := lincs_gse92742_v0.OpenDB()
+	if err != nil {
+		panic(err)
+	}
+	defer db.Close()
+	ids, err := lincs_gse92742_v0.SelectIds(db, *query)
+	if err != nil {
+		panic(err)
+	}
+
+	w := bufio.NewWriter(os.Stdout)
+	for _, id := range ids {
+		_, err = fmt.Fprintln(w, id)
+		if err != nil {
+			panic(err)
+		}
+	}
+	err = w.Flush()
+	if err != nil {
+		panic(err)
+	}
+}
@@ -4,6 +4,7 @@
 package lincs_gse92742_v0
 
 import (
+	"context"
 	"fmt"
 	"sort"
 	"strconv"
@@ -70,7 +71,16 @@ type scoredGeneset struct {
 func (s *scoredGeneset) Score() float64 { return s.score }
 
 func (ds *Dataset) SearchGenesets(keyword string, offset, limit int) (
-	rv []dbs.ScoredGeneset, err error) {
+	[]dbs.ScoredGeneset, error) {
+	return ds.SearchGenesetsContext(context.Background(), keyword, offset,
+		limit)
+}
+
+func (ds *Dataset) SearchGenesetsContext(ctx context.Context, keyword string,
+	offset, limit int) (rv []dbs.ScoredGeneset, err error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
 	keyword = strings.ToLower(keyword)
 	skipped := 0
 	for _, gs := range ds.genesets {
@@ -90,16 +100,32 @@ func (ds *Dataset) SearchGenesets(keyword string, offset, limit int) (
 }
 
 func (ds *Dataset) GetGeneset(genesetId string) (dbs.Geneset, error) {
-	id, err := strconv.ParseUint(genesetId, 10, 0)
-	if err != nil {
+	return ds.GetGenesetContext(context.Background(), genesetId)
+}
+
+func (ds *Dataset) GetGenesetContext(ctx context.Context, genesetId string) (
+	dbs.Geneset, error) {
+	if err := ctx.Err(); err != nil {
 		return nil, err
 	}
+	id, err := strconv.ParseUint(genesetId, 10, 0)
+	if err != nil || id >= uint64(len(ds.genesets)) {
+		return nil, dbs.ErrNotFound.New("invalid geneset id %q", genesetId)
+	}
 	return ds.genesets[id], nil
 }
 
 func (ds *Dataset) NearestGenesets(dims []dbs.Dimension, f dbs.ScoreFilter,
 	offset, limit int) ([]dbs.ScoredGeneset, error) {
-	data, err := ds.NearestGeneSigs(dims, nil, 0, ds.genesigs.Rows())
+	return ds.NearestGenesetsContext(context.Background(), dims, f, offset,
+		limit)
+}
+
+func (ds *Dataset) NearestGenesetsContext(ctx context.Context,
+	dims []dbs.Dimension, f dbs.ScoreFilter, offset, limit int) (
+	[]dbs.ScoredGeneset, error) {
+	data, err := ds.NearestGeneSigsContext(ctx, dims, nil, 0,
+		ds.genesigs.Rows())
 	if err != nil {
 		return nil, err
 	}
@@ -136,6 +162,14 @@ func (ds *Dataset) NearestGenesets(dims []dbs.Dimension, f dbs.ScoreFilter,
 }
 
 func (ds *Dataset) CombineGenes(genes []dbs.Gene) ([]dbs.Dimension, error) {
+	return ds.CombineGenesContext(context.Background(), genes)
+}
+
+func (ds *Dataset) CombineGenesContext(ctx context.Context,
+	genes []dbs.Gene) ([]dbs.Dimension, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
 	var vectors [][]float32
 	for _, gene := range genes {
 		if id, exists := ds.geneSigsByName[gene.Name]; exists {
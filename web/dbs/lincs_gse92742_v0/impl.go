@@ -5,6 +5,7 @@ package lincs_gse92742_v0
 
 import (
 	"bufio"
+	"context"
 	"database/sql"
 	"flag"
 	"fmt"
@@ -41,13 +42,20 @@ var (
 )
 
 type Dataset struct {
+	// db is a read-only connection pool, so a canceled request only
+	// interrupts the connection its own statement is running on.
 	db *sql.DB
-	tx *sql.Tx
 
 	samples  *mmm.Handle
 	genesigs *mmm.Handle
 	genesets []*geneset
 
+	sampleNeighbors  neighbors
+	genesigNeighbors neighbors
+
+	sampleIndexes  indexes
+	genesigIndexes indexes
+
 	dimensionMap        []string
 	dimensionMapReverse map[string]int
 	geneSigsByName      map[string]mmm.Ident
@@ -64,16 +72,11 @@ func New() (*Dataset, error) {
 		}
 	}()
 
-	db, err := sql.Open(*driver, *db)
+	db, err := OpenDB()
 	if err != nil {
 		return nil, err
 	}
 	ds.db = db
-	tx, err := db.Begin()
-	if err != nil {
-		return nil, err
-	}
-	ds.tx = tx
 
 	sample_fh, err := mmm.Open(*samplePath)
 	if err != nil {
@@ -87,6 +90,24 @@ func New() (*Dataset, error) {
 	}
 	ds.genesigs = genesig_fh
 
+	err = ds.sampleNeighbors.open(*samplePath)
+	if err != nil {
+		return nil, err
+	}
+	err = ds.genesigNeighbors.open(*genesigPath)
+	if err != nil {
+		return nil, err
+	}
+
+	err = ds.sampleIndexes.open(*samplePath, sample_fh)
+	if err != nil {
+		return nil, err
+	}
+	err = ds.genesigIndexes.open(*genesigPath, genesig_fh)
+	if err != nil {
+		return nil, err
+	}
+
 	if genesig_fh.Cols() != sample_fh.Cols() {
 		return nil, fmt.Errorf("gene sig and sample data column mismatch")
 	}
@@ -98,7 +119,7 @@ func New() (*Dataset, error) {
 
 	ds.dimensionMap = make([]string, sample_fh.Cols())
 	ds.dimensionMapReverse = make(map[string]int, sample_fh.Cols())
-	rows, err := tx.Query("SELECT id, pr_gene_id FROM dimensions")
+	rows, err := db.Query("SELECT id, pr_gene_id FROM dimensions")
 	if err != nil {
 		return nil, err
 	}
@@ -118,7 +139,7 @@ func New() (*Dataset, error) {
 		}
 
 		var gene_symbol string
-		err := tx.QueryRow("SELECT pr_gene_symbol FROM pr_gene WHERE "+
+		err := db.QueryRow("SELECT pr_gene_symbol FROM pr_gene WHERE "+
 			"pr_gene_id = ?", gene_id).Scan(&gene_symbol)
 		if err != nil {
 			return nil, err
@@ -134,7 +155,7 @@ func New() (*Dataset, error) {
 
 	ds.geneSigsByName = map[string]mmm.Ident{}
 	for i := 0; i < ds.genesigs.Rows(); i++ {
-		s, err := ds.byIdx(ds.genesigs, i, false)
+		s, err := ds.byIdx(context.Background(), ds.genesigs, i, false)
 		if err != nil {
 			return nil, err
 		}
@@ -194,10 +215,10 @@ func (ds *Dataset) Close() error {
 		errs.Add(ds.genesigs.Close())
 		ds.genesigs = nil
 	}
-	if ds.tx != nil {
-		errs.Add(ds.tx.Rollback())
-		ds.tx = nil
-	}
+	errs.Add(ds.sampleNeighbors.Close())
+	errs.Add(ds.genesigNeighbors.Close())
+	errs.Add(ds.sampleIndexes.Close())
+	errs.Add(ds.genesigIndexes.Close())
 	if ds.db != nil {
 		errs.Add(ds.db.Close())
 		ds.db = nil
@@ -236,38 +257,55 @@ func (ds *Dataset) SampleTagNames() []string {
 		"pert_itime", "is_touchstone"}
 }
 
-func (ds *Dataset) list(h *mmm.Handle, offset, limit int, tags bool) (
-	rv []*sample, err error) {
+func (ds *Dataset) list(ctx context.Context, h *mmm.Handle, offset,
+	limit int, tags bool) (rv []*sample, err error) {
+	var idxs []int
 	for i := offset; i < offset+limit && i < h.Rows(); i++ {
-		s, err := ds.byIdx(h, i, tags)
-		if err != nil {
-			return nil, err
-		}
-		rv = append(rv, s)
+		idxs = append(idxs, i)
 	}
-	return rv, nil
+	return ds.byIdxs(ctx, h, idxs, tags)
 }
 
 func (ds *Dataset) ListGeneSigs(offset, limit int) ([]dbs.GeneSig, error) {
-	rv, err := ds.list(ds.genesigs, offset, limit, false)
+	return ds.ListGeneSigsContext(context.Background(), offset, limit)
+}
+
+func (ds *Dataset) ListGeneSigsContext(ctx context.Context, offset,
+	limit int) ([]dbs.GeneSig, error) {
+	rv, err := ds.list(ctx, ds.genesigs, offset, limit, false)
 	return samplesToGeneSigs(rv), err
 }
 
 func (ds *Dataset) ListSamples(offset, limit int) (samples []dbs.Sample,
 	err error) {
-	rv, err := ds.list(ds.samples, offset, limit, true)
+	return ds.ListSamplesContext(context.Background(), offset, limit)
+}
+
+func (ds *Dataset) ListSamplesContext(ctx context.Context, offset,
+	limit int) (samples []dbs.Sample, err error) {
+	rv, err := ds.list(ctx, ds.samples, offset, limit, true)
 	return samplesToSamples(rv), err
 }
 
 func (ds *Dataset) ListGenesets(offset, limit int) (rv []dbs.Geneset,
 	err error) {
+	return ds.ListGenesetsContext(context.Background(), offset, limit)
+}
+
+func (ds *Dataset) ListGenesetsContext(ctx context.Context, offset,
+	limit int) (rv []dbs.Geneset, err error) {
+	err = ctx.Err()
+	if err != nil {
+		return nil, err
+	}
 	for i := offset; i < offset+limit && i < len(ds.genesets); i++ {
 		rv = append(rv, ds.genesets[i])
 	}
 	return rv, nil
 }
 
-func (ds *Dataset) load(h *mmm.Handle, mmm_id mmm.Ident, tags bool) (
+func (ds *Dataset) load(ctx context.Context, h *mmm.Handle,
+	mmm_id mmm.Ident, tags bool) (
 	rv *sample, found bool, err error) {
 	values, found := h.RowById(mmm_id)
 	if !found {
@@ -276,13 +314,13 @@ func (ds *Dataset) load(h *mmm.Handle, mmm_id mmm.Ident, tags bool) (
 	var pert_iname, pert_id, pert_type, cell_id, pert_idose, pert_itime,
 		is_touchstone string
 	if tags {
-		err = ds.tx.QueryRow("SELECT sig.pert_iname, sig.pert_id, sig.pert_type, "+
+		err = ds.db.QueryRowContext(ctx, "SELECT sig.pert_iname, sig.pert_id, sig.pert_type, "+
 			"sig.cell_id, sig.pert_idose, sig.pert_itime, sig.is_touchstone "+
 			"FROM sig sig, signatures s WHERE s.sig_id = sig.sig_id AND s.id = ?",
 			mmm_id).Scan(&pert_iname, &pert_id, &pert_type, &cell_id, &pert_idose,
 			&pert_itime, &is_touchstone)
 	} else {
-		err = ds.tx.QueryRow("SELECT sig.pert_iname "+
+		err = ds.db.QueryRowContext(ctx, "SELECT sig.pert_iname "+
 			"FROM sig sig, signatures s WHERE s.sig_id = sig.sig_id AND s.id = ?",
 			mmm_id).Scan(&pert_iname)
 	}
@@ -310,25 +348,67 @@ func (ds *Dataset) load(h *mmm.Handle, mmm_id mmm.Ident, tags bool) (
 	return rv, true, nil
 }
 
-func (ds *Dataset) byIdx(h *mmm.Handle, idx int, tags bool) (*sample, error) {
-	s, found, err := ds.load(h, h.RowIdByIdx(idx), tags)
+func (ds *Dataset) byIdx(ctx context.Context, h *mmm.Handle, idx int,
+	tags bool) (*sample, error) {
+	s, found, err := ds.load(ctx, h, h.RowIdByIdx(idx), tags)
 	return s, notFound(found, err)
 }
 
+// byIdxs is like byIdx for many rows at once, but loads metadata with a
+// query per batch of rows instead of a query per row.
+func (ds *Dataset) byIdxs(ctx context.Context, h *mmm.Handle, idxs []int,
+	tags bool) (
+	rv []*sample, err error) {
+	ids := make([]mmm.Ident, 0, len(idxs))
+	for _, idx := range idxs {
+		ids = append(ids, h.RowIdByIdx(idx))
+	}
+	metadata, err := LookupSigs(ctx, ds.db, ids, tags)
+	if err != nil {
+		return nil, err
+	}
+	rv = make([]*sample, 0, len(idxs))
+	for i, idx := range idxs {
+		m, found := metadata[ids[i]]
+		if !found {
+			return nil, notFound(false, nil)
+		}
+		rv = append(rv, &sample{
+			mmm_id:       ids[i],
+			name:         m.Name,
+			tags:         m.Tags,
+			data:         h.RowByIdx(idx),
+			dimensionMap: ds.dimensionMap,
+		})
+	}
+	return rv, nil
+}
+
 func (ds *Dataset) GetGeneSig(geneSigId string) (dbs.GeneSig, error) {
+	return ds.GetGeneSigContext(context.Background(), geneSigId)
+}
+
+func (ds *Dataset) GetGeneSigContext(ctx context.Context, geneSigId string) (
+	dbs.GeneSig, error) {
 	id, err := strconv.ParseUint(geneSigId, 10, 32)
 	if err != nil {
-		return nil, err
+		return nil, dbs.ErrNotFound.New("invalid gene signature id %q",
+			geneSigId)
 	}
-	rv, found, err := ds.load(ds.genesigs, mmm.Ident(id), false)
+	rv, found, err := ds.load(ctx, ds.genesigs, mmm.Ident(id), false)
 	return rv, notFound(found, err)
 }
 
 func (ds *Dataset) GetSample(sampleId string) (dbs.Sample, error) {
+	return ds.GetSampleContext(context.Background(), sampleId)
+}
+
+func (ds *Dataset) GetSampleContext(ctx context.Context, sampleId string) (
+	dbs.Sample, error) {
 	id, err := strconv.ParseUint(sampleId, 10, 32)
 	if err != nil {
-		return nil, err
+		return nil, dbs.ErrNotFound.New("invalid sample id %q", sampleId)
 	}
-	rv, found, err := ds.load(ds.samples, mmm.Ident(id), true)
+	rv, found, err := ds.load(ctx, ds.samples, mmm.Ident(id), true)
 	return rv, notFound(found, err)
 }
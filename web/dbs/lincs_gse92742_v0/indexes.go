@@ -0,0 +1,105 @@
+// Copyright (C) 2017 JT Olds
+// See LICENSE for copying information
+
+package lincs_gse92742_v0
+
+import (
+	"context"
+	"flag"
+	"math"
+	"os"
+
+	"github.com/jtolds/golincs/hnsw"
+	"github.com/jtolds/golincs/mmm"
+	"github.com/jtolds/golincs/web/dbs"
+	"github.com/spacemonkeygo/errors"
+)
+
+var (
+	hnswEf = flag.Int("gse92742.hnsw_ef", 100,
+		"default candidate list size for searches of HNSW indexes built with "+
+			"hnswbuild")
+)
+
+// indexes are the optional approximate search indexes for an mmm file.
+type indexes struct {
+	reduced   reducedIndex
+	graph     *hnsw.Index
+	quantized *mmm.Quantized
+}
+
+func (ix *indexes) open(path string, h *mmm.Handle) (err error) {
+	err = ix.reduced.open(path, h)
+	if err != nil {
+		return err
+	}
+	err = ix.openGraph(path, h)
+	if err != nil {
+		return err
+	}
+	return ix.openQuantized(path, h)
+}
+
+func (ix *indexes) openGraph(path string, h *mmm.Handle) (err error) {
+	_, err = os.Stat(hnsw.Path(path))
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	ix.graph, err = hnsw.LoadFile(hnsw.Path(path), h)
+	if err != nil {
+		return err
+	}
+	if math.IsNaN(ix.graph.Recall) {
+		logger.Noticef("loaded HNSW index for %s (m=%d, ef_construction=%d)",
+			path, ix.graph.M, ix.graph.EfConstruction)
+	} else {
+		logger.Noticef("loaded HNSW index for %s (m=%d, ef_construction=%d, "+
+			"measured recall %0.4f)", path, ix.graph.M, ix.graph.EfConstruction,
+			ix.graph.Recall)
+	}
+	return nil
+}
+
+func (ix *indexes) Close() error {
+	var errs errors.ErrorGroup
+	errs.Add(ix.reduced.Close())
+	ix.graph = nil
+	if ix.quantized != nil {
+		errs.Add(ix.quantized.Close())
+		ix.quantized = nil
+	}
+	return errs.Finalize()
+}
+
+// nearestGraph finds nearest rows with an HNSW search, re-scoring the
+// candidate list the graph search returns exactly. It can't apply sample
+// filters.
+func (ds *Dataset) nearestGraph(ctx context.Context, mh *mmm.Handle,
+	graph *hnsw.Index, ef int, query []float32, score_filter dbs.ScoreFilter,
+	offset, limit int, tags bool) (rv []scoredSample, ok bool, err error) {
+	if ef <= 0 {
+		ef = *hnswEf
+	}
+	if offset+limit > ef {
+		return nil, false, nil
+	}
+
+	scored := make(maxHeap, 0, ef)
+	for _, res := range graph.Search(query, 0, ef) {
+		score := unitCosineSimilarity(query, mh.RowByIdx(res.Idx))
+		if score_filter != nil && !score_filter(score) {
+			continue
+		}
+		scored = append(scored, scoredSample{idx: res.Idx, score: score})
+	}
+	scored.Sort()
+
+	if len(scored) < offset+limit && ef < mh.Rows() {
+		return nil, false, nil
+	}
+	rv, err = ds.withSamples(ctx, mh, page(scored, offset, limit), tags)
+	return rv, err == nil, err
+}
@@ -0,0 +1,216 @@
+// Copyright (C) 2017 JT Olds
+// See LICENSE for copying information
+
+package lincs_gse92742_v0
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/jtolds/golincs/mmm"
+)
+
+var columnName = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// OpenDB opens the metadata database configured by the gse92742.db_path and
+// gse92742.db_driver flags. sqlite3 databases are opened read-only.
+func OpenDB() (*sql.DB, error) {
+	dsn := *db
+	if *driver == "sqlite3" && !strings.HasPrefix(dsn, "file:") {
+		dsn = "file:" + dsn + "?mode=ro"
+	}
+	return sql.Open(*driver, dsn)
+}
+
+// splitClauses splits a metadata query on AND, ignoring any AND inside a
+// quoted value.
+func splitClauses(query string) (clauses []string) {
+	var quote rune
+	start := 0
+	for i, r := range query {
+		switch {
+		case quote != 0:
+			if r == quote {
+				quote = 0
+			}
+		case r == '"' || r == '\'':
+			quote = r
+		case (i == 0 || query[i-1] == ' ') && len(query) >= i+4 &&
+			strings.EqualFold(query[i:i+4], "and ") &&
+			strings.TrimSpace(query[start:i]) != "":
+			clauses = append(clauses, query[start:i])
+			start = i + 4
+		}
+	}
+	return append(clauses, query[start:])
+}
+
+func unquote(val string) string {
+	if len(val) >= 2 && (val[0] == '"' || val[0] == '\'') &&
+		val[len(val)-1] == val[0] {
+		return val[1 : len(val)-1]
+	}
+	return val
+}
+
+// metadataWhere translates a metadata query into an SQL condition on the sig
+// table, with placeholders for the values.
+func metadataWhere(query string) (where string, args []interface{},
+	err error) {
+	var conds []string
+	for _, clause := range splitClauses(query) {
+		clause = strings.TrimSpace(clause)
+		if clause == "" {
+			return "", nil, fmt.Errorf("empty clause in query %q", query)
+		}
+		idx := strings.IndexAny(clause, "=!~")
+		if idx < 0 {
+			return "", nil, fmt.Errorf("clause %q has no operator", clause)
+		}
+		column := strings.TrimSpace(clause[:idx])
+		rest := clause[idx:]
+		var op string
+		for _, candidate := range []string{"!=", "!~", "=", "~"} {
+			if strings.HasPrefix(rest, candidate) {
+				op = candidate
+				break
+			}
+		}
+		if op == "" {
+			return "", nil, fmt.Errorf("clause %q has an invalid operator", clause)
+		}
+		if !columnName.MatchString(column) {
+			return "", nil, fmt.Errorf("invalid column name %q", column)
+		}
+		value := unquote(strings.TrimSpace(rest[len(op):]))
+
+		switch op {
+		case "=":
+			conds = append(conds, fmt.Sprintf(`sig."%s" = ?`, column))
+		case "!=":
+			conds = append(conds, fmt.Sprintf(`sig."%s" != ?`, column))
+		case "~":
+			conds = append(conds, fmt.Sprintf(`instr(lower(sig."%s"), ?)`, column))
+			value = strings.ToLower(value)
+		case "!~":
+			conds = append(conds,
+				fmt.Sprintf(`NOT instr(lower(sig."%s"), ?)`, column))
+			value = strings.ToLower(value)
+		}
+		args = append(args, value)
+	}
+	return strings.Join(conds, " AND "), args, nil
+}
+
+func selectIds(db *sql.DB, query string) (ids []mmm.Ident, err error) {
+	where, args, err := metadataWhere(query)
+	if err != nil {
+		return nil, err
+	}
+	rows, err := db.Query("SELECT s.id FROM signatures s, sig sig WHERE "+
+		"s.sig_id = sig.sig_id AND "+where+" ORDER BY s.id", args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var id mmm.Ident
+		err = rows.Scan(&id)
+		if err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	return ids, rows.Err()
+}
+
+// SelectIds resolves a metadata query against the signatures and sig tables
+// of db to a list of mmm row ids. A query looks like
+//
+//	cell_id = MCF7 AND pert_type = trt_cp AND pert_itime = 24 h
+//
+// Each AND-separated clause is a sig column name, an operator, and a value.
+// The operators are = and != for exact matches, and ~ and !~ for
+// case-insensitive substring matches. Values may be quoted.
+func SelectIds(db *sql.DB, query string) ([]mmm.Ident, error) {
+	return selectIds(db, query)
+}
+
+// SelectIds resolves a metadata query to the mmm row ids it matches.
+func (ds *Dataset) SelectIds(query string) ([]mmm.Ident, error) {
+	return selectIds(ds.db, query)
+}
+
+// SigMetadata is a signature's name and sample tags from the metadata db.
+type SigMetadata struct {
+	Name string // pert_iname
+	Tags map[string]string
+}
+
+// LookupSigs looks up the metadata of the signatures with the given mmm row
+// ids in db, with a query per batch of ids instead of a query per id. Tags
+// are only loaded if tags is true. Ids that aren't in db are left out.
+func LookupSigs(ctx context.Context, db *sql.DB, ids []mmm.Ident,
+	tags bool) (rv map[mmm.Ident]SigMetadata, err error) {
+	const batchSize = 500
+	rv = make(map[mmm.Ident]SigMetadata, len(ids))
+	for len(ids) > 0 {
+		batch := ids
+		if len(batch) > batchSize {
+			batch = batch[:batchSize]
+		}
+		ids = ids[len(batch):]
+
+		args := make([]interface{}, 0, len(batch))
+		for _, id := range batch {
+			args = append(args, id)
+		}
+		columns := "s.id, sig.pert_iname"
+		if tags {
+			columns += ", sig.pert_id, sig.pert_type, sig.cell_id, " +
+				"sig.pert_idose, sig.pert_itime, sig.is_touchstone"
+		}
+		rows, err := db.QueryContext(ctx, "SELECT "+columns+" "+
+			"FROM sig sig, signatures s WHERE s.sig_id = sig.sig_id AND s.id IN ("+
+			strings.TrimSuffix(strings.Repeat("?, ", len(batch)), ", ")+")",
+			args...)
+		if err != nil {
+			return nil, err
+		}
+		for rows.Next() {
+			var mmm_id mmm.Ident
+			var pert_iname, pert_id, pert_type, cell_id, pert_idose, pert_itime,
+				is_touchstone string
+			if tags {
+				err = rows.Scan(&mmm_id, &pert_iname, &pert_id, &pert_type,
+					&cell_id, &pert_idose, &pert_itime, &is_touchstone)
+			} else {
+				err = rows.Scan(&mmm_id, &pert_iname)
+			}
+			if err != nil {
+				rows.Close()
+				return nil, err
+			}
+			m := SigMetadata{Name: pert_iname, Tags: map[string]string{}}
+			if tags {
+				m.Tags["pert_id"] = pert_id
+				m.Tags["pert_type"] = pert_type
+				m.Tags["cell_id"] = cell_id
+				m.Tags["pert_idose"] = pert_idose
+				m.Tags["pert_itime"] = pert_itime
+				m.Tags["is_touchstone"] = is_touchstone
+			}
+			rv[mmm_id] = m
+		}
+		err = rows.Err()
+		rows.Close()
+		if err != nil {
+			return nil, err
+		}
+	}
+	return rv, nil
+}
@@ -5,6 +5,7 @@ package lincs_gse92742_v0
 
 import (
 	"container/heap"
+	"context"
 	"math"
 	"sort"
 
@@ -92,107 +93,117 @@ func normalize(vector []float32) {
 	}
 }
 
-func (ds *Dataset) nearest(mh *mmm.Handle, dims []dbs.Dimension,
-	sample_filter dbs.SampleFilter, score_filter dbs.ScoreFilter,
-	offset, limit int, tags bool) ([]scoredSample, error) {
-
-	query := make([]float32, mh.Cols())
+// queryVector returns dims as a unit vector with a value for every
+// dimension of the dataset.
+func (ds *Dataset) queryVector(dims []dbs.Dimension) []float32 {
+	query := make([]float32, len(ds.dimensionMap))
 	for _, dim := range dims {
 		if idx, found := ds.dimensionMapReverse[dim.Name]; found {
 			query[idx] = float32(dim.Value)
 		}
 	}
 	normalize(query)
+	return query
+}
 
-	var h heapimpl
-	fromend := false
-	if offset+limit <= mh.Rows()-offset {
-		hi := make(minHeap, 0, offset+limit)
-		h = &hi
-		heap.Push(h, scoredSample{idx: -1, score: math.Inf(-1)})
-	} else {
-		fromend = true
-		hi := make(maxHeap, 0, mh.Rows()-offset)
-		h = &hi
-		heap.Push(h, scoredSample{idx: -1, score: math.Inf(1)})
-	}
-
-	for i := 0; i < mh.Rows(); i++ {
-		vals := mh.RowByIdx(i)
-		score := unitCosineSimilarity(query, vals)
-		if fromend {
-			if score >= h.Data()[0].score {
-				continue
-			}
-		} else {
-			if score <= h.Data()[0].score {
-				continue
-			}
-		}
-		if score_filter != nil && !score_filter(score) {
-			continue
-		}
+// nearest returns the requested page of rows of mh nearest to dims. Unless
+// opts.Exact is set, the approximate indexes in ix are tried first: the HNSW
+// graph, then the quantized copy, then the reduced index. Each looks at a
+// limited list of candidates, and reports ok as false if it can't answer
+// the query, either because the requested page is past its candidates or
+// because too many of them were filtered out. The next index is tried then,
+// and an exact scan of every row is the last resort.
+func (ds *Dataset) nearest(ctx context.Context, mh *mmm.Handle,
+	ix *indexes, opts dbs.SearchOptions, dims []dbs.Dimension,
+	sample_filter dbs.SampleFilter, score_filter dbs.ScoreFilter,
+	offset, limit int, tags bool) ([]scoredSample, error) {
 
-		var s dbs.Sample
-		if sample_filter != nil {
-			var err error
-			s, err = ds.byIdx(mh, i, tags)
-			if err != nil {
-				return nil, err
-			}
-			if !sample_filter(s) {
-				continue
-			}
-		}
+	query := ds.queryVector(dims)
 
-		if h.Len() >= h.Cap() {
-			heap.Pop(h)
+	if !opts.Exact && sample_filter == nil && ix.graph != nil {
+		rv, ok, err := ds.nearestGraph(ctx, mh, ix.graph, opts.Candidates, query,
+			score_filter, offset, limit, tags)
+		if err != nil || ok {
+			return rv, err
 		}
-		heap.Push(h, scoredSample{
-			idx:    i,
-			score:  score,
-			Sample: s})
 	}
-
-	h.Sort()
-
-	rv := make([]scoredSample, 0, limit)
-	found := 0
-	for _, el := range h.Data() {
-		if el.idx == -1 {
-			continue
-		}
-		if el.Sample == nil {
-			s, err := ds.byIdx(mh, el.idx, tags)
-			if err != nil {
-				return nil, err
-			}
-			el.Sample = s
+	if !opts.Exact && ix.quantized != nil {
+		rv, ok, err := ds.nearestQuantized(ctx, mh, ix.quantized, opts.Candidates,
+			query, sample_filter, score_filter, offset, limit, tags)
+		if err != nil || ok {
+			return rv, err
 		}
-		if !fromend && found < offset {
-			found++
-			continue
-		}
-		rv = append(rv, el)
-		if len(rv) >= limit {
-			break
+	}
+	if !opts.Exact && ix.reduced.loaded() {
+		rv, ok, err := ds.nearestReduced(ctx, mh, &ix.reduced, opts.Candidates,
+			query, sample_filter, score_filter, offset, limit, tags)
+		if err != nil || ok {
+			return rv, err
 		}
 	}
-	return rv, nil
+
+	return ds.nearestExact(ctx, mh, query, sample_filter, score_filter, offset,
+		limit, tags)
 }
 
 func (ds *Dataset) NearestGeneSigs(dims []dbs.Dimension,
 	score_filter dbs.ScoreFilter, offset, limit int) (
 	[]dbs.ScoredGeneSig, error) {
-	rv, err := ds.nearest(ds.genesigs, dims, nil, score_filter, offset, limit,
-		false)
-	return scoredSamplesToScoredGeneSigs(rv), err
+	return ds.NearestGeneSigsContext(context.Background(), dims, score_filter,
+		offset, limit)
+}
+
+func (ds *Dataset) NearestGeneSigsContext(ctx context.Context,
+	dims []dbs.Dimension, score_filter dbs.ScoreFilter, offset, limit int) (
+	[]dbs.ScoredGeneSig, error) {
+	return ds.NearestGeneSigsWithContext(ctx, dbs.SearchOptions{}, dims,
+		score_filter, offset, limit)
 }
 
 func (ds *Dataset) NearestSamples(dims []dbs.Dimension,
 	filter dbs.SampleFilter, score_filter dbs.ScoreFilter, offset, limit int) (
 	[]dbs.ScoredSample, error) {
-	rv, err := ds.nearest(ds.samples, dims, filter, score_filter, offset, limit,
-		true)
+	return ds.NearestSamplesContext(context.Background(), dims, filter,
+		score_filter, offset, limit)
+}
+
+func (ds *Dataset) NearestSamplesContext(ctx context.Context,
+	dims []dbs.Dimension, filter dbs.SampleFilter,
+	score_filter dbs.ScoreFilter, offset, limit int) (
+	[]dbs.ScoredSample, error) {
+	return ds.NearestSamplesWithContext(ctx, dbs.SearchOptions{}, dims, filter,
+		score_filter, offset, limit)
+}
+
+func (ds *Dataset) NearestGeneSigsWith(opts dbs.SearchOptions,
+	dims []dbs.Dimension, score_filter dbs.ScoreFilter, offset, limit int) (
+	[]dbs.ScoredGeneSig, error) {
+	return ds.NearestGeneSigsWithContext(context.Background(), opts, dims,
+		score_filter, offset, limit)
+}
+
+func (ds *Dataset) NearestGeneSigsWithContext(ctx context.Context,
+	opts dbs.SearchOptions, dims []dbs.Dimension,
+	score_filter dbs.ScoreFilter, offset, limit int) (
+	[]dbs.ScoredGeneSig, error) {
+	rv, err := ds.nearest(ctx, ds.genesigs, &ds.genesigIndexes, opts, dims, nil,
+		score_filter, offset, limit, false)
+	return scoredSamplesToScoredGeneSigs(rv), err
+}
+
+func (ds *Dataset) NearestSamplesWith(opts dbs.SearchOptions,
+	dims []dbs.Dimension, filter dbs.SampleFilter,
+	score_filter dbs.ScoreFilter, offset, limit int) (
+	[]dbs.ScoredSample, error) {
+	return ds.NearestSamplesWithContext(context.Background(), opts, dims,
+		filter, score_filter, offset, limit)
+}
+
+func (ds *Dataset) NearestSamplesWithContext(ctx context.Context,
+	opts dbs.SearchOptions, dims []dbs.Dimension, filter dbs.SampleFilter,
+	score_filter dbs.ScoreFilter, offset, limit int) (
+	[]dbs.ScoredSample, error) {
+	rv, err := ds.nearest(ctx, ds.samples, &ds.sampleIndexes, opts, dims, filter,
+		score_filter, offset, limit, true)
 	return scoredSamplesToScoredSamples(rv), err
 }
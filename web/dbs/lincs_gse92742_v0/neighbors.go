@@ -0,0 +1,129 @@
+// Copyright (C) 2017 JT Olds
+// See LICENSE for copying information
+
+package lincs_gse92742_v0
+
+import (
+	"context"
+	"os"
+	"strconv"
+
+	"github.com/jtolds/golincs/mmm"
+	"github.com/jtolds/golincs/web/dbs"
+	"github.com/spacemonkeygo/errors"
+)
+
+// neighbors holds the precomputed TopK sidecar files written by mmmknn for
+// an mmm file. Either may be nil if it hasn't been computed.
+type neighbors struct {
+	similar, opposite *mmm.TopK
+}
+
+func openTopKIfExists(path string) (*mmm.TopK, error) {
+	_, err := os.Stat(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return mmm.OpenTopK(path)
+}
+
+func (n *neighbors) open(path string) (err error) {
+	n.similar, err = openTopKIfExists(mmm.SimilarPath(path))
+	if err != nil {
+		return err
+	}
+	if n.similar != nil {
+		logger.Noticef("loaded precomputed neighbors for %s", path)
+	}
+	n.opposite, err = openTopKIfExists(mmm.OppositePath(path))
+	return err
+}
+
+func (n *neighbors) Close() error {
+	var errs errors.ErrorGroup
+	if n.similar != nil {
+		errs.Add(n.similar.Close())
+		n.similar = nil
+	}
+	if n.opposite != nil {
+		errs.Add(n.opposite.Close())
+		n.opposite = nil
+	}
+	return errs.Finalize()
+}
+
+func (ds *Dataset) loadNeighbors(ctx context.Context, h *mmm.Handle,
+	t *mmm.TopK, id mmm.Ident, limit int, tags bool) (rv []scoredSample,
+	err error) {
+	if t == nil {
+		return nil, nil
+	}
+	ids, scores, found := t.Neighbors(id)
+	if !found {
+		return nil, nil
+	}
+	for i, neighbor_id := range ids {
+		if len(rv) >= limit {
+			break
+		}
+		s, found, err := ds.load(ctx, h, neighbor_id, tags)
+		if err != nil {
+			return nil, err
+		}
+		if !found {
+			continue
+		}
+		rv = append(rv, scoredSample{idx: -1, score: float64(scores[i]), Sample: s})
+	}
+	return rv, nil
+}
+
+func (ds *Dataset) neighbors(ctx context.Context, h *mmm.Handle,
+	n *neighbors, id string, limit int, tags bool) (
+	similar, opposite []scoredSample, err error) {
+	mmm_id, err := strconv.ParseUint(id, 10, 32)
+	if err != nil {
+		return nil, nil, err
+	}
+	similar, err = ds.loadNeighbors(ctx, h, n.similar, mmm.Ident(mmm_id),
+		limit, tags)
+	if err != nil {
+		return nil, nil, err
+	}
+	opposite, err = ds.loadNeighbors(ctx, h, n.opposite, mmm.Ident(mmm_id),
+		limit, tags)
+	if err != nil {
+		return nil, nil, err
+	}
+	return similar, opposite, nil
+}
+
+func (ds *Dataset) SampleNeighbors(sampleId string, limit int) (
+	similar, opposite []dbs.ScoredSample, err error) {
+	return ds.SampleNeighborsContext(context.Background(), sampleId, limit)
+}
+
+func (ds *Dataset) SampleNeighborsContext(ctx context.Context,
+	sampleId string, limit int) (similar, opposite []dbs.ScoredSample,
+	err error) {
+	s, o, err := ds.neighbors(ctx, ds.samples, &ds.sampleNeighbors, sampleId,
+		limit, true)
+	return scoredSamplesToScoredSamples(s), scoredSamplesToScoredSamples(o), err
+}
+
+func (ds *Dataset) GeneSigNeighbors(geneSigId string, limit int) (
+	similar, opposite []dbs.ScoredGeneSig, err error) {
+	return ds.GeneSigNeighborsContext(context.Background(), geneSigId, limit)
+}
+
+func (ds *Dataset) GeneSigNeighborsContext(ctx context.Context,
+	geneSigId string, limit int) (similar, opposite []dbs.ScoredGeneSig,
+	err error) {
+	s, o, err := ds.neighbors(ctx, ds.genesigs, &ds.genesigNeighbors,
+		geneSigId, limit, false)
+	return scoredSamplesToScoredGeneSigs(s),
+		scoredSamplesToScoredGeneSigs(o), err
+}
@@ -0,0 +1,97 @@
+// Copyright (C) 2017 JT Olds
+// See LICENSE for copying information
+
+package lincs_gse92742_v0
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/jtolds/golincs/mmm"
+	"github.com/jtolds/golincs/web/dbs"
+)
+
+var (
+	quantizedCandidates = flag.Int("gse92742.quantized_candidates", 1000,
+		"default number of candidates found with a first pass over a "+
+			"quantized copy, made with mmmquantize, to re-rank with exact scores")
+)
+
+func (ix *indexes) openQuantized(path string, h *mmm.Handle) (err error) {
+	_, err = os.Stat(mmm.QuantizedPath(path))
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	ix.quantized, err = mmm.OpenQuantized(mmm.QuantizedPath(path))
+	if err != nil {
+		return err
+	}
+	if ix.quantized.Rows() != h.Rows() || ix.quantized.Cols() != h.Cols() {
+		return fmt.Errorf("quantized copy of %s has mismatched dimensions", path)
+	}
+	for idx, id := range h.RowIds() {
+		if ix.quantized.RowIdByIdx(idx) != id {
+			return fmt.Errorf("quantized copy of %s has mismatched row ids", path)
+		}
+	}
+	logger.Noticef("loaded quantized copy of %s", path)
+	return nil
+}
+
+// quantizedScan returns the indexes of the n rows with the highest
+// int8 scores, best first.
+func quantizedScan(ctx context.Context, q *mmm.Quantized, query []float32,
+	n int) ([]int, error) {
+	query_q := make([]int8, len(query))
+	query_scale := mmm.QuantizeRow(query_q, query)
+
+	scores := make([]float32, q.Rows())
+	workers := workerCount(q.Rows())
+	mmm.ParallelRows(workers, workers, func(w int) {
+		start, end := workerRange(q.Rows(), workers, w)
+		for i := start; i < end; i++ {
+			if (i-start)%scanBlockRows == 0 && ctx.Err() != nil {
+				return
+			}
+			row, scale := q.RowByIdx(i)
+			scores[i] = float32(mmm.DotQuantized(query_q, row)) * query_scale *
+				scale
+		}
+	})
+
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	top := topScores(scores, n, false, nil)
+	rv := make([]int, 0, len(top))
+	for _, el := range top {
+		rv = append(rv, el.idx)
+	}
+	return rv, nil
+}
+
+// nearestQuantized finds nearest rows by re-ranking the best candidates from
+// a scan of the quantized copy with exact scores.
+func (ds *Dataset) nearestQuantized(ctx context.Context, mh *mmm.Handle,
+	q *mmm.Quantized, candidates int, query []float32,
+	sample_filter dbs.SampleFilter, score_filter dbs.ScoreFilter, offset,
+	limit int, tags bool) (rv []scoredSample, ok bool, err error) {
+	if candidates <= 0 {
+		candidates = *quantizedCandidates
+	}
+	if offset+limit > candidates {
+		return nil, false, nil
+	}
+	idxs, err := quantizedScan(ctx, q, query, candidates)
+	if err != nil {
+		return nil, false, err
+	}
+	return ds.rerank(ctx, mh, idxs, candidates >= mh.Rows(), query,
+		sample_filter, score_filter, offset, limit, tags)
+}
@@ -0,0 +1,175 @@
+// Copyright (C) 2017 JT Olds
+// See LICENSE for copying information
+
+package lincs_gse92742_v0
+
+import (
+	"container/heap"
+	"context"
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/jtolds/golincs/mmm"
+	"github.com/jtolds/golincs/pca"
+	"github.com/jtolds/golincs/web/dbs"
+	"github.com/spacemonkeygo/errors"
+)
+
+var (
+	pcaComponents = flag.Int("gse92742.pca_components", 0,
+		"if > 0, build a PCA-reduced search index with this many components "+
+			"for sample and gene signature files that don't have one yet. "+
+			"indexes can also be built ahead of time with mmmpca, writing "+
+			"-scores to <path>.pca and -loadings to <path>.pca.loadings")
+	pcaCandidates = flag.Int("gse92742.pca_candidates", 2000,
+		"default number of candidates found in a PCA-reduced index to re-rank "+
+			"with exact scores")
+)
+
+func reducedScoresPath(path string) string   { return path + ".pca" }
+func reducedLoadingsPath(path string) string { return path + ".pca.loadings" }
+
+// reducedIndex is a PCA-projected copy of an mmm file. scores has the same
+// rows as the original file and a column per component, and loadings has a
+// row per component and the same columns as the original file. Both are nil
+// if there is no index.
+type reducedIndex struct {
+	scores, loadings *mmm.Handle
+}
+
+func buildReducedIndex(path string, h *mmm.Handle) error {
+	logger.Noticef("building %d component PCA index for %s", *pcaComponents,
+		path)
+	res, err := pca.Randomized(h, pca.RandomizedOptions{
+		Components: *pcaComponents})
+	if err != nil {
+		return err
+	}
+	err = res.WriteScores(reducedScoresPath(path), h, 0)
+	if err != nil {
+		return err
+	}
+	return res.WriteLoadings(reducedLoadingsPath(path), h)
+}
+
+func (ri *reducedIndex) open(path string, h *mmm.Handle) (err error) {
+	_, err = os.Stat(reducedScoresPath(path))
+	if os.IsNotExist(err) {
+		if *pcaComponents <= 0 {
+			return nil
+		}
+		err = buildReducedIndex(path, h)
+	}
+	if err != nil {
+		return err
+	}
+
+	ri.scores, err = mmm.Open(reducedScoresPath(path))
+	if err != nil {
+		return err
+	}
+	ri.loadings, err = mmm.Open(reducedLoadingsPath(path))
+	if err != nil {
+		return err
+	}
+
+	if ri.scores.Rows() != h.Rows() || ri.scores.Cols() != ri.loadings.Rows() ||
+		ri.loadings.Cols() != h.Cols() {
+		return fmt.Errorf("PCA index for %s has mismatched dimensions", path)
+	}
+	for idx, id := range h.RowIds() {
+		if ri.scores.RowIdByIdx(idx) != id {
+			return fmt.Errorf("PCA index for %s has mismatched row ids", path)
+		}
+	}
+	for idx, id := range h.ColIds() {
+		if ri.loadings.ColIdByIdx(idx) != id {
+			return fmt.Errorf("PCA index for %s has mismatched column ids", path)
+		}
+	}
+	logger.Noticef("loaded %d component PCA index for %s", ri.scores.Cols(),
+		path)
+	return nil
+}
+
+func (ri *reducedIndex) Close() error {
+	var errs errors.ErrorGroup
+	if ri.scores != nil {
+		errs.Add(ri.scores.Close())
+		ri.scores = nil
+	}
+	if ri.loadings != nil {
+		errs.Add(ri.loadings.Close())
+		ri.loadings = nil
+	}
+	return errs.Finalize()
+}
+
+func (ri *reducedIndex) loaded() bool { return ri.scores != nil }
+
+func dot(a, b []float32) (sum float64) {
+	for i := range a {
+		sum += float64(a[i]) * float64(b[i])
+	}
+	return sum
+}
+
+// candidates returns the indexes of the n rows with the highest scores in
+// reduced space, best first. Rows are scored as the dot product of their
+// centered projection with the projection of the query, which ranks rows the
+// same as the dot product of the original rows with the query, up to the
+// error from dropped components.
+func (ri *reducedIndex) candidates(ctx context.Context, query []float32,
+	n int) ([]int, error) {
+	projected := make([]float32, ri.loadings.Rows())
+	for c := range projected {
+		projected[c] = float32(dot(query, ri.loadings.RowByIdx(c)))
+	}
+
+	h := make(minHeap, 0, n)
+	for i := 0; i < ri.scores.Rows(); i++ {
+		if i%scanBlockRows == 0 {
+			if err := ctx.Err(); err != nil {
+				return nil, err
+			}
+		}
+		score := dot(projected, ri.scores.RowByIdx(i))
+		if len(h) < n {
+			heap.Push(&h, scoredSample{idx: i, score: score})
+			continue
+		}
+		if score <= h[0].score {
+			continue
+		}
+		h[0] = scoredSample{idx: i, score: score}
+		heap.Fix(&h, 0)
+	}
+	h.Sort()
+
+	rv := make([]int, 0, len(h))
+	for _, el := range h {
+		rv = append(rv, el.idx)
+	}
+	return rv, nil
+}
+
+// nearestReduced finds nearest rows by re-ranking candidates from the
+// reduced index with exact scores.
+func (ds *Dataset) nearestReduced(ctx context.Context, mh *mmm.Handle,
+	ri *reducedIndex, candidates int, query []float32,
+	sample_filter dbs.SampleFilter, score_filter dbs.ScoreFilter, offset,
+	limit int, tags bool) (rv []scoredSample, ok bool, err error) {
+	if candidates <= 0 {
+		candidates = *pcaCandidates
+	}
+	if offset+limit > candidates {
+		return nil, false, nil
+	}
+	idxs, err := ri.candidates(ctx, query, candidates)
+	if err != nil {
+		return nil, false, err
+	}
+	return ds.rerank(ctx, mh, idxs, candidates >= mh.Rows(), query,
+		sample_filter, score_filter, offset, limit, tags)
+}
@@ -0,0 +1,311 @@
+// Copyright (C) 2017 JT Olds
+// See LICENSE for copying information
+
+package lincs_gse92742_v0
+
+import (
+	"container/heap"
+	"context"
+	"flag"
+	"runtime"
+
+	"github.com/gonum/blas"
+	"github.com/gonum/blas/blas32"
+	"github.com/jtolds/golincs/mmm"
+	"github.com/jtolds/golincs/web/dbs"
+)
+
+var (
+	scanWorkers = flag.Int("gse92742.scan_workers", 0,
+		"number of goroutines used for exact nearest scans. if <= 0, "+
+			"GOMAXPROCS is used")
+)
+
+const (
+	// scanBlockRows is how many rows are scored per matrix-vector product.
+	scanBlockRows = 256
+	// filterBatchRows is how many rows have their sample filter checked per
+	// metadata query.
+	filterBatchRows = 256
+)
+
+func workerCount(rows int) int {
+	workers := *scanWorkers
+	if workers <= 0 {
+		workers = runtime.GOMAXPROCS(0)
+	}
+	if workers > rows {
+		workers = rows
+	}
+	if workers < 1 {
+		workers = 1
+	}
+	return workers
+}
+
+// workerRange returns the rows worker w of workers is responsible for.
+func workerRange(rows, workers, w int) (start, end int) {
+	per_worker := (rows + workers - 1) / workers
+	start, end = w*per_worker, (w+1)*per_worker
+	if start > rows {
+		start = rows
+	}
+	if end > rows {
+		end = rows
+	}
+	return start, end
+}
+
+// scoreRows returns the dot product of query with every row of mh, which is
+// the cosine similarity since both are unit vectors. Each worker scores its
+// share of the rows a block at a time with a float32 matrix-vector product,
+// checking for cancellation between blocks.
+func scoreRows(ctx context.Context, mh *mmm.Handle, query []float32) (
+	[]float32, error) {
+	scores := make([]float32, mh.Rows())
+	workers := workerCount(mh.Rows())
+	x := blas32.Vector{Inc: 1, Data: query}
+	mmm.ParallelRows(workers, workers, func(w int) {
+		start, end := workerRange(mh.Rows(), workers, w)
+		for ; start < end && ctx.Err() == nil; start += scanBlockRows {
+			block_end := start + scanBlockRows
+			if block_end > end {
+				block_end = end
+			}
+			blas32.Gemv(blas.NoTrans, 1, blas32.General{
+				Rows:   block_end - start,
+				Cols:   mh.Cols(),
+				Stride: mh.Cols(),
+				Data:   mh.RowsByIdx(start, block_end),
+			}, x, 0, blas32.Vector{Inc: 1, Data: scores[start:block_end]})
+		}
+	})
+	err := ctx.Err()
+	if err != nil {
+		return nil, err
+	}
+	return scores, nil
+}
+
+// topScores returns up to n rows with the highest scores, or with the lowest
+// scores if lowest is true, skipping rows score_filter rejects. Each worker
+// keeps its own heap for its share of the rows, and the heaps are merged at
+// the end. The result is sorted by descending score in either case, so with
+// lowest set, the lowest score comes last.
+func topScores(scores []float32, n int, lowest bool,
+	score_filter dbs.ScoreFilter) []scoredSample {
+	if n <= 0 {
+		return nil
+	}
+	workers := workerCount(len(scores))
+	heaps := make([]heapimpl, workers)
+	mmm.ParallelRows(workers, workers, func(w int) {
+		var h heapimpl
+		if lowest {
+			hi := make(maxHeap, 0, n)
+			h = &hi
+		} else {
+			hi := make(minHeap, 0, n)
+			h = &hi
+		}
+		start, end := workerRange(len(scores), workers, w)
+		for i := start; i < end; i++ {
+			score := float64(scores[i])
+			if h.Len() >= n {
+				worst := h.Data()[0].score
+				if (lowest && score >= worst) || (!lowest && score <= worst) {
+					continue
+				}
+			}
+			if score_filter != nil && !score_filter(score) {
+				continue
+			}
+			if h.Len() >= n {
+				h.Data()[0] = scoredSample{idx: i, score: score}
+				heap.Fix(h, 0)
+				continue
+			}
+			heap.Push(h, scoredSample{idx: i, score: score})
+		}
+		heaps[w] = h
+	})
+
+	var merged maxHeap
+	for _, h := range heaps {
+		merged = append(merged, h.Data()...)
+	}
+	merged.Sort()
+	if len(merged) > n {
+		if lowest {
+			merged = merged[len(merged)-n:]
+		} else {
+			merged = merged[:n]
+		}
+	}
+	return merged
+}
+
+// nearestExact scores every row of mh against query and returns the
+// requested page of results.
+func (ds *Dataset) nearestExact(ctx context.Context, mh *mmm.Handle,
+	query []float32, sample_filter dbs.SampleFilter,
+	score_filter dbs.ScoreFilter, offset, limit int, tags bool) (
+	[]scoredSample, error) {
+	if limit <= 0 || mh.Rows() == 0 {
+		return nil, nil
+	}
+	scores, err := scoreRows(ctx, mh, query)
+	if err != nil {
+		return nil, err
+	}
+
+	if sample_filter == nil {
+		var results []scoredSample
+		if score_filter != nil || offset+limit <= mh.Rows()-offset {
+			results = page(topScores(scores, offset+limit, false, score_filter),
+				offset, limit)
+		} else {
+			// the page is closer to the end, so keep the fewer rows past offset
+			// instead. this only works when every row counts toward offset.
+			results = page(topScores(scores, mh.Rows()-offset, true, nil), 0,
+				limit)
+		}
+		return ds.withSamples(ctx, mh, results, tags)
+	}
+
+	// with a sample filter, widen the candidate list until the page is full
+	// or every row has been considered.
+	checked := make(map[int]bool)
+	var passing []scoredSample
+	for n := 4 * (offset + limit); ; n *= 4 {
+		candidates := topScores(scores, n, false, score_filter)
+		passing, err = ds.filterSorted(ctx, mh, candidates, checked, passing,
+			sample_filter, offset+limit, tags)
+		if err != nil {
+			return nil, err
+		}
+		if len(passing) >= offset+limit || len(candidates) < n ||
+			n >= mh.Rows() {
+			break
+		}
+	}
+	return page(passing, offset, limit), nil
+}
+
+// filterSorted walks candidates best first, skipping ones already in
+// checked, and appends the ones sample_filter accepts to passing until
+// passing has at least need entries. Filters are checked a batch at a time.
+// Since batches complete in order, passing stays sorted best first.
+func (ds *Dataset) filterSorted(ctx context.Context, mh *mmm.Handle,
+	candidates []scoredSample, checked map[int]bool, passing []scoredSample,
+	sample_filter dbs.SampleFilter, need int, tags bool) (
+	[]scoredSample, error) {
+	batch := make([]scoredSample, 0, filterBatchRows)
+	check := func() error {
+		idxs := make([]int, 0, len(batch))
+		for _, el := range batch {
+			idxs = append(idxs, el.idx)
+		}
+		samples, err := ds.byIdxs(ctx, mh, idxs, tags)
+		if err != nil {
+			return err
+		}
+		for i, s := range samples {
+			if sample_filter(s) {
+				batch[i].Sample = s
+				passing = append(passing, batch[i])
+			}
+		}
+		batch = batch[:0]
+		return nil
+	}
+	for _, el := range candidates {
+		if len(passing) >= need {
+			break
+		}
+		if checked[el.idx] {
+			continue
+		}
+		checked[el.idx] = true
+		batch = append(batch, el)
+		if len(batch) >= filterBatchRows {
+			err := check()
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+	if len(batch) > 0 && len(passing) < need {
+		err := check()
+		if err != nil {
+			return nil, err
+		}
+	}
+	return passing, nil
+}
+
+// rerank scores candidates from an approximate index exactly and returns the
+// requested page of them. ok is false if the candidates can't answer the
+// query because too many of them were filtered out, unless exhaustive says
+// the candidates are every row.
+func (ds *Dataset) rerank(ctx context.Context, mh *mmm.Handle,
+	candidates []int, exhaustive bool, query []float32,
+	sample_filter dbs.SampleFilter, score_filter dbs.ScoreFilter,
+	offset, limit int, tags bool) (
+	rv []scoredSample, ok bool, err error) {
+	scored := make(maxHeap, 0, len(candidates))
+	for _, idx := range candidates {
+		score := unitCosineSimilarity(query, mh.RowByIdx(idx))
+		if score_filter != nil && !score_filter(score) {
+			continue
+		}
+		scored = append(scored, scoredSample{idx: idx, score: score})
+	}
+	scored.Sort()
+
+	if sample_filter != nil {
+		scored, err = ds.filterSorted(ctx, mh, scored, make(map[int]bool), nil,
+			sample_filter, offset+limit, tags)
+		if err != nil {
+			return nil, false, err
+		}
+	}
+	if len(scored) < offset+limit && !exhaustive {
+		return nil, false, nil
+	}
+	rv, err = ds.withSamples(ctx, mh, page(scored, offset, limit), tags)
+	return rv, err == nil, err
+}
+
+// page returns the page of sorted results starting at offset.
+func page(sorted []scoredSample, offset, limit int) []scoredSample {
+	if offset >= len(sorted) {
+		return nil
+	}
+	sorted = sorted[offset:]
+	if len(sorted) > limit {
+		sorted = sorted[:limit]
+	}
+	return sorted
+}
+
+// withSamples loads the samples for results that don't have them yet.
+func (ds *Dataset) withSamples(ctx context.Context, mh *mmm.Handle,
+	results []scoredSample, tags bool) ([]scoredSample, error) {
+	var idxs []int
+	for _, el := range results {
+		if el.Sample == nil {
+			idxs = append(idxs, el.idx)
+		}
+	}
+	samples, err := ds.byIdxs(ctx, mh, idxs, tags)
+	if err != nil {
+		return nil, err
+	}
+	for i := range results {
+		if results[i].Sample == nil {
+			results[i].Sample, samples = samples[0], samples[1:]
+		}
+	}
+	return results, nil
+}
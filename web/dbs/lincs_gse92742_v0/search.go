@@ -4,16 +4,17 @@
 package lincs_gse92742_v0
 
 import (
+	"context"
 	"strings"
 
 	"github.com/jtolds/golincs/mmm"
 	"github.com/jtolds/golincs/web/dbs"
 )
 
-func (ds *Dataset) search(h *mmm.Handle, keyword string,
+func (ds *Dataset) search(ctx context.Context, h *mmm.Handle, keyword string,
 	filter dbs.SampleFilter, offset, limit int, tags bool) (rv []scoredSample,
 	err error) {
-	rows, err := ds.tx.Query(
+	rows, err := ds.db.QueryContext(ctx,
 		"SELECT s.id FROM signatures s, sig sig WHERE s.sig_id = sig.sig_id AND "+
 			"instr(lower(sig.pert_iname), ?)", strings.ToLower(keyword))
 	if err != nil {
@@ -29,7 +30,7 @@ func (ds *Dataset) search(h *mmm.Handle, keyword string,
 			return nil, err
 		}
 
-		s, found, err := ds.load(h, mmm_id, tags)
+		s, found, err := ds.load(ctx, h, mmm_id, tags)
 		if err != nil {
 			return nil, err
 		}
@@ -52,17 +53,29 @@ func (ds *Dataset) search(h *mmm.Handle, keyword string,
 		}
 	}
 
-	return rv, nil
+	return rv, rows.Err()
 }
 
 func (ds *Dataset) SearchGeneSigs(keyword string, offset, limit int) (
 	[]dbs.ScoredGeneSig, error) {
-	rv, err := ds.search(ds.genesigs, keyword, nil, offset, limit, false)
+	return ds.SearchGeneSigsContext(context.Background(), keyword, offset,
+		limit)
+}
+
+func (ds *Dataset) SearchGeneSigsContext(ctx context.Context, keyword string,
+	offset, limit int) ([]dbs.ScoredGeneSig, error) {
+	rv, err := ds.search(ctx, ds.genesigs, keyword, nil, offset, limit, false)
 	return scoredSamplesToScoredGeneSigs(rv), err
 }
 
 func (ds *Dataset) SearchSamples(keyword string, filter dbs.SampleFilter,
 	offset, limit int) ([]dbs.ScoredSample, error) {
-	rv, err := ds.search(ds.samples, keyword, filter, offset, limit, true)
+	return ds.SearchSamplesContext(context.Background(), keyword, filter,
+		offset, limit)
+}
+
+func (ds *Dataset) SearchSamplesContext(ctx context.Context, keyword string,
+	filter dbs.SampleFilter, offset, limit int) ([]dbs.ScoredSample, error) {
+	rv, err := ds.search(ctx, ds.samples, keyword, filter, offset, limit, true)
 	return scoredSamplesToScoredSamples(rv), err
 }
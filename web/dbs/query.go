@@ -0,0 +1,107 @@
+// Copyright (C) 2017 JT Olds
+// See LICENSE for copying information
+
+package dbs
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/spacemonkeygo/errors/errhttp"
+)
+
+var (
+	ErrBadQuery = Err.NewClass("bad query", errhttp.SetStatusCode(400))
+)
+
+// UpDownQuery makes a query out of lists of up-regulated and down-regulated
+// dimension names, at the dataset's largest dimension value.
+func UpDownQuery(data Dataset, up, down []string) ([]Dimension, error) {
+	total := len(up) + len(down)
+	if total == 0 {
+		return nil, ErrBadQuery.New("no dimensions provided")
+	}
+	seen := make(map[string]bool, total)
+	dims := make([]Dimension, 0, total)
+	for _, list := range []struct {
+		names []string
+		value float64
+	}{{up, data.DimMax()}, {down, -data.DimMax()}} {
+		for _, name := range list.names {
+			if seen[name] {
+				return nil, ErrBadQuery.New("dimension %#v provided twice", name)
+			}
+			seen[name] = true
+			dims = append(dims, Dimension{Name: name, Value: list.value})
+		}
+	}
+	return dims, nil
+}
+
+// ParseQueryLines parses tab-separated queries, one per line, with a query
+// name, space-separated up-regulated dimension names, and optionally
+// space-separated down-regulated dimension names. source names the input in
+// errors.
+func ParseQueryLines(data Dataset, r io.Reader, source string) (
+	names []string, queries [][]Dimension, err error) {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(nil, 10*1024*1024)
+	for line_no := 1; scanner.Scan(); line_no++ {
+		line := strings.TrimRight(scanner.Text(), "\r")
+		if strings.TrimSpace(line) == "" {
+			continue
+		}
+		parts := strings.Split(line, "\t")
+		if len(parts) < 2 || len(parts) > 3 {
+			return nil, nil, fmt.Errorf("%s:%d: expected a name and one or two "+
+				"gene lists", source, line_no)
+		}
+		var down []string
+		if len(parts) > 2 {
+			down = strings.Fields(parts[2])
+		}
+		dims, err := UpDownQuery(data, strings.Fields(parts[1]), down)
+		if err != nil {
+			return nil, nil, fmt.Errorf("%s:%d: %v", source, line_no, err)
+		}
+		names = append(names, parts[0])
+		queries = append(queries, dims)
+	}
+	return names, queries, scanner.Err()
+}
+
+// ParseTagFilters splits space-separated key=value sample tag filters into
+// canonical filters. Values match case-insensitively, so they're lowercased.
+func ParseTagFilters(value string) ([]string, error) {
+	var filters []string
+	seen := map[string]bool{}
+	for _, filter_string := range strings.Fields(value) {
+		parts := strings.Split(filter_string, "=")
+		if len(parts) != 2 {
+			return nil, ErrBadQuery.New("bad filter %q", filter_string)
+		}
+		filter := parts[0] + "=" + strings.ToLower(parts[1])
+		if !seen[filter] {
+			seen[filter] = true
+			filters = append(filters, filter)
+		}
+	}
+	return filters, nil
+}
+
+// TagFilter makes a sample filter out of canonical tag filters. A sample
+// passes a filter if its tag contains the filter's value, or if it doesn't
+// have the tag at all. TagFilter returns nil if there are no filters.
+func TagFilter(filters []string) SampleFilter {
+	var rv []SampleFilter
+	for _, filter := range filters {
+		parts := strings.SplitN(filter, "=", 2)
+		rv = append(rv, func(s Sample) bool {
+			val, ok := s.Tags()[parts[0]]
+			return !ok || strings.Contains(strings.ToLower(val), parts[1])
+		})
+	}
+	return CombineSampleFilters(rv...)
+}
@@ -0,0 +1,21 @@
+// Copyright (C) 2017 JT Olds
+// See LICENSE for copying information
+
+package dbs
+
+import (
+	"fmt"
+	"io"
+)
+
+// ScoreHeader is the header line of tsv score output, which has a line per
+// result, written by WriteScore.
+const ScoreHeader = "query\trank\tid\tname\tscore\n"
+
+// WriteScore writes a tsv score output line. rank counts from 0.
+func WriteScore(w io.Writer, query string, rank int, id, name string,
+	score float64) error {
+	_, err := fmt.Fprintf(w, "%s\t%d\t%s\t%s\t%0.6f\n", query, rank+1, id,
+		name, score)
+	return err
+}
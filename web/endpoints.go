@@ -4,8 +4,10 @@
 package main
 
 import (
+	"context"
 	"fmt"
 	"net/http"
+	"net/url"
 	"strings"
 
 	"github.com/jtolds/golincs/web/dbs"
@@ -15,28 +17,41 @@ import (
 	"gopkg.in/webhelp.v1/whparse"
 )
 
-const defaultLimit = 15
+const (
+	defaultLimit  = 15
+	neighborLimit = 10
+)
 
 type Endpoints struct {
-	data dbs.Dataset
+	data  dbs.Dataset
+	cache *resultCache
+	scans *scheduler
+	jobs  *jobManager
 }
 
-func NewEndpoints(data dbs.Dataset) *Endpoints {
-	return &Endpoints{data: data}
+func NewEndpoints(data dbs.Dataset, scans *scheduler,
+	jobs *jobManager) *Endpoints {
+	return &Endpoints{
+		data:  data,
+		cache: newResultCache(*cacheDepth, *cacheEntries, *cacheBytes),
+		scans: scans,
+		jobs:  jobs,
+	}
 }
 
 func (a *Endpoints) Dataset(w http.ResponseWriter, r *http.Request) {
 	offset := whparse.OptInt(r.FormValue("offset"), 0)
 	limit := whparse.OptInt(r.FormValue("limit"), defaultLimit)
-	samples, err := a.data.ListSamples(offset, limit)
+	ctx := whcompat.Context(r)
+	samples, err := a.data.ListSamplesContext(ctx, offset, limit)
 	if err != nil {
 		whfatal.Error(err)
 	}
-	genesigs, err := a.data.ListGeneSigs(offset, limit)
+	genesigs, err := a.data.ListGeneSigsContext(ctx, offset, limit)
 	if err != nil {
 		whfatal.Error(err)
 	}
-	genesets, err := a.data.ListGenesets(offset, limit)
+	genesets, err := a.data.ListGenesetsContext(ctx, offset, limit)
 	if err != nil {
 		whfatal.Error(err)
 	}
@@ -52,29 +67,46 @@ func (a *Endpoints) Dataset(w http.ResponseWriter, r *http.Request) {
 }
 
 func (a *Endpoints) Sample(w http.ResponseWriter, r *http.Request) {
-	sample, err := a.data.GetSample(sampleId.Get(whcompat.Context(r)))
+	ctx := whcompat.Context(r)
+	sample, err := a.data.GetSampleContext(ctx, sampleId.Get(ctx))
+	if err != nil {
+		whfatal.Error(err)
+	}
+	similar, opposite, err := a.data.SampleNeighborsContext(ctx, sample.Id(),
+		neighborLimit)
 	if err != nil {
 		whfatal.Error(err)
 	}
 	Render("show_sample", map[string]interface{}{
-		"dataset": a.data,
-		"sample":  sample,
+		"dataset":  a.data,
+		"sample":   sample,
+		"similar":  similar,
+		"opposite": opposite,
 	})
 }
 
 func (a *Endpoints) GeneSig(w http.ResponseWriter, r *http.Request) {
-	genesig, err := a.data.GetGeneSig(geneSigId.Get(whcompat.Context(r)))
+	ctx := whcompat.Context(r)
+	genesig, err := a.data.GetGeneSigContext(ctx, geneSigId.Get(ctx))
+	if err != nil {
+		whfatal.Error(err)
+	}
+	similar, opposite, err := a.data.GeneSigNeighborsContext(ctx,
+		genesig.Id(), neighborLimit)
 	if err != nil {
 		whfatal.Error(err)
 	}
 	Render("show_genesig", map[string]interface{}{
-		"dataset": a.data,
-		"genesig": genesig,
+		"dataset":  a.data,
+		"genesig":  genesig,
+		"similar":  similar,
+		"opposite": opposite,
 	})
 }
 
 func (a *Endpoints) Geneset(w http.ResponseWriter, r *http.Request) {
-	geneset, err := a.data.GetGeneset(genesetId.Get(whcompat.Context(r)))
+	ctx := whcompat.Context(r)
+	geneset, err := a.data.GetGenesetContext(ctx, genesetId.Get(ctx))
 	if err != nil {
 		whfatal.Error(err)
 	}
@@ -85,53 +117,21 @@ func (a *Endpoints) Geneset(w http.ResponseWriter, r *http.Request) {
 }
 
 func (a *Endpoints) parseDims(r *http.Request) ([]dbs.Dimension, error) {
-	up_regulated_strings := strings.Fields(r.FormValue("up-regulated"))
-	down_regulated_strings := strings.Fields(r.FormValue("down-regulated"))
-	total := len(up_regulated_strings) + len(down_regulated_strings)
-	if total == 0 {
-		return nil, wherr.BadRequest.New("no dimensions provided")
-	}
-	seen := make(map[string]bool, total)
-	dims := make([]dbs.Dimension, 0, total)
-	for _, name := range up_regulated_strings {
-		if seen[name] {
-			return nil, wherr.BadRequest.New(
-				"dimension %#v provided twice", name)
-		}
-		seen[name] = true
-		dims = append(dims, dbs.Dimension{Name: name, Value: a.data.DimMax()})
-	}
-	for _, name := range down_regulated_strings {
-		if seen[name] {
-			return nil, wherr.BadRequest.New(
-				"dimension %#v provided twice", name)
-		}
-		seen[name] = true
-		dims = append(dims, dbs.Dimension{Name: name, Value: -a.data.DimMax()})
-	}
-	return dims, nil
+	return dbs.UpDownQuery(a.data, strings.Fields(r.FormValue("up-regulated")),
+		strings.Fields(r.FormValue("down-regulated")))
 }
 
 func (a *Endpoints) parseFilters(r *http.Request) dbs.SampleFilter {
-	var filters []dbs.SampleFilter
-	for _, filter_string := range strings.Fields(r.FormValue("filters")) {
-		parts := strings.Split(filter_string, "=")
-		if len(parts) != 2 {
-			whfatal.Error(wherr.BadRequest.New("bad filter"))
-		}
-		filters = append(filters, func(s dbs.Sample) bool {
-			val, ok := s.Tags()[parts[0]]
-			return !ok || strings.Contains(
-				strings.ToLower(val), strings.ToLower(parts[1]))
-		})
-	}
-	if len(filters) > 0 {
-		return dbs.CombineSampleFilters(filters...)
+	filters, err := dbs.ParseTagFilters(r.FormValue("filters"))
+	if err != nil {
+		whfatal.Error(err)
 	}
-	return nil
+	return dbs.TagFilter(filters)
 }
 
-func (a *Endpoints) Signature(w http.ResponseWriter, r *http.Request) {
+// parseQuery reads a signature search from the request form.
+func (a *Endpoints) parseQuery(r *http.Request, rtype string) querySpec {
+	ctx := whcompat.Context(r)
 	var dims []dbs.Dimension
 	switch r.FormValue("qtype") {
 	default:
@@ -144,7 +144,7 @@ func (a *Endpoints) Signature(w http.ResponseWriter, r *http.Request) {
 			whfatal.Error(err)
 		}
 	case "sample":
-		sample, err := a.data.GetSample(r.FormValue("id"))
+		sample, err := a.data.GetSampleContext(ctx, r.FormValue("id"))
 		if err != nil {
 			whfatal.Error(err)
 		}
@@ -153,7 +153,7 @@ func (a *Endpoints) Signature(w http.ResponseWriter, r *http.Request) {
 			whfatal.Error(err)
 		}
 	case "genesig":
-		genesig, err := a.data.GetGeneSig(r.FormValue("id"))
+		genesig, err := a.data.GetGeneSigContext(ctx, r.FormValue("id"))
 		if err != nil {
 			whfatal.Error(err)
 		}
@@ -162,7 +162,7 @@ func (a *Endpoints) Signature(w http.ResponseWriter, r *http.Request) {
 			whfatal.Error(err)
 		}
 	case "geneset":
-		geneset, err := a.data.GetGeneset(r.FormValue("id"))
+		geneset, err := a.data.GetGenesetContext(ctx, r.FormValue("id"))
 		if err != nil {
 			whfatal.Error(err)
 		}
@@ -179,15 +179,203 @@ func (a *Endpoints) Signature(w http.ResponseWriter, r *http.Request) {
 			genes = append(genes, dbs.Gene{Name: dim.Name, Weight: dim.Value})
 		}
 		var err error
-		dims, err = a.data.CombineGenes(genes)
+		dims, err = a.data.CombineGenesContext(ctx, genes)
 		if err != nil {
 			whfatal.Error(err)
 		}
 	}
 
-	offset := whparse.OptInt(r.FormValue("offset"), 0)
-	limit := whparse.OptInt(r.FormValue("limit"), defaultLimit)
+	filters, err := dbs.ParseTagFilters(r.FormValue("filters"))
+	if err != nil {
+		whfatal.Error(err)
+	}
+	return newQuerySpec(dims, filters, rtype, dbs.SearchOptions{
+		Exact:      whparse.OptBool(r.FormValue("exact"), false),
+		Candidates: whparse.OptInt(r.FormValue("candidates"), 0),
+	})
+}
+
+// rank runs a search for client once the scheduler gives it a turn, and
+// returns the page of its ranking starting at offset.
+func (a *Endpoints) rank(ctx context.Context, client string, spec querySpec,
+	offset, limit int) (*ranking, error) {
+	release, err := a.scans.Acquire(ctx, client)
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+
+	rv := &ranking{}
+	switch spec.Rtype {
+	case "samples":
+		rv.Samples, err = a.data.NearestSamplesWithContext(ctx, spec.Opts,
+			spec.Dims, dbs.TagFilter(spec.Filters), nil, offset, limit)
+	case "genesigs":
+		rv.GeneSigs, err = a.data.NearestGeneSigsWithContext(ctx, spec.Opts,
+			spec.Dims, nil, offset, limit)
+	case "genesets":
+		rv.Genesets, err = a.data.NearestGenesetsContext(ctx, spec.Dims, nil,
+			offset, limit)
+	}
+	if err != nil {
+		return nil, err
+	}
+	rv.Complete = rv.Len() < limit
+	return rv, nil
+}
+
+// ranked returns the page of a search's ranking starting at offset, and
+// whether it reaches the end of the ranking. Pages within the cache depth
+// are sliced from the cached ranking, which is computed on first use, so
+// paging through results is stable and doesn't rerun the search until a
+// page past the cached results needs a deeper ranking. A deeper ranking only
+// adds results after the cached ones, so pages already served don't change.
+func (a *Endpoints) ranked(ctx context.Context, client string,
+	spec querySpec, offset, limit int) (page *ranking, err error) {
+	if offset+limit > a.cache.Depth() {
+		return a.rank(ctx, client, spec, offset, limit)
+	}
+	_, cached, ok := a.cache.Get(spec.Id())
+	if !ok || (!cached.Complete && offset+limit > cached.Len()) {
+		deeper, err := a.rank(ctx, client, spec, 0,
+			a.fillDepth(spec, offset+limit, cached))
+		if err != nil {
+			return nil, err
+		}
+		if cached != nil {
+			deeper = cached.extend(deeper)
+		}
+		cached = deeper
+		a.cache.Put(spec, cached)
+	}
+	page = cached.slice(offset, offset+limit)
+	page.Complete = cached.Complete && offset+limit >= cached.Len()
+	return page, nil
+}
+
+// fillDepth returns how many results to rank for the cache, when the first
+// need results are needed and the cached ranking, if any, is too short.
+// Exact searches cost the same at any depth, so they're ranked to the cache
+// depth at once. Otherwise, the ranking starts within what approximate
+// indexes can answer, and grows by doubling.
+func (a *Endpoints) fillDepth(spec querySpec, need int,
+	cached *ranking) int {
+	fill := a.cache.Depth()
+	if !spec.Opts.Exact {
+		fill = *cacheFill
+		if spec.Opts.Candidates > 0 {
+			fill = spec.Opts.Candidates
+		}
+		if cached != nil && fill < 2*cached.Len() {
+			fill = 2 * cached.Len()
+		}
+	}
+	if fill < need {
+		fill = need
+	}
+	if fill > a.cache.Depth() {
+		fill = a.cache.Depth()
+	}
+	return fill
+}
+
+// signatureResults is a page of signature search results.
+type signatureResults struct {
+	spec          querySpec
+	offset, limit int
+	// total is how many results the search has, or the dataset size if the
+	// search hasn't been run to the end.
+	total    int
+	samples  []dbs.ScoredSample
+	genesigs []dbs.ScoredGeneSig
+	genesets []dbs.ScoredGeneset
+}
+
+// searchRtype checks a search result type, which defaults to samples.
+func searchRtype(rtype string) string {
+	switch rtype {
+	default:
+		whfatal.Error(wherr.BadRequest.New("invalid rtype %q", rtype))
+	case "":
+		rtype = "samples"
+	case "samples", "genesigs", "genesets":
+	}
+	return rtype
+}
+
+// signatureSearch runs the signature search described by the request form,
+// for both the html and json endpoints.
+func (a *Endpoints) signatureSearch(w http.ResponseWriter,
+	r *http.Request) *signatureResults {
+	rtype := searchRtype(r.FormValue("rtype"))
+
+	var spec querySpec
+	if q := r.FormValue("q"); q != "" {
+		decoded, err := decodeQuerySpec(q)
+		if err != nil {
+			whfatal.Error(wherr.BadRequest.New("invalid query: %v", err))
+		}
+		spec = decoded.WithRtype(rtype)
+	} else if qid := r.FormValue("qid"); qid != "" {
+		cached, _, ok := a.cache.Get(qid)
+		if !ok {
+			whfatal.Error(wherr.NotFound.New(
+				"query %q not found. it may have expired", qid))
+		}
+		spec = cached.WithRtype(rtype)
+	} else {
+		spec = a.parseQuery(r, rtype)
+	}
+
+	return a.searchPage(w, r, spec, whparse.OptInt(r.FormValue("offset"), 0),
+		whparse.OptInt(r.FormValue("limit"), defaultLimit))
+}
+
+// failScan fails a request whose search couldn't run, telling the client
+// when to retry if the scheduler turned it away.
+func (a *Endpoints) failScan(w http.ResponseWriter, err error) {
+	if errQueueFull.Contains(err) {
+		w.Header().Set("Retry-After",
+			fmt.Sprint(int(a.scans.RetryAfter().Seconds())))
+	}
+	whfatal.Error(err)
+}
+
+// searchPage returns a page of a search's results.
+func (a *Endpoints) searchPage(w http.ResponseWriter, r *http.Request,
+	spec querySpec, offset, limit int) *signatureResults {
+	ctx := whcompat.Context(r)
+	page, err := a.ranked(ctx, clientKey(r), spec, offset, limit)
+	if err != nil {
+		a.failScan(w, err)
+	}
+
+	rv := &signatureResults{spec: spec, offset: offset, limit: limit}
+	// the ranking may end before the dataset does, if filters or the
+	// search itself cut it short.
+	total := func(size int) int {
+		if page.Complete {
+			return offset + page.Len()
+		}
+		return size
+	}
 
+	rv.samples, rv.genesigs, rv.genesets = page.Samples, page.GeneSigs,
+		page.Genesets
+	switch spec.Rtype {
+	case "samples":
+		rv.total = total(a.data.Samples())
+	case "genesigs":
+		rv.total = total(a.data.GeneSigs())
+	case "genesets":
+		rv.total = total(a.data.Genesets())
+	}
+	return rv
+}
+
+func (a *Endpoints) Signature(w http.ResponseWriter, r *http.Request) {
+	results := a.signatureSearch(w, r)
+	rtype := results.spec.Rtype
 	outmap := map[string]interface{}{
 		"dataset": a.data,
 		"url_for_rtype": func(rtype string) string {
@@ -196,49 +384,58 @@ func (a *Endpoints) Signature(w http.ResponseWriter, r *http.Request) {
 			v["rtype"] = []string{rtype}
 			return "?" + v.Encode()
 		},
+		"query_id": results.spec.Id(),
+		"query_url": "?" + url.Values{
+			"q":     []string{results.spec.Encode()},
+			"rtype": []string{rtype},
+		}.Encode(),
+		"page_urls": newPageURLs(r, results.offset, results.limit,
+			results.total),
 	}
-
-	switch r.FormValue("rtype") {
-	default:
-		whfatal.Error(
-			wherr.BadRequest.New("invalid rtype %q", r.FormValue("rtype")))
-	case "samples", "":
-		nearest, err := a.data.NearestSamples(dims, a.parseFilters(r), nil,
-			offset, limit)
-		if err != nil {
-			whfatal.Error(err)
-		}
-		outmap["page_urls"] = newPageURLs(r, offset, limit, a.data.Samples())
-		outmap["results"] = nearest
-		Render("results_samples", outmap)
+	switch rtype {
+	case "samples":
+		outmap["results"] = results.samples
 	case "genesigs":
-		nearest, err := a.data.NearestGeneSigs(dims, nil, offset, limit)
-		if err != nil {
-			whfatal.Error(err)
-		}
-		outmap["page_urls"] = newPageURLs(r, offset, limit, a.data.GeneSigs())
-		outmap["results"] = nearest
-		Render("results_genesigs", outmap)
+		outmap["results"] = results.genesigs
 	case "genesets":
-		nearest, err := a.data.NearestGenesets(dims, nil, offset, limit)
-		if err != nil {
-			whfatal.Error(err)
-		}
-		outmap["page_urls"] = newPageURLs(r, offset, limit, a.data.Genesets())
-		outmap["results"] = nearest
-		Render("results_genesets", outmap)
+		outmap["results"] = results.genesets
 	}
+	Render("results_"+rtype, outmap)
 }
 
-func (a *Endpoints) Keyword(w http.ResponseWriter, r *http.Request) {
+// keywordSearch runs the keyword search described by the request form, for
+// both the html and json endpoints. results is a list of dbs.ScoredSample,
+// dbs.ScoredGeneSig, or dbs.ScoredGeneset, depending on rtype.
+func (a *Endpoints) keywordSearch(r *http.Request) (rtype string,
+	offset, limit int, results interface{}) {
 	name := r.FormValue("keyword")
 	if name == "" {
 		whfatal.Error(wherr.BadRequest.New("no keyword provided"))
 	}
 
-	offset := whparse.OptInt(r.FormValue("offset"), 0)
-	limit := whparse.OptInt(r.FormValue("limit"), defaultLimit)
-	outmap := map[string]interface{}{
+	offset = whparse.OptInt(r.FormValue("offset"), 0)
+	limit = whparse.OptInt(r.FormValue("limit"), defaultLimit)
+	ctx := whcompat.Context(r)
+
+	var err error
+	switch rtype = searchRtype(r.FormValue("rtype")); rtype {
+	case "samples":
+		results, err = a.data.SearchSamplesContext(ctx, name,
+			a.parseFilters(r), offset, limit)
+	case "genesigs":
+		results, err = a.data.SearchGeneSigsContext(ctx, name, offset, limit)
+	case "genesets":
+		results, err = a.data.SearchGenesetsContext(ctx, name, offset, limit)
+	}
+	if err != nil {
+		whfatal.Error(err)
+	}
+	return rtype, offset, limit, results
+}
+
+func (a *Endpoints) Keyword(w http.ResponseWriter, r *http.Request) {
+	rtype, offset, limit, results := a.keywordSearch(r)
+	Render("results_"+rtype, map[string]interface{}{
 		"dataset":   a.data,
 		"page_urls": newPageURLs(r, offset, limit, -1),
 		"url_for_rtype": func(rtype string) string {
@@ -247,33 +444,6 @@ func (a *Endpoints) Keyword(w http.ResponseWriter, r *http.Request) {
 			v["rtype"] = []string{rtype}
 			return "?" + v.Encode()
 		},
-	}
-
-	switch r.FormValue("rtype") {
-	default:
-		whfatal.Error(
-			wherr.BadRequest.New("invalid rtype %q", r.FormValue("rtype")))
-	case "samples", "":
-		results, err := a.data.SearchSamples(name, a.parseFilters(r), offset,
-			limit)
-		if err != nil {
-			whfatal.Error(err)
-		}
-		outmap["results"] = results
-		Render("results_samples", outmap)
-	case "genesigs":
-		results, err := a.data.SearchGeneSigs(name, offset, limit)
-		if err != nil {
-			whfatal.Error(err)
-		}
-		outmap["results"] = results
-		Render("results_genesigs", outmap)
-	case "genesets":
-		results, err := a.data.SearchGenesets(name, offset, limit)
-		if err != nil {
-			whfatal.Error(err)
-		}
-		outmap["results"] = results
-		Render("results_genesets", outmap)
-	}
+		"results": results,
+	})
 }
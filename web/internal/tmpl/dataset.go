@@ -13,6 +13,9 @@ var _ = T.MustParse(`{{ template "header" . }}
 
 <h2>Search</h2>
 
+<p>To run many signature searches at once, submit a
+ <a href="/dataset/{{.Page.dataset.Id}}/jobs">batch job</a>.</p>
+
 <ul class="nav nav-tabs" role="tablist">
   <li role="presentation" class="active">
     <a href="#bysig" aria-controls="bysig" role="tab" data-toggle="tab">Signature</a>
@@ -47,6 +50,11 @@ var _ = T.MustParse(`{{ template "header" . }}
     <label for="filters"><strong>filters: </strong></label>
     <input type="text" name="filters" class="form-control" id="filters" />
   </div>
+  <div class="checkbox">
+    <label>
+      <input type="checkbox" name="exact" value="yes"> Exact search
+    </label>
+  </div>
   <div class="btn-group" data-toggle="buttons">
     <label class="btn btn-default active">
       <input type="radio" name="direct" value="yes"
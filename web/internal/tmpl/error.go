@@ -0,0 +1,14 @@
+// Copyright (C) 2017 JT Olds
+// See LICENSE for copying information
+
+package tmpl
+
+var _ = T.MustParse(`{{ template "header" . }}
+
+<h1>{{.Page.code}} {{.Page.status}}</h1>
+
+<div class="alert alert-danger" role="alert">{{.Page.message}}</div>
+
+<p><a href="javascript:history.back()">Go back</a></p>
+
+{{ template "footer" . }}`)
@@ -42,6 +42,7 @@ var _ = T.MustParse(`<!DOCTYPE html>
         <div id="navbar" class="navbar-collapse collapse">
           <ul class="nav navbar-nav navbar-left">
             <li><a href="/">Data</a></li>
+            <li><a href="/status">Status</a></li>
           </ul>
         </div>
       </div>
@@ -0,0 +1,63 @@
+// Copyright (C) 2017 JT Olds
+// See LICENSE for copying information
+
+package tmpl
+
+var _ = T.MustParse(`{{ template "header" . }}
+
+<h1>Dataset: <a href="/dataset/{{.Page.dataset.Id}}">{{.Page.dataset.Name}}</a></h1>
+<h2><a href="/dataset/{{.Page.dataset.Id}}/jobs">Batch job</a>: {{.Page.job.Id}}</h2>
+
+{{ $base := printf "/dataset/%v/job/%s" .Page.dataset.Id .Page.job.Id }}
+{{ with .Page.job }}
+<table class="table table-condensed">
+  <tr><th>Queries</th><td>{{.Name}} ({{.Queries}})</td></tr>
+  <tr><th>Against</th><td>{{.Rtype}}</td></tr>
+  <tr><th>Results per query</th><td>{{.K}}</td></tr>
+  <tr><th>Submitted</th>
+    <td>{{.Created.Format "2006-01-02 15:04:05 MST"}}</td></tr>
+  <tr><th>State</th><td id="job-state">{{.State}}</td></tr>
+</table>
+
+<div class="progress">
+  <div id="job-progress" class="progress-bar" role="progressbar"
+      style="width: {{.Percent}}%;">
+    <span id="job-done">{{.Done}}</span> of {{.Queries}} queries
+  </div>
+</div>
+
+<div id="job-error" class="alert alert-danger" role="alert"
+    {{ if not .Error }}style="display: none;"{{ end }}>{{.Error}}</div>
+
+<p id="job-results" {{ if ne .State "done" }}style="display: none;"{{ end }}>
+  Download results as
+  <a href="{{$base}}/results?format=tsv">TSV</a> or
+  <a href="{{$base}}/results?format=json">JSON</a>.
+</p>
+
+{{ if not .Ended }}
+<script>
+  var events = new EventSource("{{$base}}/events");
+  events.onmessage = function(e) {
+    var job = JSON.parse(e.data);
+    document.getElementById("job-state").textContent = job.state;
+    document.getElementById("job-done").textContent = job.done;
+    document.getElementById("job-progress").style.width =
+        Math.floor(job.done * 100 / job.queries) + "%";
+    if (job.error) {
+      var el = document.getElementById("job-error");
+      el.textContent = job.error;
+      el.style.display = "";
+    }
+    if (job.state == "done") {
+      document.getElementById("job-results").style.display = "";
+    }
+    if (job.state == "done" || job.state == "failed") {
+      events.close();
+    }
+  };
+</script>
+{{ end }}
+{{ end }}
+
+{{ template "footer" . }}`)
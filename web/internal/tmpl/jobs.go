@@ -0,0 +1,71 @@
+// Copyright (C) 2017 JT Olds
+// See LICENSE for copying information
+
+package tmpl
+
+var _ = T.MustParse(`{{ template "header" . }}
+
+<h1>Dataset: <a href="/dataset/{{.Page.dataset.Id}}">{{.Page.dataset.Name}}</a></h1>
+<h2>Batch jobs</h2>
+
+<div class="panel panel-default">
+  <div class="panel-body">
+
+<p>Upload a tab-separated file of queries, one per line, with a query name,
+ space-separated up-regulated genes, and space-separated down-regulated
+ genes. Results can be downloaded once the job is done.</p>
+
+<form method="POST" action="/dataset/{{.Page.dataset.Id}}/jobs"
+    enctype="multipart/form-data">
+<div class="row">
+<div class="col-md-12 form-inline">
+  <div class="form-group">
+    <input type="file" name="queries" class="form-control" />
+  </div>
+  <div class="form-group">
+    <label for="rtype"><strong>against: </strong></label>
+    <select name="rtype" class="form-control" id="rtype">
+      <option value="samples">Samples</option>
+      <option value="genesigs">Gene signatures</option>
+    </select>
+  </div>
+  <div class="form-group">
+    <label for="k"><strong>results per query: </strong></label>
+    <input type="number" name="k" value="10" min="0" class="form-control"
+        id="k" />
+  </div>
+  <button type="submit" class="btn btn-primary">Submit</button>
+</div>
+</div>
+</form>
+
+  </div>
+</div>
+
+{{ $page := .Page }}
+{{ if .Page.jobs }}
+<table class="table table-striped">
+  <tr>
+    <th>Job</th>
+    <th>Queries</th>
+    <th>Against</th>
+    <th>Results per query</th>
+    <th>Submitted</th>
+    <th>State</th>
+  </tr>
+  {{ range .Page.jobs }}
+  <tr>
+    <td><a href="/dataset/{{$page.dataset.Id}}/job/{{.Id}}">{{.Id}}</a></td>
+    <td>{{.Name}} ({{.Queries}})</td>
+    <td>{{.Rtype}}</td>
+    <td>{{.K}}</td>
+    <td>{{.Created.Format "2006-01-02 15:04:05 MST"}}</td>
+    <td>{{.State}}{{ if eq .State "running" }} ({{.Percent}}%){{ end }}</td>
+  </tr>
+  {{ end }}
+</table>
+{{ else }}
+<p>No batch jobs have been submitted.</p>
+{{ end }}
+
+{{ template "footer" . }}`)
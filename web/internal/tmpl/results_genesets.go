@@ -22,6 +22,12 @@ var _ = T.MustParse(`{{ template "header" . }}
 <div class="panel panel-default">
   <div class="panel-body">
 
+  {{ if .Page.query_id }}
+  <div style="float: left;">
+    Query id: <a href="{{.Page.query_url}}">{{.Page.query_id}}</a>
+  </div>
+  {{ end }}
+
   <div style="float: right;">
     {{.Page.page_urls.Render}}
   </div>
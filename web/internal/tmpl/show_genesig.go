@@ -15,6 +15,30 @@ var _ = T.MustParse(`{{ template "header" . }}
   </a>
 </div>
 
+{{ $Page := .Page }}
+{{ if or .Page.similar .Page.opposite }}
+<div class="row">
+  <div class="col-md-6">
+    <h3>Most similar</h3>
+    <ul>
+    {{ range .Page.similar }}
+    <li><a href="/dataset/{{$Page.dataset.Id}}/genesig/{{.Id}}">{{.Name}}</a>
+      ({{.Score}})</li>
+    {{ end }}
+    </ul>
+  </div>
+  <div class="col-md-6">
+    <h3>Most opposite</h3>
+    <ul>
+    {{ range .Page.opposite }}
+    <li><a href="/dataset/{{$Page.dataset.Id}}/genesig/{{.Id}}">{{.Name}}</a>
+      ({{.Score}})</li>
+    {{ end }}
+    </ul>
+  </div>
+</div>
+{{ end }}
+
 <table class="table table-striped">
 <tr>
   <th>Dimension</th>
@@ -0,0 +1,38 @@
+// Copyright (C) 2017 JT Olds
+// See LICENSE for copying information
+
+package tmpl
+
+var _ = T.MustParse(`{{ template "header" . }}
+
+<h1>Status</h1>
+
+<h2>Signature searches</h2>
+{{ with .Page.scans }}
+<table class="table table-condensed">
+  <tr><th>Running</th><td>{{.Running}} of {{.Slots}}</td></tr>
+  <tr><th>Waiting</th><td>{{.Queued}} of {{.MaxQueued}}</td></tr>
+  <tr><th>Admitted</th><td>{{.Admitted}}</td></tr>
+  <tr><th>Turned away</th><td>{{.Rejected}}</td></tr>
+  <tr><th>Recent average search time</th><td>{{.AvgScan}}</td></tr>
+</table>
+
+<h3>Clients</h3>
+{{ if .Clients }}
+<table class="table table-striped">
+  <tr><th>Client</th><th>Running</th><th>Waiting</th><th>Longest wait</th></tr>
+  {{ range .Clients }}
+  <tr>
+    <td>{{.Client}}</td>
+    <td>{{.Running}}</td>
+    <td>{{.Waiting}}</td>
+    <td>{{ if .Waiting }}{{.Oldest}}{{ end }}</td>
+  </tr>
+  {{ end }}
+</table>
+{{ else }}
+<p>No searches are running or waiting.</p>
+{{ end }}
+{{ end }}
+
+{{ template "footer" . }}`)
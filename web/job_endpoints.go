@@ -0,0 +1,252 @@
+// Copyright (C) 2017 JT Olds
+// See LICENSE for copying information
+
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+
+	"gopkg.in/webhelp.v1/whcompat"
+	"gopkg.in/webhelp.v1/wherr"
+	"gopkg.in/webhelp.v1/whfatal"
+	"gopkg.in/webhelp.v1/whlog"
+	"gopkg.in/webhelp.v1/whparse"
+)
+
+// maxJobUpload bounds the size of uploaded batch job queries.
+const maxJobUpload = 64 << 20
+
+func (a *Endpoints) job(r *http.Request) jobState {
+	id := jobId.Get(whcompat.Context(r))
+	state, _, ok := a.jobs.Get(id)
+	if !ok {
+		whfatal.Error(wherr.NotFound.New("job %q not found", id))
+	}
+	return state
+}
+
+// Jobs lists batch jobs, with a form to submit a new one.
+func (a *Endpoints) Jobs(w http.ResponseWriter, r *http.Request) {
+	if r.FormValue("format") == "json" {
+		writeJSON(w, a.jobs.List())
+		return
+	}
+	Render("jobs", map[string]interface{}{
+		"dataset": a.data,
+		"jobs":    a.jobs.List(),
+	})
+}
+
+// CreateJob submits a batch job. Queries come from an uploaded queries file,
+// or the queries form value if no file is uploaded, in the format of
+// lincsscore's -queries.
+func (a *Endpoints) CreateJob(w http.ResponseWriter, r *http.Request) {
+	r.Body = http.MaxBytesReader(w, r.Body, maxJobUpload)
+	name := "queries"
+	var queries io.Reader
+	fh, header, err := r.FormFile("queries")
+	switch err {
+	case nil:
+		defer fh.Close()
+		name = header.Filename
+		queries = fh
+	case http.ErrMissingFile:
+		queries = strings.NewReader(r.FormValue("queries"))
+	default:
+		whfatal.Error(wherr.BadRequest.Wrap(err))
+	}
+
+	rtype := r.FormValue("rtype")
+	if rtype == "" {
+		rtype = "samples"
+	}
+	state, err := a.jobs.Create(name, rtype,
+		whparse.OptInt(r.FormValue("k"), defaultLimit), queries)
+	if err != nil {
+		whfatal.Error(err)
+	}
+	if r.FormValue("format") == "json" {
+		w.WriteHeader(http.StatusCreated)
+		writeJSON(w, state)
+		return
+	}
+	whfatal.Redirect("job/" + state.Id)
+}
+
+// Job shows a batch job's progress.
+func (a *Endpoints) Job(w http.ResponseWriter, r *http.Request) {
+	state := a.job(r)
+	if r.FormValue("format") == "json" {
+		writeJSON(w, state)
+		return
+	}
+	Render("job", map[string]interface{}{
+		"dataset": a.data,
+		"job":     state,
+	})
+}
+
+// JobEvents streams a batch job's state as server-sent events, one event
+// per change, until the job ends or the client goes away. Streams aren't
+// subject to the request timeout.
+func (a *Endpoints) JobEvents(w http.ResponseWriter, r *http.Request) {
+	id := a.job(r).Id
+	ctx := whcompat.Context(r)
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	for {
+		state, changed, _ := a.jobs.Get(id)
+		data, err := json.Marshal(state)
+		if err != nil {
+			whfatal.Error(err)
+		}
+		_, err = fmt.Fprintf(w, "data: %s\n\n", data)
+		if err != nil {
+			return
+		}
+		if f, ok := w.(http.Flusher); ok {
+			f.Flush()
+		}
+		if state.Ended() {
+			return
+		}
+		select {
+		case <-changed:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// JobResults downloads a finished batch job's results, as tsv by default or
+// as a json list if format is json.
+func (a *Endpoints) JobResults(w http.ResponseWriter, r *http.Request) {
+	state := a.job(r)
+	if state.State != jobDone {
+		whfatal.Error(wherr.BadRequest.New("job %s is %s, not done", state.Id,
+			state.State))
+	}
+	fh, err := os.Open(a.jobs.ResultsPath(state.Id))
+	if err != nil {
+		whfatal.Error(err)
+	}
+	defer fh.Close()
+
+	switch r.FormValue("format") {
+	default:
+		whfatal.Error(
+			wherr.BadRequest.New("invalid format %q", r.FormValue("format")))
+	case "tsv", "":
+		w.Header().Set("Content-Type", "text/tab-separated-values")
+		w.Header().Set("Content-Disposition",
+			fmt.Sprintf("attachment; filename=\"job-%s.tsv\"", state.Id))
+		stat, err := fh.Stat()
+		if err != nil {
+			whfatal.Error(err)
+		}
+		http.ServeContent(w, r, "", stat.ModTime(), fh)
+	case "json":
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Content-Disposition",
+			fmt.Sprintf("attachment; filename=\"job-%s.json\"", state.Id))
+		err = writeResultsJSON(w, fh)
+		if err != nil {
+			// the response has started, so all that's left is to log it.
+			whlog.Default("error: %s: %v", r.URL, err)
+		}
+	}
+}
+
+type jobResult struct {
+	Query string  `json:"query"`
+	Rank  int     `json:"rank"`
+	Id    string  `json:"id"`
+	Name  string  `json:"name"`
+	Score float64 `json:"score"`
+}
+
+// parseResult parses a line of tsv score output.
+func parseResult(line string) (rv jobResult, err error) {
+	parts := strings.Split(line, "\t")
+	if len(parts) != 5 {
+		return rv, fmt.Errorf("malformed result line %q", line)
+	}
+	rank, err := strconv.Atoi(parts[1])
+	if err != nil {
+		return rv, fmt.Errorf("malformed result line %q: %v", line, err)
+	}
+	score, err := strconv.ParseFloat(parts[4], 64)
+	if err != nil {
+		return rv, fmt.Errorf("malformed result line %q: %v", line, err)
+	}
+	return jobResult{Query: parts[0], Rank: rank, Id: parts[2],
+		Name: parts[3], Score: score}, nil
+}
+
+// checkResults makes sure every line of tsv score output, after the header
+// if header is true, parses, so it can be converted to json later.
+func checkResults(results []byte, header bool) error {
+	scanner := bufio.NewScanner(bytes.NewReader(results))
+	scanner.Buffer(nil, 10*1024*1024)
+	for ; scanner.Scan(); header = false {
+		if header {
+			continue
+		}
+		_, err := parseResult(scanner.Text())
+		if err != nil {
+			return err
+		}
+	}
+	return scanner.Err()
+}
+
+// writeResultsJSON converts tsv score output to a json list of results. The
+// output was checked with checkResults as it was written, so once the
+// response has started, only I/O can fail.
+func writeResultsJSON(w io.Writer, r io.Reader) error {
+	bw := bufio.NewWriter(w)
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(nil, 10*1024*1024)
+	first := true
+	_, err := bw.WriteString("[")
+	if err != nil {
+		return err
+	}
+	for header := true; scanner.Scan(); header = false {
+		if header {
+			continue
+		}
+		result, err := parseResult(scanner.Text())
+		if err != nil {
+			return err
+		}
+		data, err := json.Marshal(result)
+		if err != nil {
+			return err
+		}
+		if !first {
+			bw.WriteString(",")
+		}
+		first = false
+		_, err = bw.Write(data)
+		if err != nil {
+			return err
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+	_, err = bw.WriteString("]\n")
+	if err != nil {
+		return err
+	}
+	return bw.Flush()
+}
@@ -0,0 +1,473 @@
+// Copyright (C) 2017 JT Olds
+// See LICENSE for copying information
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"flag"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/jtolds/golincs/web/dbs"
+	"gopkg.in/webhelp.v1/wherr"
+	"gopkg.in/webhelp.v1/whlog"
+)
+
+var (
+	jobsDir = flag.String("jobs_dir", "jobs",
+		"directory to keep batch jobs and their results in. unfinished jobs "+
+			"found here are resumed on startup")
+	jobChunk = flag.Int("job_chunk", 64,
+		"most queries a batch job runs at a time. progress is saved after "+
+			"each chunk")
+)
+
+// jobChunkResults bounds how many results a chunk of a batch job holds in
+// memory, for jobs that ask for many results per query.
+const jobChunkResults = 100000
+
+const (
+	jobQueued  = "queued"
+	jobRunning = "running"
+	jobDone    = "done"
+	jobFailed  = "failed"
+)
+
+// jobState is everything saved about a batch job. It's also what clients
+// polling the job see.
+type jobState struct {
+	Id      string `json:"id"`
+	Name    string `json:"name"`
+	Rtype   string `json:"rtype"`
+	K       int    `json:"k"`
+	State   string `json:"state"`
+	Error   string `json:"error,omitempty"`
+	Queries int    `json:"queries"`
+	Done    int    `json:"done"`
+	// ResultBytes is how much of the results file is from finished chunks.
+	// Anything past it is from an interrupted chunk and is discarded when the
+	// job resumes.
+	ResultBytes int64     `json:"result_bytes"`
+	Created     time.Time `json:"created"`
+	Started     time.Time `json:"started"`
+	Finished    time.Time `json:"finished"`
+}
+
+// Ended is true if the job won't make any more progress.
+func (s jobState) Ended() bool {
+	return s.State == jobDone || s.State == jobFailed
+}
+
+// Percent is how far along the job is.
+func (s jobState) Percent() int {
+	if s.Queries == 0 {
+		return 0
+	}
+	return s.Done * 100 / s.Queries
+}
+
+type job struct {
+	state jobState
+	// changed is closed and replaced whenever state changes.
+	changed chan struct{}
+}
+
+// jobManager runs batch jobs for a dataset one at a time, in the order they
+// were submitted. Each job lives in its own directory under dir, with its
+// uploaded queries, its results so far as tsv, and its state as json, so
+// jobs pick up where they left off if the server restarts.
+type jobManager struct {
+	dir   string
+	data  dbs.Dataset
+	scans *scheduler
+
+	mtx     sync.Mutex
+	jobs    map[string]*job
+	pending []*job
+	wake    chan struct{}
+}
+
+func newJobManager(dir string, data dbs.Dataset,
+	scans *scheduler) *jobManager {
+	return &jobManager{
+		dir:   dir,
+		data:  data,
+		scans: scans,
+		jobs:  map[string]*job{},
+		wake:  make(chan struct{}, 1),
+	}
+}
+
+func (m *jobManager) jobPath(id, name string) string {
+	return filepath.Join(m.dir, id, name)
+}
+
+// Start loads the jobs saved in the job directory, and starts running the
+// ones that hadn't finished.
+func (m *jobManager) Start() error {
+	err := os.MkdirAll(m.dir, 0755)
+	if err != nil {
+		return err
+	}
+	entries, err := ioutil.ReadDir(m.dir)
+	if err != nil {
+		return err
+	}
+
+	m.mtx.Lock()
+	for _, entry := range entries {
+		if !entry.IsDir() {
+			continue
+		}
+		data, err := ioutil.ReadFile(m.jobPath(entry.Name(), "job.json"))
+		if err != nil {
+			whlog.Default("skipping job %s: %v", entry.Name(), err)
+			continue
+		}
+		j := &job{changed: make(chan struct{})}
+		err = json.Unmarshal(data, &j.state)
+		if err != nil {
+			whlog.Default("skipping job %s: %v", entry.Name(), err)
+			continue
+		}
+		m.jobs[j.state.Id] = j
+		if !j.state.Ended() {
+			j.state.State = jobQueued
+			m.pending = append(m.pending, j)
+		}
+	}
+	sort.Slice(m.pending, func(i, j int) bool {
+		return m.pending[i].state.Created.Before(m.pending[j].state.Created)
+	})
+	if len(m.pending) > 0 {
+		whlog.Default("resuming %d batch jobs in %s", len(m.pending), m.dir)
+	}
+	m.mtx.Unlock()
+
+	go m.run()
+	m.notify()
+	return nil
+}
+
+func (m *jobManager) notify() {
+	select {
+	case m.wake <- struct{}{}:
+	default:
+	}
+}
+
+func newJobId() (string, error) {
+	var buf [8]byte
+	_, err := rand.Read(buf[:])
+	if err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(buf[:]), nil
+}
+
+// Create validates and saves a new job scoring the queries in r, in the
+// format dbs.ParseQueryLines reads, against samples or gene signatures. It
+// keeps the best k results per query, or every result if k is 0.
+func (m *jobManager) Create(name, rtype string, k int, r io.Reader) (
+	jobState, error) {
+	total := m.data.Samples()
+	switch rtype {
+	default:
+		return jobState{}, wherr.BadRequest.New("invalid rtype %q", rtype)
+	case "samples":
+	case "genesigs":
+		total = m.data.GeneSigs()
+	}
+	if k < 0 {
+		return jobState{}, wherr.BadRequest.New("invalid k %d", k)
+	}
+	if k == 0 || k > total {
+		k = total
+	}
+
+	queries, err := ioutil.ReadAll(r)
+	if err != nil {
+		return jobState{}, err
+	}
+	_, parsed, err := dbs.ParseQueryLines(m.data, bytes.NewReader(queries), name)
+	if err != nil {
+		return jobState{}, wherr.BadRequest.Wrap(err)
+	}
+	if len(parsed) == 0 {
+		return jobState{}, wherr.BadRequest.New("no queries provided")
+	}
+
+	id, err := newJobId()
+	if err != nil {
+		return jobState{}, err
+	}
+	j := &job{
+		state: jobState{
+			Id:      id,
+			Name:    name,
+			Rtype:   rtype,
+			K:       k,
+			State:   jobQueued,
+			Queries: len(parsed),
+			Created: time.Now().UTC(),
+		},
+		changed: make(chan struct{}),
+	}
+	err = os.MkdirAll(filepath.Join(m.dir, id), 0755)
+	if err != nil {
+		return jobState{}, err
+	}
+	err = ioutil.WriteFile(m.jobPath(id, "queries.tsv"), queries, 0644)
+	if err != nil {
+		return jobState{}, err
+	}
+	err = m.save(j.state)
+	if err != nil {
+		return jobState{}, err
+	}
+
+	m.mtx.Lock()
+	m.jobs[id] = j
+	m.pending = append(m.pending, j)
+	m.mtx.Unlock()
+	m.notify()
+	return j.state, nil
+}
+
+// save writes a job's state, replacing the old state all at once.
+func (m *jobManager) save(state jobState) error {
+	data, err := json.MarshalIndent(state, "", "  ")
+	if err != nil {
+		return err
+	}
+	path := m.jobPath(state.Id, "job.json")
+	err = ioutil.WriteFile(path+".tmp", data, 0644)
+	if err != nil {
+		return err
+	}
+	return os.Rename(path+".tmp", path)
+}
+
+// update changes a job's state, saves it, and wakes anyone watching it.
+func (m *jobManager) update(j *job, fn func(state *jobState)) error {
+	m.mtx.Lock()
+	fn(&j.state)
+	state := j.state
+	close(j.changed)
+	j.changed = make(chan struct{})
+	m.mtx.Unlock()
+	return m.save(state)
+}
+
+// Get returns a job's state, and a channel that's closed when it changes.
+func (m *jobManager) Get(id string) (state jobState,
+	changed <-chan struct{}, ok bool) {
+	m.mtx.Lock()
+	defer m.mtx.Unlock()
+	j, ok := m.jobs[id]
+	if !ok {
+		return jobState{}, nil, false
+	}
+	return j.state, j.changed, true
+}
+
+// List returns every job, newest first.
+func (m *jobManager) List() []jobState {
+	m.mtx.Lock()
+	rv := make([]jobState, 0, len(m.jobs))
+	for _, j := range m.jobs {
+		rv = append(rv, j.state)
+	}
+	m.mtx.Unlock()
+	sort.Slice(rv, func(i, j int) bool {
+		return rv[i].Created.After(rv[j].Created)
+	})
+	return rv
+}
+
+// ResultsPath is where a job's tsv results are written, in the format of
+// lincsscore's tsv output.
+func (m *jobManager) ResultsPath(id string) string {
+	return m.jobPath(id, "results.tsv")
+}
+
+func (m *jobManager) run() {
+	for range m.wake {
+		for {
+			m.mtx.Lock()
+			if len(m.pending) == 0 {
+				m.mtx.Unlock()
+				break
+			}
+			j := m.pending[0]
+			m.pending = m.pending[1:]
+			m.mtx.Unlock()
+
+			err := m.runJob(j)
+			if err != nil {
+				whlog.Default("batch job %s failed: %v", j.state.Id, err)
+				err = m.update(j, func(state *jobState) {
+					state.State = jobFailed
+					state.Error = err.Error()
+					state.Finished = time.Now().UTC()
+				})
+				if err != nil {
+					whlog.Default("failed saving job %s: %v", j.state.Id, err)
+				}
+			}
+		}
+	}
+}
+
+func (m *jobManager) runJob(j *job) (err error) {
+	err = m.update(j, func(state *jobState) {
+		state.State = jobRunning
+		if state.Started.IsZero() {
+			state.Started = time.Now().UTC()
+		}
+	})
+	if err != nil {
+		return err
+	}
+	m.mtx.Lock()
+	state := j.state
+	m.mtx.Unlock()
+
+	fh, err := os.Open(m.jobPath(state.Id, "queries.tsv"))
+	if err != nil {
+		return err
+	}
+	names, queries, err := dbs.ParseQueryLines(m.data, fh, state.Name)
+	fh.Close()
+	if err != nil {
+		return err
+	}
+
+	out, err := os.OpenFile(m.ResultsPath(state.Id), os.O_WRONLY|os.O_CREATE,
+		0644)
+	if err != nil {
+		return err
+	}
+	defer out.Close()
+	err = out.Truncate(state.ResultBytes)
+	if err != nil {
+		return err
+	}
+	_, err = out.Seek(state.ResultBytes, io.SeekStart)
+	if err != nil {
+		return err
+	}
+
+	chunk := *jobChunk
+	if state.K > 0 && jobChunkResults/state.K < chunk {
+		chunk = jobChunkResults / state.K
+	}
+	if chunk < 1 {
+		chunk = 1
+	}
+
+	written := state.ResultBytes
+	for done := state.Done; done < len(queries); {
+		end := done + chunk
+		if end > len(queries) {
+			end = len(queries)
+		}
+		var buf bytes.Buffer
+		if written == 0 {
+			buf.WriteString(dbs.ScoreHeader)
+		}
+		err = m.runChunk(&buf, state, names[done:end], queries[done:end])
+		if err != nil {
+			return err
+		}
+		err = checkResults(buf.Bytes(), written == 0)
+		if err != nil {
+			return err
+		}
+		_, err = out.Write(buf.Bytes())
+		if err != nil {
+			return err
+		}
+		err = out.Sync()
+		if err != nil {
+			return err
+		}
+		written += int64(buf.Len())
+		done = end
+
+		err = m.update(j, func(state *jobState) {
+			state.Done = done
+			state.ResultBytes = written
+			if done == len(queries) {
+				state.State = jobDone
+				state.Finished = time.Now().UTC()
+			}
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return out.Close()
+}
+
+// runChunk scores some of a job's queries, taking turns with interactive
+// searches, and writes the results to w.
+func (m *jobManager) runChunk(w io.Writer, state jobState, names []string,
+	queries [][]dbs.Dimension) error {
+	ctx := context.Background()
+	var release func()
+	for {
+		var err error
+		release, err = m.scans.Acquire(ctx, "job "+state.Id)
+		if err == nil {
+			break
+		}
+		if !errQueueFull.Contains(err) {
+			return err
+		}
+		time.Sleep(m.scans.RetryAfter())
+	}
+	defer release()
+
+	if state.Rtype == "genesigs" {
+		results, err := m.data.NearestGeneSigsBatchContext(ctx, queries, state.K)
+		if err != nil {
+			return err
+		}
+		for q, hits := range results {
+			for rank, hit := range hits {
+				err = dbs.WriteScore(w, names[q], rank, hit.Id(), hit.Name(),
+					hit.Score())
+				if err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	}
+
+	results, err := m.data.NearestSamplesBatchContext(ctx, queries, state.K)
+	if err != nil {
+		return err
+	}
+	for q, hits := range results {
+		for rank, hit := range hits {
+			err = dbs.WriteScore(w, names[q], rank, hit.Id(), hit.Name(),
+				hit.Score())
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
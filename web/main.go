@@ -8,9 +8,11 @@ import (
 	"fmt"
 	"net/http"
 	"os"
+	"path/filepath"
 
 	"github.com/jtolds/golincs/web/dbs"
 	"github.com/jtolds/golincs/web/dbs/lincs_gse92742_v0"
+	"gopkg.in/webhelp.v1/wherr"
 	"gopkg.in/webhelp.v1/whfatal"
 	"gopkg.in/webhelp.v1/whlog"
 	"gopkg.in/webhelp.v1/whmux"
@@ -24,6 +26,7 @@ var (
 	sampleId  = whmux.NewStringArg()
 	geneSigId = whmux.NewStringArg()
 	genesetId = whmux.NewStringArg()
+	jobId     = whmux.NewStringArg()
 )
 
 func main() {
@@ -36,38 +39,77 @@ func main() {
 
 	datasetMux := whmux.Dir{"": whredir.RedirectHandler("/")}
 	datasets := []dbs.Dataset{lincs_92742}
+	apiMux := whmux.Dir{"": whmux.Exact(apiDatasets(datasets))}
+	// searches on every dataset compete for the same cores, so they share a
+	// scheduler.
+	scans := newScheduler(*concurrentScans, *scanQueue)
+	var jobs []*jobManager
 	for id, dataset := range datasets {
+		dataset_jobs := newJobManager(filepath.Join(*jobsDir, fmt.Sprint(id)),
+			dataset, scans)
+		jobs = append(jobs, dataset_jobs)
 		endpoints := NewEndpoints(struct {
 			dbs.Dataset
 			Id int
-		}{Dataset: dataset, Id: id})
+		}{Dataset: dataset, Id: id}, scans, dataset_jobs)
 
 		datasetMux[fmt.Sprint(id)] = whmux.Dir{
-			"": whmux.Exact(http.HandlerFunc(endpoints.Dataset)),
+			"": withTimeout(whmux.Exact(http.HandlerFunc(endpoints.Dataset))),
 
-			"sample": sampleId.Shift(
-				whmux.Exact(http.HandlerFunc(endpoints.Sample))),
-			"genesig": geneSigId.Shift(
-				whmux.Exact(http.HandlerFunc(endpoints.GeneSig))),
-			"geneset": genesetId.Shift(
-				whmux.Exact(http.HandlerFunc(endpoints.Geneset))),
+			"sample": withTimeout(sampleId.Shift(
+				whmux.Exact(http.HandlerFunc(endpoints.Sample)))),
+			"genesig": withTimeout(geneSigId.Shift(
+				whmux.Exact(http.HandlerFunc(endpoints.GeneSig)))),
+			"geneset": withTimeout(genesetId.Shift(
+				whmux.Exact(http.HandlerFunc(endpoints.Geneset)))),
 
-			"search": whmux.Dir{
+			"search": withTimeout(whmux.Dir{
 				"keyword":   whmux.Exact(http.HandlerFunc(endpoints.Keyword)),
 				"signature": whmux.Exact(http.HandlerFunc(endpoints.Signature)),
-			},
+			}),
+
+			"jobs": withTimeout(whmux.Exact(whmux.Method{
+				"GET":  http.HandlerFunc(endpoints.Jobs),
+				"POST": http.HandlerFunc(endpoints.CreateJob),
+			})),
+			// a job's event stream lasts as long as the job does, and its
+			// results download as long as the client takes, so they don't get
+			// the request timeout.
+			"job": jobId.Shift(whmux.Dir{
+				"": withTimeout(
+					whmux.Exact(http.HandlerFunc(endpoints.Job))),
+				"events":  whmux.Exact(http.HandlerFunc(endpoints.JobEvents)),
+				"results": whmux.Exact(http.HandlerFunc(endpoints.JobResults)),
+			}),
 		}
+		apiMux[fmt.Sprint(id)] = endpoints.APIRoutes(id)
 	}
 
-	routes := whlog.LogRequests(whlog.Default, whfatal.Catch(whmux.Dir{
-		"": whmux.Exact(http.HandlerFunc(
-			func(w http.ResponseWriter, r *http.Request) {
-				Render("datasets", map[string]interface{}{"datasets": datasets})
+	routes := whlog.LogRequests(whlog.Default, wherr.HandleWith(
+		wherr.HandlerFunc(handleError), whfatal.Catch(whmux.Dir{
+			"": withTimeout(whmux.Exact(http.HandlerFunc(
+				func(w http.ResponseWriter, r *http.Request) {
+					Render("datasets", map[string]interface{}{
+						"datasets": datasets})
+				}))),
+			"dataset": datasetMux,
+			"api": withTimeout(apiHandler(whmux.Dir{
+				"v1": whmux.Dir{"datasets": apiMux},
 			})),
-		"dataset": datasetMux,
-	}))
+			"status": withTimeout(whmux.Exact(http.HandlerFunc(
+				func(w http.ResponseWriter, r *http.Request) {
+					Render("status", map[string]interface{}{
+						"scans": scans.Status()})
+				}))),
+		})))
 	switch flag.Arg(0) {
 	case "serve":
+		for _, dataset_jobs := range jobs {
+			err = dataset_jobs.Start()
+			if err != nil {
+				panic(err)
+			}
+		}
 		panic(whlog.ListenAndServe(*listenAddr, routes))
 	case "routes":
 		whroute.PrintRoutes(os.Stdout, routes)
@@ -0,0 +1,288 @@
+// Copyright (C) 2017 JT Olds
+// See LICENSE for copying information
+
+package main
+
+import (
+	"context"
+	"flag"
+	"net"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"gopkg.in/webhelp.v1/wherr"
+)
+
+var (
+	concurrentScans = flag.Int("concurrent_scans", 2,
+		"number of signature searches that may run at once. each search "+
+			"already uses every core, so this should stay small")
+	scanQueue = flag.Int("scan_queue", 16,
+		"number of signature searches that may wait for a turn. searches past "+
+			"this are turned away with a 503")
+	clientHeader = flag.String("client_header", "",
+		"if set, the request header, such as X-Forwarded-For, that identifies "+
+			"clients for fair queueing. by default the remote address is used")
+
+	errQueueFull = wherr.ServiceUnavailable.NewClass("Queue Full")
+)
+
+// clientKey identifies who a request is from, so the scheduler can take
+// turns between clients.
+func clientKey(r *http.Request) string {
+	if *clientHeader != "" {
+		if val := r.Header.Get(*clientHeader); val != "" {
+			return strings.TrimSpace(strings.Split(val, ",")[0])
+		}
+	}
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+type ticket struct {
+	client  string
+	queued  time.Time
+	ready   chan struct{}
+	granted bool
+}
+
+// scheduler limits how many expensive searches run at once. Searches past
+// the limit wait in a bounded queue, and turns are handed out round robin
+// between clients, so one client submitting many searches can't starve the
+// rest.
+type scheduler struct {
+	slots     int
+	maxQueued int
+
+	mtx      sync.Mutex
+	running  map[string]int
+	waiting  map[string][]*ticket
+	order    []string
+	queued   int
+	admitted int64
+	rejected int64
+	avgScan  time.Duration
+}
+
+func newScheduler(slots, max_queued int) *scheduler {
+	if slots < 1 {
+		slots = 1
+	}
+	return &scheduler{
+		slots:     slots,
+		maxQueued: max_queued,
+		running:   map[string]int{},
+		waiting:   map[string][]*ticket{},
+	}
+}
+
+func (s *scheduler) runningCount() (total int) {
+	for _, count := range s.running {
+		total += count
+	}
+	return total
+}
+
+// Acquire waits for a turn for client, and returns a func to call once the
+// search is done. It fails with errQueueFull if too many searches are
+// already waiting, or with ctx's error if ctx is done first.
+func (s *scheduler) Acquire(ctx context.Context, client string) (
+	release func(), err error) {
+	s.mtx.Lock()
+	if s.queued == 0 && s.runningCount() < s.slots {
+		s.start(client)
+		s.mtx.Unlock()
+		return s.releaser(client), nil
+	}
+	if s.queued >= s.maxQueued {
+		s.rejected++
+		retry := s.retryAfter()
+		s.mtx.Unlock()
+		return nil, errQueueFull.New("too many searches are waiting. try "+
+			"again in %v", retry)
+	}
+	t := &ticket{
+		client: client,
+		queued: time.Now(),
+		ready:  make(chan struct{})}
+	if len(s.waiting[client]) == 0 {
+		s.order = append(s.order, client)
+	}
+	s.waiting[client] = append(s.waiting[client], t)
+	s.queued++
+	s.mtx.Unlock()
+
+	select {
+	case <-t.ready:
+		return s.releaser(client), nil
+	case <-ctx.Done():
+	}
+
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	if t.granted {
+		// the turn came at the same time as the cancellation. pass it on.
+		s.finish(client, 0)
+	} else {
+		s.dequeue(t)
+	}
+	return nil, ctx.Err()
+}
+
+func (s *scheduler) releaser(client string) func() {
+	started := time.Now()
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			s.mtx.Lock()
+			defer s.mtx.Unlock()
+			s.finish(client, time.Since(started))
+		})
+	}
+}
+
+func (s *scheduler) start(client string) {
+	s.running[client]++
+	s.admitted++
+}
+
+// finish frees client's slot, records how long it was held, and hands it to
+// the next waiting search. s.mtx must be held.
+func (s *scheduler) finish(client string, took time.Duration) {
+	s.running[client]--
+	if s.running[client] <= 0 {
+		delete(s.running, client)
+	}
+	if took > 0 {
+		if s.avgScan == 0 {
+			s.avgScan = took
+		} else {
+			s.avgScan = (s.avgScan*7 + took) / 8
+		}
+	}
+	s.dispatch()
+}
+
+// dispatch grants free slots to waiting searches, taking the oldest search
+// from each client in turn. s.mtx must be held.
+func (s *scheduler) dispatch() {
+	for s.queued > 0 && s.runningCount() < s.slots {
+		client := s.order[0]
+		s.order = s.order[1:]
+		tickets := s.waiting[client]
+		t := tickets[0]
+		if len(tickets) > 1 {
+			s.waiting[client] = tickets[1:]
+			s.order = append(s.order, client)
+		} else {
+			delete(s.waiting, client)
+		}
+		s.queued--
+		s.start(client)
+		t.granted = true
+		close(t.ready)
+	}
+}
+
+// dequeue removes a ticket that gave up waiting. s.mtx must be held.
+func (s *scheduler) dequeue(t *ticket) {
+	tickets := s.waiting[t.client]
+	for i, other := range tickets {
+		if other == t {
+			tickets = append(tickets[:i:i], tickets[i+1:]...)
+			break
+		}
+	}
+	s.queued--
+	if len(tickets) > 0 {
+		s.waiting[t.client] = tickets
+		return
+	}
+	delete(s.waiting, t.client)
+	for i, client := range s.order {
+		if client == t.client {
+			s.order = append(s.order[:i:i], s.order[i+1:]...)
+			break
+		}
+	}
+}
+
+// RetryAfter estimates how long until the queue has room, from the recent
+// average search time.
+func (s *scheduler) RetryAfter() time.Duration {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	return s.retryAfter()
+}
+
+func (s *scheduler) retryAfter() time.Duration {
+	estimate := s.avgScan * time.Duration(s.queued/s.slots+1)
+	if estimate < time.Second {
+		return time.Second
+	}
+	return estimate.Round(time.Second)
+}
+
+type clientStatus struct {
+	Client  string
+	Running int
+	Waiting int
+	Oldest  time.Duration
+}
+
+type schedulerStatus struct {
+	Slots     int
+	MaxQueued int
+	Running   int
+	Queued    int
+	Admitted  int64
+	Rejected  int64
+	AvgScan   time.Duration
+	Clients   []clientStatus
+}
+
+// Status returns a snapshot of the scheduler for the status page.
+func (s *scheduler) Status() schedulerStatus {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	status := schedulerStatus{
+		Slots:     s.slots,
+		MaxQueued: s.maxQueued,
+		Running:   s.runningCount(),
+		Queued:    s.queued,
+		Admitted:  s.admitted,
+		Rejected:  s.rejected,
+		AvgScan:   s.avgScan.Round(time.Millisecond),
+	}
+	clients := map[string]*clientStatus{}
+	get := func(client string) *clientStatus {
+		if cs, ok := clients[client]; ok {
+			return cs
+		}
+		cs := &clientStatus{Client: client}
+		clients[client] = cs
+		return cs
+	}
+	for client, count := range s.running {
+		get(client).Running = count
+	}
+	now := time.Now()
+	for client, tickets := range s.waiting {
+		cs := get(client)
+		cs.Waiting = len(tickets)
+		cs.Oldest = now.Sub(tickets[0].queued).Round(time.Millisecond)
+	}
+	for _, cs := range clients {
+		status.Clients = append(status.Clients, *cs)
+	}
+	sort.Slice(status.Clients, func(i, j int) bool {
+		return status.Clients[i].Client < status.Clients[j].Client
+	})
+	return status
+}
@@ -0,0 +1,167 @@
+// Copyright (C) 2017 JT Olds
+// See LICENSE for copying information
+
+package main
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+)
+
+// waitQueued waits until n searches are waiting in s.
+func waitQueued(t *testing.T, s *scheduler, n int) {
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		s.mtx.Lock()
+		queued := s.queued
+		s.mtx.Unlock()
+		if queued == n {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("%d searches queued, expected %d", queued, n)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+// checkIdle makes sure s holds no slots or waiting searches.
+func checkIdle(t *testing.T, s *scheduler) {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	if s.runningCount() != 0 || s.queued != 0 || len(s.waiting) != 0 ||
+		len(s.order) != 0 {
+		t.Fatalf("scheduler not idle: running %v, queued %d, waiting %v, "+
+			"order %v", s.running, s.queued, s.waiting, s.order)
+	}
+}
+
+type grant struct {
+	client  string
+	release func()
+}
+
+// queue starts a search for client that sends its turn to granted, and
+// waits until it's queued behind the n-1 searches already waiting.
+func queue(t *testing.T, s *scheduler, client string, n int,
+	granted chan<- grant) {
+	go func() {
+		release, err := s.Acquire(context.Background(), client)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		granted <- grant{client: client, release: release}
+	}()
+	waitQueued(t, s, n)
+}
+
+func TestSchedulerFairness(t *testing.T) {
+	s := newScheduler(1, 10)
+	release, err := s.Acquire(context.Background(), "hog")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	granted := make(chan grant)
+	for i, client := range []string{"a", "a", "a", "b"} {
+		queue(t, s, client, i+1, granted)
+	}
+
+	// b queued last, but gets the second turn instead of waiting behind all
+	// of a's searches.
+	var order []string
+	for i := 0; i < 4; i++ {
+		release()
+		g := <-granted
+		order = append(order, g.client)
+		release = g.release
+	}
+	release()
+	if got := strings.Join(order, ""); got != "abaa" {
+		t.Fatalf("turns went to %q, expected %q", got, "abaa")
+	}
+	checkIdle(t, s)
+}
+
+func TestSchedulerQueueFull(t *testing.T) {
+	s := newScheduler(1, 1)
+	release, err := s.Acquire(context.Background(), "a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	granted := make(chan grant)
+	queue(t, s, "a", 1, granted)
+
+	_, err = s.Acquire(context.Background(), "b")
+	if !errQueueFull.Contains(err) {
+		t.Fatalf("expected a full queue, got %v", err)
+	}
+	if status := s.Status(); status.Rejected != 1 || status.Queued != 1 {
+		t.Fatalf("unexpected status %+v", status)
+	}
+
+	release()
+	(<-granted).release()
+	checkIdle(t, s)
+}
+
+func TestSchedulerCancelWaiting(t *testing.T) {
+	s := newScheduler(1, 10)
+	release, err := s.Acquire(context.Background(), "a")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	errs := make(chan error)
+	go func() {
+		_, err := s.Acquire(ctx, "b")
+		errs <- err
+	}()
+	waitQueued(t, s, 1)
+	cancel()
+	if err := <-errs; err != context.Canceled {
+		t.Fatalf("expected cancellation, got %v", err)
+	}
+	waitQueued(t, s, 0)
+
+	// the canceled search gave up its place, so the slot goes straight to the
+	// next search once it's free.
+	release()
+	checkIdle(t, s)
+	release, err = s.Acquire(context.Background(), "c")
+	if err != nil {
+		t.Fatal(err)
+	}
+	release()
+	checkIdle(t, s)
+}
+
+func TestSchedulerCancelGranted(t *testing.T) {
+	s := newScheduler(1, 10)
+	// a waiting search canceled just as it's granted a turn must either take
+	// the turn or pass it on. either way, no slot is lost.
+	for i := 0; i < 200; i++ {
+		release, err := s.Acquire(context.Background(), "a")
+		if err != nil {
+			t.Fatal(err)
+		}
+		ctx, cancel := context.WithCancel(context.Background())
+		done := make(chan func())
+		go func() {
+			release, err := s.Acquire(ctx, "b")
+			if err != nil {
+				release = func() {}
+			}
+			done <- release
+		}()
+		waitQueued(t, s, 1)
+		go cancel()
+		release()
+		(<-done)()
+		checkIdle(t, s)
+	}
+}
@@ -0,0 +1,80 @@
+// Copyright (C) 2017 JT Olds
+// See LICENSE for copying information
+
+package main
+
+import (
+	"context"
+	"flag"
+	"net/http"
+	"time"
+
+	"github.com/jtolds/golincs/web/internal/tmpl"
+	"gopkg.in/webhelp.v1/whcompat"
+	"gopkg.in/webhelp.v1/wherr"
+	"gopkg.in/webhelp.v1/whfatal"
+	"gopkg.in/webhelp.v1/whlog"
+	"gopkg.in/webhelp.v1/whroute"
+)
+
+var requestTimeout = flag.Duration("request_timeout", 30*time.Second,
+	"how long a request may run before its queries are cancelled. 0 means "+
+		"no limit")
+
+// withTimeout gives every request to h a deadline of -request_timeout.
+// Dataset queries take the request's context, so they're cancelled when the
+// deadline passes or the client goes away. Errors from h are caught with the
+// deadline's context, so errorMessage can tell when it passed.
+func withTimeout(h http.Handler) http.Handler {
+	if *requestTimeout <= 0 {
+		return h
+	}
+	caught := whfatal.Catch(h)
+	return whroute.HandlerFunc(h,
+		func(w http.ResponseWriter, r *http.Request) {
+			ctx, cancel := context.WithTimeout(whcompat.Context(r),
+				*requestTimeout)
+			defer cancel()
+			caught.ServeHTTP(w, whcompat.WithContext(r, ctx))
+		})
+}
+
+// errorMessage picks the status and message to show for an error. Errors
+// from queries cut short by the request deadline become a 504 saying so,
+// and server errors without an HTTP status are logged but not shown, since
+// they may leak internals. ok is false if the client went away, so there's
+// no one to show anything to.
+func errorMessage(r *http.Request, err error) (code int, message string,
+	ok bool) {
+	switch whcompat.Context(r).Err() {
+	case context.DeadlineExceeded:
+		err = wherr.GatewayTimeout.New("the query took longer than %v and was "+
+			"cancelled. try a smaller page or fewer filters", *requestTimeout)
+	case context.Canceled:
+		whlog.Default("request cancelled: %s", r.URL)
+		return 0, "", false
+	}
+
+	code = wherr.HTTPCode(err)
+	message = err.Error()
+	if code >= 500 && !wherr.HTTPError.Contains(err) {
+		whlog.Default("error: %s: %v", r.URL, err)
+		message = "an unexpected error occurred"
+	}
+	return code, message, true
+}
+
+// handleError renders an error page.
+func handleError(w http.ResponseWriter, r *http.Request, err error) {
+	code, message, ok := errorMessage(r, err)
+	if !ok {
+		return
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.WriteHeader(code)
+	tmpl.T.Render(w, r, "error", PageCtx{Page: map[string]interface{}{
+		"code":    code,
+		"status":  http.StatusText(code),
+		"message": message,
+	}})
+}
@@ -0,0 +1,157 @@
+// Copyright (C) 2017 JT Olds
+// See LICENSE for copying information
+
+// Package wire defines the json types of the web server's /api/v1/ api,
+// which are shared by the server and the client package.
+package wire
+
+import (
+	"github.com/jtolds/golincs/web/dbs"
+)
+
+// Error is the body of every api error response, under an "error" key.
+type Error struct {
+	Code    int    `json:"code"`
+	Status  string `json:"status"`
+	Message string `json:"message"`
+}
+
+// ErrorBody wraps an Error the way the server sends it.
+type ErrorBody struct {
+	Error Error `json:"error"`
+}
+
+type Dataset struct {
+	Id             int      `json:"id"`
+	Name           string   `json:"name"`
+	Dimensions     int      `json:"dimensions"`
+	DimMax         float64  `json:"dim_max"`
+	SampleTagNames []string `json:"sample_tag_names"`
+	Samples        int      `json:"samples"`
+	GeneSigs       int      `json:"genesigs"`
+	Genesets       int      `json:"genesets"`
+}
+
+// Sample, GeneSig and Geneset are dataset entries. Score is set if the entry
+// is a search result.
+type Sample struct {
+	Id    string            `json:"id"`
+	Name  string            `json:"name"`
+	Tags  map[string]string `json:"tags"`
+	Score *float64          `json:"score,omitempty"`
+}
+
+type GeneSig struct {
+	Id    string   `json:"id"`
+	Name  string   `json:"name"`
+	Score *float64 `json:"score,omitempty"`
+}
+
+type Geneset struct {
+	Id          string   `json:"id"`
+	Name        string   `json:"name"`
+	Description string   `json:"description"`
+	Genes       []string `json:"genes"`
+	Score       *float64 `json:"score,omitempty"`
+}
+
+type Dimension struct {
+	Name  string  `json:"name"`
+	Value float64 `json:"value"`
+}
+
+type Gene struct {
+	Name   string  `json:"name"`
+	Weight float64 `json:"weight"`
+}
+
+// List is a page of entries or search results. Results is a list of Sample,
+// GeneSig or Geneset. Total is -1 if it isn't known. Signature searches also
+// return the search's id and its encoding, which the signature search takes
+// as q to run it again.
+type List struct {
+	QueryId string      `json:"query_id,omitempty"`
+	Query   string      `json:"query,omitempty"`
+	Rtype   string      `json:"rtype,omitempty"`
+	Offset  int         `json:"offset"`
+	Limit   int         `json:"limit"`
+	Total   int         `json:"total"`
+	Results interface{} `json:"results"`
+}
+
+type Neighbors struct {
+	Similar  interface{} `json:"similar"`
+	Opposite interface{} `json:"opposite"`
+}
+
+// NearestRequest is the body of a nearest search, which ranks rtype entries
+// by similarity to Dims. Filters are key=value sample tag filters, like a
+// signature search's.
+type NearestRequest struct {
+	Rtype      string      `json:"rtype"`
+	Dims       []Dimension `json:"dims"`
+	Filters    []string    `json:"filters,omitempty"`
+	Exact      bool        `json:"exact,omitempty"`
+	Candidates int         `json:"candidates,omitempty"`
+	Offset     int         `json:"offset"`
+	Limit      int         `json:"limit"`
+}
+
+// MaxBatchQueries bounds how many queries a BatchRequest may have, and
+// MaxBatchResults how many results it may ask for across all of them.
+// Larger batches should be split up, or submitted as a batch job.
+const (
+	MaxBatchQueries = 256
+	MaxBatchResults = 100000
+)
+
+// BatchRequest is the body of a batch nearest search, which finds the best
+// Limit rtype entries for each query. Like a nearest search, the server
+// picks a default Limit if it's not positive.
+type BatchRequest struct {
+	Rtype   string        `json:"rtype"`
+	Queries [][]Dimension `json:"queries"`
+	Limit   int           `json:"limit"`
+}
+
+// BatchResults has a list of results per query of a BatchRequest, in the
+// same order.
+type BatchResults struct {
+	Results interface{} `json:"results"`
+}
+
+type CombineRequest struct {
+	Genes []Gene `json:"genes"`
+}
+
+func FromDimensions(dims []dbs.Dimension) []Dimension {
+	rv := make([]Dimension, 0, len(dims))
+	for _, dim := range dims {
+		rv = append(rv, Dimension{Name: dim.Name, Value: dim.Value})
+	}
+	return rv
+}
+
+func ToDimensions(dims []Dimension) []dbs.Dimension {
+	rv := make([]dbs.Dimension, 0, len(dims))
+	for _, dim := range dims {
+		rv = append(rv, dbs.Dimension{Name: dim.Name, Value: dim.Value})
+	}
+	return rv
+}
+
+func FromGenes(genes []dbs.Gene) []Gene {
+	rv := make([]Gene, 0, len(genes))
+	for _, gene := range genes {
+		rv = append(rv, Gene{Name: gene.Name, Weight: gene.Weight})
+	}
+	return rv
+}
+
+func ToGenes(genes []Gene) []dbs.Gene {
+	rv := make([]dbs.Gene, 0, len(genes))
+	for _, gene := range genes {
+		rv = append(rv, dbs.Gene{Name: gene.Name, Weight: gene.Weight})
+	}
+	return rv
+}